@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"hot-coffee/internal/check"
+)
+
+// runCheck implements "hot-coffee check" and returns the exit code: 0 when
+// the data is clean or fully repaired, 1 when problems remain.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	dir := fs.String("dir", "data", "Path to the data directory")
+	fix := fs.Bool("fix", false, "Apply the safe repairs; stop the server first")
+	dryRun := fs.Bool("dry-run", false, "Print the repairs --fix would make as a diff without writing them")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	res := check.Run(*dir)
+	for _, p := range res.Problems {
+		fmt.Println(p)
+	}
+	fixable := res.Fixable()
+	fmt.Printf("%d problem(s), %d fixable\n", len(res.Problems), fixable)
+
+	fixed := false
+	switch {
+	case len(res.Changes) == 0:
+	case *dryRun:
+		fmt.Println()
+		res.WriteDiff(os.Stdout)
+	case *fix:
+		if err := res.Apply(); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+		fmt.Printf("Repaired %d record(s)\n", len(res.Changes))
+		fixed = true
+	}
+	return res.ExitCode(fixed)
+}
+
+// validateEdit rejects a hand edit of a watched data file that introduces
+// problems hot-coffee check would report. The other files are read from
+// dir as they are stored.
+func validateEdit(dir string) func(file string, previous, raw []byte) error {
+	return func(file string, previous, raw []byte) error {
+		problems := check.Edit(dir, file, previous, raw)
+		if len(problems) == 0 {
+			return nil
+		}
+		msg := fmt.Sprintf("%d new problem(s): %s", len(problems), problems[0])
+		if len(problems) > 1 {
+			msg += fmt.Sprintf(" and %d more", len(problems)-1)
+		}
+		return errors.New(msg)
+	}
+}
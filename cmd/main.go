@@ -1,24 +1,55 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"hot-coffee/internal/handler"
+	"hot-coffee/internal/metrics"
+	"hot-coffee/internal/migrate"
 	"hot-coffee/internal/repository"
 	"hot-coffee/internal/service"
+	"hot-coffee/models"
 )
 
 func main() {
 	jsonHandler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})
 	slog.SetDefault(slog.New(jsonHandler))
 
+	if len(os.Args) > 1 && os.Args[1] == "user" {
+		os.Exit(runUser(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheck(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
 	port := flag.String("port", ":4000", "HTTP network address")
 	dir := flag.String("dir", "data", "Path to the directory")
+	requestTimeout := flag.Duration("request-timeout", 10*time.Second, "Time limit for a request (0 disables it)")
+	reportTimeout := flag.Duration("report-timeout", time.Minute, "Time limit for reports and imports (0 disables it)")
+	maxBody := flag.Int64("max-body", 1<<20, "Maximum request body size in bytes (0 disables it)")
+	maxImportBody := flag.Int64("max-import-body", 32<<20, "Maximum body size of bulk imports in bytes (0 disables it)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "Time allowed for in-flight requests to finish on shutdown")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file; serves HTTPS together with --tls-key")
+	tlsKey := flag.String("tls-key", "", "TLS private key file")
+	fixturesDir := flag.String("fixtures-dir", "", "Directory with data files that DELETE /reset?fixtures=true reseeds from")
+	autoMigrate := flag.Bool("auto-migrate", true, "Upgrade older data files on startup (after taking a snapshot)")
+	eventBuffer := flag.Int("event-buffer", 1000, "Number of recent order events kept for resuming /orders/stream")
+	watchInterval := flag.Duration("watch-interval", 2*time.Second, "Poll data files for external edits and reload valid ones (0 disables it)")
+	requireIfMatch := flag.Bool("require-if-match", false, "Reject PUT, PATCH and DELETE of orders, menu, inventory and customers without If-Match (428)")
+	publicMetrics := flag.Bool("public-metrics", false, "Serve /metrics without an API key instead of requiring the manager role")
+	noAuth := flag.Bool("no-auth", false, "Disable API key authentication (development only)")
 	help := flag.Bool("help", false, "Print usage information")
 	flag.Parse()
 
@@ -30,47 +61,221 @@ func main() {
 	if *dir == "" {
 		log.Fatal("You must specify a directory with -dir")
 	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("--tls-cert and --tls-key must be given together")
+	}
+
+	slog.Info("Starting Hot-Coffee", "port", *port, "dataDir", *dir, "requestTimeout", *requestTimeout, "reportTimeout", *reportTimeout)
 
-	slog.Info("Starting Hot-Coffee", "port", *port, "dataDir", *dir)
+	if err := migrateOnStart(*dir, *autoMigrate); err != nil {
+		log.Fatalf("Data schema: %v", err)
+	}
 
 	// Data Access Layer
 	orderRepo := repository.NewJSONOrderRepo(*dir)
 	menuRepo := repository.NewJSONMenuRepo(*dir)
 	invRepo := repository.NewJSONInventoryRepo(*dir)
+	userRepo := repository.NewJSONUserRepo(*dir)
+	webhookRepo := repository.NewJSONWebhookRepo(*dir)
+	customerRepo := repository.NewJSONCustomerRepo(*dir)
+	snapshotRepo := repository.NewJSONSnapshotRepo(*dir, orderRepo, menuRepo, invRepo, customerRepo, migrate.Upgrade)
 
 	// // Service layer
-	orderSvc := service.NewOrderService(orderRepo, menuRepo, invRepo)
-	menuSvc := service.NewMenuService(menuRepo, invRepo)
-	invSvc := service.NewInventoryService(invRepo)
+	webhookSvc := service.NewWebhookService(webhookRepo, service.WebhookOptions{})
+	orderEvents := service.NewOrderEvents(*eventBuffer)
+	orderEvents.Listen(webhookSvc.OrderEvent)
+	orderSvc := service.NewOrderService(orderRepo, menuRepo, invRepo, customerRepo, orderEvents, webhookSvc)
+	menuSvc := service.NewMenuService(menuRepo, invRepo, orderRepo, orderSvc)
+	invSvc := service.NewInventoryService(invRepo, menuRepo, webhookSvc)
+	customerSvc := service.NewCustomerService(customerRepo, orderRepo, menuRepo)
+	userSvc := service.NewUserService(userRepo)
+	snapshotSvc := service.NewSnapshotService(snapshotRepo)
+	resetSvc := service.NewResetService(snapshotRepo, *fixturesDir)
+
+	if *noAuth {
+		slog.Warn("Authentication disabled; every route is public")
+	} else if users, err := userSvc.ListUsers(context.Background()); err != nil {
+		log.Fatalf("Loading users: %v", err)
+	} else if len(users) == 0 {
+		slog.Warn("No users configured; create one with: hot-coffee user add --dir " + *dir + " --name <name> --role admin")
+	}
+
+	metrics.NewGaugeFunc("hotcoffee_inventory_quantity",
+		"Current stock of each inventory item, in its unit.",
+		[]string{"ingredient_id", "name", "unit"},
+		func(ctx context.Context, emit func(v float64, labelValues ...string)) error {
+			items, err := invSvc.GetAllInventoryItem(ctx)
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				emit(item.Quantity, item.IngredientID, item.Name, item.Unit)
+			}
+			return nil
+		})
 
 	// // Handler layer
 	orderHandler := handler.NewOrderHandler(orderSvc)
 	menuHandler := handler.NewMenuHandler(menuSvc)
 	invHandler := handler.NewInventoryHandler(invSvc)
-	adminHandler := handler.NewAdminHandler(*dir)
+	adminHandler := handler.NewAdminHandler(snapshotSvc, resetSvc)
+	webhookHandler := handler.NewWebhookHandler(webhookSvc)
+	customerHandler := handler.NewCustomerHandler(customerSvc)
+	healthHandler := handler.NewHealthHandler(*dir)
+	authenticator := handler.NewAuthenticator(userSvc, !*noAuth)
 
 	mux := http.NewServeMux()
+	// Regular routes get the request timeout and body limit; reports and
+	// imports get the larger bulk limits.
+	std := routeLimits{timeout: *requestTimeout, maxBody: *maxBody}
+	bulk := routeLimits{timeout: *reportTimeout, maxBody: *maxImportBody}
+	// Event streams stay open until the client leaves.
+	stream := routeLimits{maxBody: *maxBody}
+	// Every role may read orders, the menu, inventory and reports; writes
+	// need the role named here.
+	public := handler.Access{}
+	cashier := handler.Access{Read: models.RoleCashier, Write: models.RoleCashier}
+	manager := handler.Access{Read: models.RoleCashier, Write: models.RoleManager}
+	admin := handler.Access{Read: models.RoleAdmin, Write: models.RoleAdmin}
+	handle := func(pattern string, h http.HandlerFunc, limits routeLimits, access handler.Access) {
+		protected := authenticator.Require(access, handler.MaxBytes(limits.maxBody, h))
+		mux.Handle(pattern, handler.Instrument(pattern, handler.Timeout(limits.timeout, protected)))
+	}
+
+	// Routes of versioned records; with --require-if-match their writes
+	// must name the version they replace.
+	versioned := func(h http.HandlerFunc) http.HandlerFunc {
+		if *requireIfMatch {
+			return handler.RequireIfMatch(h)
+		}
+		return h
+	}
+
+	// Cashiers take, change and close orders; deleting one erases it from
+	// the sales history and needs a manager.
+	orders := cashier
+	orders.Delete = models.RoleManager
+
+	handle("/orders", orderHandler.Orders, std, cashier)               // GET/POST /orders
+	handle("/orders/", versioned(orderHandler.OrderByID), std, orders) // GET/PUT/PATCH/DELETE /orders/{id}, POST /orders/{id}/close
+	// Browser EventSource clients cannot set headers and send the key as
+	// ?access_token= instead.
+	streamAccess := cashier
+	streamAccess.QueryKey = true
+	handle("/orders/stream", orderHandler.Stream, stream, streamAccess)
+	handle("/stations", orderHandler.Stations, std, cashier)
+	handle("/stations/", orderHandler.StationQueue, std, cashier) // GET /stations/{station}/queue
+
+	handle("/customers", customerHandler.Customers, std, manager)
+	handle("/customers/", versioned(customerHandler.CustomerByID), std, manager) // GET/PUT/PATCH/DELETE /customers/{id}, GET /customers/{id}/orders
 
-	mux.HandleFunc("/orders", orderHandler.Orders)     // GET/POST /orders
-	mux.HandleFunc("/orders/", orderHandler.OrderByID) // GET/PUT/DELETE /orders/{id}
+	handle("/menu", menuHandler.Menu, std, manager)
+	handle("/menu/", versioned(menuHandler.MenuByID), std, manager)
+	handle("/menu/import", menuHandler.Import, bulk, admin)
 
-	mux.HandleFunc("/menu", menuHandler.Menu)
-	mux.HandleFunc("/menu/", menuHandler.MenuByID)
+	handle("/inventory", invHandler.Inventory, std, manager)
+	handle("/inventory/", versioned(invHandler.InventoryByID), std, manager)
+	handle("/inventory/import", invHandler.Import, bulk, admin)
 
-	mux.HandleFunc("/inventory", invHandler.Inventory)
-	mux.HandleFunc("/inventory/", invHandler.InventoryByID)
+	handle("/reports/total-sales", orderHandler.GetTotalSales, bulk, cashier)
+	handle("/reports/popular-items", orderHandler.GetPopularMenuItems, bulk, cashier)
+	handle("/reports/ingredient-consumption", orderHandler.GetIngredientConsumption, bulk, cashier)
+	handle("/reports/inventory-forecast", orderHandler.GetInventoryForecast, bulk, cashier)
 
-	mux.HandleFunc("/reports/total-sales", orderHandler.GetTotalSales)
-	mux.HandleFunc("/reports/popular-items", orderHandler.GetPopularMenuItems)
+	handle("/reset", adminHandler.Reset, std, admin)
+	handle("/admin/snapshots", adminHandler.Snapshots, bulk, admin)
+	handle("/admin/snapshots/", adminHandler.SnapshotByID, bulk, admin)
+	handle("/webhooks", webhookHandler.Webhooks, std, admin)
+	handle("/webhooks/", webhookHandler.WebhookByID, std, admin) // GET/DELETE /webhooks/{id}, GET /webhooks/{id}/deliveries
 
-	mux.HandleFunc("/reset", adminHandler.ResetAll)
+	handle("/healthz", healthHandler.Healthz, std, public)
+	handle("/readyz", healthHandler.Readyz, std, public)
+	handle("/version", healthHandler.Version, std, public)
+	// Metrics reveal stock levels and traffic; scrapers need a manager key
+	// unless they are made public explicitly.
+	metricsAccess := handler.Access{Read: models.RoleManager, Write: models.RoleManager}
+	if *publicMetrics {
+		metricsAccess = public
+	}
+	handle("/metrics", handler.Metrics, std, metricsAccess)
+
+	srv := &http.Server{
+		Addr:              *port,
+		Handler:           handler.RequestLogger(mux),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      writeTimeout(*requestTimeout, *reportTimeout),
+		IdleTimeout:       2 * time.Minute,
+	}
+	srv.RegisterOnShutdown(orderEvents.Close)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *watchInterval > 0 {
+		if err := repository.Watch(ctx, *watchInterval, validateEdit(*dir), orderRepo, menuRepo, invRepo, customerRepo); err != nil {
+			log.Fatalf("Failed to watch data files: %v", err)
+		}
+	}
+
+	// Deliveries still pending at shutdown are sent after the next start.
+	go webhookSvc.Run(ctx)
+
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("Listening", "address", *port, "tls", *tlsCert != "")
+		if *tlsCert != "" {
+			errCh <- srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+		} else {
+			errCh <- srv.ListenAndServe()
+		}
+	}()
 
-	slog.Info("Listening", "address", *port)
-	err := http.ListenAndServe(*port, mux)
-	if err != nil {
-		slog.Error("Server failed", err)
+	select {
+	case err := <-errCh:
+		slog.Error("Server failed", "err", err)
 		os.Exit(1)
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("Shutting down", "timeout", *shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Shutdown did not drain all requests", "err", err)
+	}
+	for name, repo := range map[string]interface{ Flush() error }{
+		"orders":    orderRepo,
+		"menu":      menuRepo,
+		"inventory": invRepo,
+		"webhooks":  webhookRepo,
+		"customers": customerRepo,
+	} {
+		if err := repo.Flush(); err != nil {
+			slog.Error("Flush failed", "repository", name, "err", err)
+		}
+	}
+	slog.Info("Stopped")
+}
+
+// routeLimits bounds the time and request body size of a route.
+type routeLimits struct {
+	timeout time.Duration
+	maxBody int64
+}
+
+// writeTimeout leaves the slowest route enough time to write its response
+// after its own deadline; it is disabled when any route runs unbounded.
+func writeTimeout(timeouts ...time.Duration) time.Duration {
+	longest := time.Duration(0)
+	for _, t := range timeouts {
+		if t <= 0 {
+			return 0
+		}
+		longest = max(longest, t)
 	}
+	return longest + 10*time.Second
 }
 
 func printUsage() {
@@ -78,12 +283,50 @@ func printUsage() {
 Coffee Shop Management System
 
 Usage:
-  hot-coffee [--port <N>] [--dir <S>] 
+  hot-coffee [--port <N>] [--dir <S>] [--request-timeout <D>] [--report-timeout <D>]
+             [--max-body <N>] [--max-import-body <N>] [--shutdown-timeout <D>]
+             [--tls-cert <S> --tls-key <S>] [--fixtures-dir <S>] [--auto-migrate=false]
+             [--event-buffer <N>] [--watch-interval <D>] [--require-if-match]
+             [--public-metrics] [--no-auth]
+  hot-coffee user add --name <S> --role <cashier|manager|admin> [--dir <S>]
+  hot-coffee user list [--dir <S>]
+  hot-coffee check [--dir <S>] [--fix] [--dry-run]
+  hot-coffee migrate [--dir <S>] [--dry-run]
   hot-coffee --help
 
 Options:
   --help       Show this screen.
   --port N     Port number.
   --dir S      Path to the data directory.
+  --request-timeout D
+               Time limit for a request, e.g. 10s (default 10s, 0 disables it).
+  --report-timeout D
+               Time limit for reports and imports (default 1m, 0 disables it).
+  --max-body N Maximum request body size in bytes (default 1048576).
+  --max-import-body N
+               Maximum body size of bulk imports in bytes (default 33554432).
+  --shutdown-timeout D
+               Time allowed for in-flight requests on shutdown (default 30s).
+  --tls-cert S, --tls-key S
+               Certificate and key files; when set the server speaks HTTPS.
+  --fixtures-dir S
+               Data files that DELETE /reset?fixtures=true copies in.
+  --auto-migrate
+               Upgrade older data files on startup (default true); with
+               false, outdated data stops the server.
+  --event-buffer N
+               Recent order events kept for resuming /orders/stream
+               (default 1000).
+  --watch-interval D
+               How often to check data files for external edits (default 2s,
+               0 disables it); valid edits are reloaded, invalid ones logged.
+  --require-if-match
+               Reject PUT, PATCH and DELETE of versioned records without an
+               If-Match header (428). Off by default: such writes are applied
+               without a version check.
+  --public-metrics
+               Serve /metrics without an API key; by default it needs the
+               manager role.
+  --no-auth    Serve every route without API keys (development only).
 `)
 }
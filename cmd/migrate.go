@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"hot-coffee/internal/migrate"
+	"hot-coffee/internal/repository"
+)
+
+// runMigrate implements "hot-coffee migrate" and returns the exit code.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dir := fs.String("dir", "data", "Path to the data directory")
+	dryRun := fs.Bool("dry-run", false, "List the pending migrations without applying them")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	// Keep stdout for the command's output.
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	version, steps, err := migrate.Pending(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	fmt.Printf("Schema version %d, this binary uses %d\n", version, migrate.Current)
+	for _, m := range steps {
+		fmt.Printf("  %d: %s\n", m.Version, m.Name)
+	}
+	if len(steps) == 0 || *dryRun {
+		return 0
+	}
+	snap, err := upgradeData(context.Background(), *dir, steps)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	fmt.Printf("Migrated to version %d; previous data is in snapshot %s\n", migrate.Current, snap)
+	return 0
+}
+
+// migrateOnStart brings the data directory to the current schema before
+// the server opens it, unless auto is false, in which case outdated data
+// is an error. Data newer than the binary is always an error.
+func migrateOnStart(dir string, auto bool) error {
+	version, steps, err := migrate.Pending(dir)
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+	if !auto {
+		return fmt.Errorf("data schema version %d is older than %d; run hot-coffee migrate --dir %s", version, migrate.Current, dir)
+	}
+	snap, err := upgradeData(context.Background(), dir, steps)
+	if err != nil {
+		return err
+	}
+	slog.Info("Migrated data", "from", version, "to", migrate.Current, "snapshot", snap)
+	return nil
+}
+
+// upgradeData snapshots the data directory and applies steps, returning
+// the snapshot ID.
+func upgradeData(ctx context.Context, dir string, steps []migrate.Migration) (string, error) {
+	snapshots := repository.NewJSONSnapshotRepo(dir,
+		repository.NewJSONOrderRepo(dir), repository.NewJSONMenuRepo(dir), repository.NewJSONInventoryRepo(dir),
+		repository.NewJSONCustomerRepo(dir), migrate.Upgrade)
+	snap, err := snapshots.Create(ctx, "pre-migrate")
+	if err != nil {
+		return "", fmt.Errorf("snapshot before migrating: %w", err)
+	}
+	if err := migrate.Apply(dir, steps); err != nil {
+		return snap.ID, err
+	}
+	return snap.ID, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"hot-coffee/internal/repository"
+	"hot-coffee/internal/service"
+	"hot-coffee/models"
+)
+
+// runUser implements "hot-coffee user add|list" and returns the exit code.
+func runUser(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: hot-coffee user add|list [options]")
+		return 2
+	}
+	fs := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	dir := fs.String("dir", "data", "Path to the data directory")
+	name := fs.String("name", "", "User name")
+	role := fs.String("role", "", "Role: cashier, manager or admin")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	// Keep stdout for the command's output.
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	ctx := context.Background()
+	svc := service.NewUserService(repository.NewJSONUserRepo(*dir))
+	switch args[0] {
+	case "add":
+		key, err := svc.CreateUser(ctx, *name, models.Role(*role))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+		fmt.Printf("Created %s (%s). API key, shown only once:\n%s\n", *name, *role, key)
+	case "list":
+		users, err := svc.ListUsers(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+		for _, u := range users {
+			fmt.Printf("%-20s %-8s %s\n", u.Name, u.Role, u.CreatedAt)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown user command %q\n", args[0])
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,480 @@
+// Package check verifies a data directory offline. It loads the data files
+// as stored, reports every referential and validation problem with its file
+// and record location, and works out the repairs that lose no data.
+package check
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"hot-coffee/internal/repository"
+	"hot-coffee/models"
+)
+
+const (
+	ordersFile    = "orders.json"
+	menuFile      = "menu_items.json"
+	inventoryFile = "inventory.json"
+	customersFile = "customers.json"
+)
+
+// fileRank orders problems and changes the way the files are listed above.
+var fileRank = map[string]int{ordersFile: 0, menuFile: 1, inventoryFile: 2, customersFile: 3}
+
+// Problem is a single finding. Index is the record's position in the file's
+// array, or -1 when the file as a whole is unusable.
+type Problem struct {
+	File    string
+	Index   int
+	ID      string
+	Field   string
+	Message string
+	// Fix describes the repair Apply makes; empty when there is no safe one.
+	Fix string
+}
+
+func (p Problem) String() string {
+	loc := p.File
+	if p.Index >= 0 {
+		loc += "[" + strconv.Itoa(p.Index) + "]"
+	}
+	if p.ID != "" {
+		loc += " " + strconv.Quote(p.ID)
+	}
+	if p.Field != "" {
+		loc += " " + p.Field
+	}
+	s := loc + ": " + p.Message
+	if p.Fix != "" {
+		s += " (fix: " + p.Fix + ")"
+	}
+	return s
+}
+
+// Change is a record the repairs rewrite, as compact JSON. After is nil for
+// a record that is removed.
+type Change struct {
+	File   string
+	Index  int
+	ID     string
+	Before []byte
+	After  []byte
+}
+
+// Result holds what Run found and the repaired files.
+type Result struct {
+	Problems []Problem
+	Changes  []Change
+
+	dir      string
+	repaired map[string]any
+}
+
+// Fixable counts the problems Apply repairs.
+func (r *Result) Fixable() int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Fix != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// table is one loaded data file. items is a copy of orig that the repairs
+// edit in place, so positions keep matching the stored file; removed
+// records are only marked in dropped.
+type table[T any] struct {
+	file    string
+	orig    []T
+	items   []T
+	dropped map[int]bool
+	loaded  bool
+}
+
+// live calls fn for every record that has not been removed.
+func (t *table[T]) live(fn func(i int, item *T)) {
+	for i := range t.items {
+		if !t.dropped[i] {
+			fn(i, &t.items[i])
+		}
+	}
+}
+
+func (t *table[T]) result() []T {
+	out := make([]T, 0, len(t.items))
+	for i, item := range t.items {
+		if !t.dropped[i] {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// changes lists the records whose repaired JSON differs from the stored one.
+func (t *table[T]) changes(id func(*T) string) []Change {
+	var changes []Change
+	for i := range t.orig {
+		before, _ := json.Marshal(t.orig[i])
+		var after []byte
+		if !t.dropped[i] {
+			after, _ = json.Marshal(t.items[i])
+			if bytes.Equal(before, after) {
+				continue
+			}
+		}
+		changes = append(changes, Change{File: t.file, Index: i, ID: id(&t.orig[i]), Before: before, After: after})
+	}
+	return changes
+}
+
+type checker struct {
+	problems []Problem
+	// contents replaces the stored content of the files it names.
+	contents map[string][]byte
+}
+
+// read returns the content of file, from contents when it is there.
+func (c *checker) read(dir, file string) ([]byte, error) {
+	if raw, ok := c.contents[file]; ok {
+		return raw, nil
+	}
+	return os.ReadFile(filepath.Join(dir, file))
+}
+
+func (c *checker) report(file string, index int, id, field, message, fix string) {
+	c.problems = append(c.problems, Problem{File: file, Index: index, ID: id, Field: field, Message: message, Fix: fix})
+}
+
+func load[T any](c *checker, dir, file string) *table[T] {
+	t := &table[T]{file: file, dropped: map[int]bool{}}
+	raw, err := c.read(dir, file)
+	if err != nil {
+		c.report(file, -1, "", "", err.Error(), "")
+		return t
+	}
+	if err := json.Unmarshal(raw, &t.orig); err != nil {
+		c.report(file, -1, "", "", "invalid JSON: "+err.Error(), "")
+		return t
+	}
+	t.items = append([]T(nil), t.orig...)
+	t.loaded = true
+	return t
+}
+
+// ExitCode is the status hot-coffee check exits with: 0 when no problem is
+// left, 1 otherwise. fixed tells whether Apply has repaired the fixable ones.
+func (r *Result) ExitCode(fixed bool) int {
+	remaining := len(r.Problems)
+	if fixed {
+		remaining -= r.Fixable()
+	}
+	if remaining > 0 {
+		return 1
+	}
+	return 0
+}
+
+// dedupe reports records whose ID repeats an earlier one. The server only
+// ever finds the first, so exact copies are removed and the others renamed
+// to a free "<id>-dup<n>", which keeps their data reachable.
+func dedupe[T any](c *checker, t *table[T], field string, id func(*T) *string) {
+	taken := map[string]bool{}
+	t.live(func(_ int, item *T) { taken[*id(item)] = true })
+	seen := map[string]int{}
+	t.live(func(i int, item *T) {
+		key := *id(item)
+		if key == "" {
+			return
+		}
+		j, dup := seen[key]
+		if !dup {
+			seen[key] = i
+			return
+		}
+		where := fmt.Sprintf("duplicate %s, first used at %s[%d]", field, t.file, j)
+		a, _ := json.Marshal(t.items[j])
+		b, _ := json.Marshal(*item)
+		if bytes.Equal(a, b) {
+			c.report(t.file, i, key, field, where+" with identical content", "remove the copy")
+			t.dropped[i] = true
+			return
+		}
+		renamed := key
+		for n := 1; ; n++ {
+			renamed = key + "-dup" + strconv.Itoa(n)
+			if !taken[renamed] {
+				break
+			}
+		}
+		taken[renamed] = true
+		c.report(t.file, i, key, field, where, "rename to "+renamed)
+		*id(item) = renamed
+	})
+}
+
+// uniqueNames reports names used by more than one record; the repositories
+// refuse to create such records but cannot pick a winner either.
+func uniqueNames[T any](c *checker, t *table[T], id, name func(*T) string) {
+	seen := map[string]int{}
+	t.live(func(i int, item *T) {
+		n := name(item)
+		if n == "" {
+			return
+		}
+		if j, ok := seen[n]; ok {
+			c.report(t.file, i, id(item), "name", fmt.Sprintf("duplicate name %q, first used at %s[%d]", n, t.file, j), "")
+			return
+		}
+		seen[n] = i
+	})
+}
+
+// Run loads the data files in dir and checks them. File-level failures are
+// reported as problems; checks that need a file that did not load are
+// skipped.
+func Run(dir string) *Result {
+	return run(dir, nil)
+}
+
+// Edit checks an edit of one data file in dir: it runs the checks with the
+// file's previous content and with the edited one and returns the problems
+// only the edited content has. Problems the data already had are left to
+// hot-coffee check, so they do not block an unrelated edit.
+func Edit(dir, file string, previous, edited []byte) []Problem {
+	before := map[string]int{}
+	for _, p := range run(dir, map[string][]byte{file: previous}).Problems {
+		before[p.key()]++
+	}
+	var introduced []Problem
+	for _, p := range run(dir, map[string][]byte{file: edited}).Problems {
+		if before[p.key()] > 0 {
+			before[p.key()]--
+			continue
+		}
+		introduced = append(introduced, p)
+	}
+	return introduced
+}
+
+// key identifies a problem independently of the record's position, which
+// an edit may shift.
+func (p Problem) key() string {
+	return p.File + "|" + p.ID + "|" + p.Field + "|" + p.Message
+}
+
+func run(dir string, contents map[string][]byte) *Result {
+	c := &checker{contents: contents}
+	orders := load[models.Order](c, dir, ordersFile)
+	menu := load[models.MenuItem](c, dir, menuFile)
+	inventory := load[models.InventoryItem](c, dir, inventoryFile)
+	customers, customersLoaded := loadCustomerIDs(c, dir)
+
+	inventoryID := func(item *models.InventoryItem) *string { return &item.IngredientID }
+	menuID := func(item *models.MenuItem) *string { return &item.ID }
+	orderID := func(order *models.Order) *string { return &order.ID }
+
+	// IDs the other files may reference, before any duplicate is renamed.
+	ingredients := map[string]bool{}
+	inventory.live(func(_ int, item *models.InventoryItem) { ingredients[item.IngredientID] = true })
+	products := map[string]bool{}
+	menu.live(func(_ int, item *models.MenuItem) { products[item.ID] = true })
+
+	dedupe(c, inventory, "ingredient_id", inventoryID)
+	dedupe(c, menu, "product_id", menuID)
+	dedupe(c, orders, "order_id", orderID)
+
+	checkInventory(c, inventory)
+	checkMenu(c, menu, ingredients, inventory.loaded)
+	checkOrders(c, orders, products, menu.loaded, customers, customersLoaded)
+
+	res := &Result{dir: dir, repaired: map[string]any{}}
+	sort.SliceStable(c.problems, func(i, j int) bool {
+		a, b := c.problems[i], c.problems[j]
+		if a.File != b.File {
+			return fileRank[a.File] < fileRank[b.File]
+		}
+		return a.Index < b.Index
+	})
+	res.Problems = c.problems
+	if orders.loaded {
+		res.Changes = append(res.Changes, orders.changes(func(o *models.Order) string { return o.ID })...)
+		res.repaired[ordersFile] = orders.result()
+	}
+	if menu.loaded {
+		res.Changes = append(res.Changes, menu.changes(func(m *models.MenuItem) string { return m.ID })...)
+		res.repaired[menuFile] = menu.result()
+	}
+	if inventory.loaded {
+		res.Changes = append(res.Changes, inventory.changes(func(i *models.InventoryItem) string { return i.IngredientID })...)
+		res.repaired[inventoryFile] = inventory.result()
+	}
+	return res
+}
+
+func checkInventory(c *checker, t *table[models.InventoryItem]) {
+	t.live(func(i int, item *models.InventoryItem) {
+		id := item.IngredientID
+		if id == "" {
+			c.report(t.file, i, id, "ingredient_id", "ingredient_id is empty", "")
+		}
+		if item.Name == "" {
+			c.report(t.file, i, id, "name", "name is empty", "")
+		}
+		if item.Unit == "" {
+			c.report(t.file, i, id, "unit", "unit is empty", "")
+		}
+		if item.Quantity < 0 {
+			c.report(t.file, i, id, "quantity", "negative stock "+strconv.FormatFloat(item.Quantity, 'f', -1, 64), "set quantity to 0")
+			item.Quantity = 0
+		}
+	})
+	uniqueNames(c, t, func(item *models.InventoryItem) string { return item.IngredientID }, func(item *models.InventoryItem) string { return item.Name })
+}
+
+func checkMenu(c *checker, t *table[models.MenuItem], ingredients map[string]bool, inventoryLoaded bool) {
+	t.live(func(i int, item *models.MenuItem) {
+		id := item.ID
+		if id == "" {
+			c.report(t.file, i, id, "product_id", "product_id is empty", "")
+		}
+		if item.Name == "" {
+			c.report(t.file, i, id, "name", "name is empty", "")
+		}
+		if item.Price <= 0 {
+			c.report(t.file, i, id, "price", "price must be positive", "")
+		}
+		if len(item.Ingredients) == 0 {
+			c.report(t.file, i, id, "ingredients", "recipe is empty", "")
+		}
+		inRecipe := map[string]bool{}
+		missing := false
+		for j, ingredient := range item.Ingredients {
+			field := fmt.Sprintf("ingredients[%d]", j)
+			if ingredient.Quantity <= 0 {
+				c.report(t.file, i, id, field+".quantity", "ingredient quantity must be positive", "")
+			}
+			if inRecipe[ingredient.IngredientID] {
+				c.report(t.file, i, id, field+".ingredient_id", "ingredient "+ingredient.IngredientID+" listed twice", "")
+			}
+			inRecipe[ingredient.IngredientID] = true
+			if inventoryLoaded && !ingredients[ingredient.IngredientID] {
+				fix := ""
+				if !item.Archived {
+					fix = "archive the menu item"
+				}
+				c.report(t.file, i, id, field+".ingredient_id", "ingredient "+ingredient.IngredientID+" not found in "+inventoryFile, fix)
+				missing = true
+			}
+		}
+		// Such a product cannot be ordered anyway; archiving says so while
+		// keeping it resolvable for past orders.
+		if missing {
+			item.Archived = true
+		}
+	})
+	uniqueNames(c, t, func(item *models.MenuItem) string { return item.ID }, func(item *models.MenuItem) string { return item.Name })
+}
+
+// loadCustomerIDs reads the IDs orders may link to. customers.json is only
+// referenced, never repaired; a missing file means there are no customers.
+func loadCustomerIDs(c *checker, dir string) (map[string]bool, bool) {
+	ids := map[string]bool{}
+	raw, err := c.read(dir, customersFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ids, true
+	}
+	if err != nil {
+		c.report(customersFile, -1, "", "", err.Error(), "")
+		return ids, false
+	}
+	var customers []models.Customer
+	if err := json.Unmarshal(raw, &customers); err != nil {
+		c.report(customersFile, -1, "", "", "invalid JSON: "+err.Error(), "")
+		return ids, false
+	}
+	for _, customer := range customers {
+		ids[customer.ID] = true
+	}
+	return ids, true
+}
+
+func checkOrders(c *checker, t *table[models.Order], products map[string]bool, menuLoaded bool, customers map[string]bool, customersLoaded bool) {
+	t.live(func(i int, order *models.Order) {
+		id := order.ID
+		if id == "" {
+			c.report(t.file, i, id, "order_id", "order_id is empty", "")
+		}
+		if order.CustomerName == "" {
+			c.report(t.file, i, id, "customer_name", "customer_name is empty", "")
+		}
+		// The order keeps its customer name, so it can fall back to a
+		// walk-in order.
+		if customersLoaded && order.CustomerID != "" && !customers[order.CustomerID] {
+			c.report(t.file, i, id, "customer_id", "customer "+order.CustomerID+" not found in "+customersFile, "unlink the customer")
+			order.CustomerID = ""
+		}
+		if order.Status != "open" && order.Status != "ready" && order.Status != "closed" {
+			c.report(t.file, i, id, "status", fmt.Sprintf("unknown status %q", order.Status), "")
+		}
+		if _, err := time.Parse(time.RFC3339, order.CreatedAt); err != nil {
+			c.report(t.file, i, id, "created_at", fmt.Sprintf("invalid timestamp %q", order.CreatedAt), "")
+		}
+		if len(order.Items) == 0 {
+			c.report(t.file, i, id, "items", "order has no items", "")
+		}
+		for j, line := range order.Items {
+			field := fmt.Sprintf("items[%d]", j)
+			if line.Quantity <= 0 {
+				c.report(t.file, i, id, field+".quantity", "quantity must be positive", "")
+			}
+			// Lines stored before line statuses have none until migrated.
+			if line.Status != "" && line.Status != models.LinePending && line.Status != models.LineDone {
+				c.report(t.file, i, id, field+".status", fmt.Sprintf("unknown line status %q", line.Status), "")
+			}
+			if menuLoaded && !products[line.ProductID] {
+				c.report(t.file, i, id, field+".product_id", "product "+line.ProductID+" not found in "+menuFile, "")
+			}
+		}
+	})
+}
+
+// WriteDiff prints the repairs record by record: the stored JSON prefixed
+// with "-" and the repaired JSON with "+".
+func (r *Result) WriteDiff(w io.Writer) {
+	file := ""
+	for _, ch := range r.Changes {
+		if ch.File != file {
+			file = ch.File
+			fmt.Fprintf(w, "--- %s\n+++ %s (fixed)\n", file, file)
+		}
+		fmt.Fprintf(w, "@@ [%d] %s @@\n-%s\n", ch.Index, ch.ID, ch.Before)
+		if ch.After != nil {
+			fmt.Fprintf(w, "+%s\n", ch.After)
+		}
+	}
+}
+
+// Apply writes every file with changes. The server must not be running, as
+// it would overwrite the repairs with its own view of the data.
+func (r *Result) Apply() error {
+	changed := map[string]bool{}
+	for _, ch := range r.Changes {
+		changed[ch.File] = true
+	}
+	for file := range changed {
+		if err := repository.WriteJSONFile(filepath.Join(r.dir, file), r.repaired[file]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package check
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hot-coffee/models"
+)
+
+func writeFile(t *testing.T, dir, name string, v any) []byte {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestEditReportsOnlyIntroducedProblems(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, inventoryFile, []models.InventoryItem{{IngredientID: "milk", Name: "Milk", Quantity: 1, Unit: "ml"}})
+	// The stored menu already has a problem: the cake has no recipe.
+	menu := []models.MenuItem{
+		{ID: "latte", Name: "Latte", Price: 3, Ingredients: []models.MenuItemIngredient{{IngredientID: "milk", Quantity: 1}}},
+		{ID: "cake", Name: "Cake", Price: 2},
+	}
+	previous := writeFile(t, dir, menuFile, menu)
+	writeFile(t, dir, ordersFile, []models.Order{})
+
+	edited := func(change func(menu []models.MenuItem) []models.MenuItem) []byte {
+		items := change(append([]models.MenuItem(nil), menu...))
+		raw, err := json.Marshal(items)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	tests := []struct {
+		name      string
+		edit      []byte
+		wantField string
+	}{
+		{
+			name: "unrelated fix passes despite the old problem",
+			edit: edited(func(m []models.MenuItem) []models.MenuItem { m[0].Price = 4; return m }),
+		},
+		{
+			name: "inserted record shifting the old problem passes",
+			edit: edited(func(m []models.MenuItem) []models.MenuItem {
+				return append([]models.MenuItem{{ID: "tea", Name: "Tea", Price: 1, Ingredients: []models.MenuItemIngredient{{IngredientID: "milk", Quantity: 1}}}}, m...)
+			}),
+		},
+		{
+			name:      "negative price is reported",
+			edit:      edited(func(m []models.MenuItem) []models.MenuItem { m[0].Price = -1; return m }),
+			wantField: "price",
+		},
+		{
+			name:      "duplicate ID is reported",
+			edit:      edited(func(m []models.MenuItem) []models.MenuItem { m[1].ID = "latte"; return m }),
+			wantField: "product_id",
+		},
+		{
+			name: "unknown ingredient is reported",
+			edit: edited(func(m []models.MenuItem) []models.MenuItem {
+				m[0].Ingredients = []models.MenuItemIngredient{{IngredientID: "oat", Quantity: 1}}
+				return m
+			}),
+			wantField: "ingredients[0].ingredient_id",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := Edit(dir, menuFile, previous, tt.edit)
+			if tt.wantField == "" {
+				if len(problems) != 0 {
+					t.Errorf("Edit() = %v, want no problems", problems)
+				}
+				return
+			}
+			for _, p := range problems {
+				if p.Field == tt.wantField {
+					return
+				}
+			}
+			t.Errorf("Edit() = %v, want a problem in %s", problems, tt.wantField)
+		})
+	}
+}
+
+func TestRunDedupes(t *testing.T) {
+	dir := t.TempDir()
+	milk := models.InventoryItem{IngredientID: "milk", Name: "Milk", Quantity: 1, Unit: "ml"}
+	writeFile(t, dir, inventoryFile, []models.InventoryItem{
+		milk,
+		milk, // exact copy
+		{IngredientID: "milk", Name: "Oat milk", Quantity: 2, Unit: "ml"},
+		{IngredientID: "milk-dup1", Name: "Soy milk", Quantity: 3, Unit: "ml"},
+	})
+	writeFile(t, dir, menuFile, []models.MenuItem{})
+	writeFile(t, dir, ordersFile, []models.Order{})
+
+	res := Run(dir)
+	want := []struct {
+		index int
+		fix   string
+	}{
+		{index: 1, fix: "remove the copy"},
+		// milk-dup1 is taken, so the rename skips it.
+		{index: 2, fix: "rename to milk-dup2"},
+	}
+	if len(res.Problems) != len(want) {
+		t.Fatalf("Run() = %v, want %d problems", res.Problems, len(want))
+	}
+	for i, w := range want {
+		if p := res.Problems[i]; p.Index != w.index || p.Field != "ingredient_id" || p.Fix != w.fix {
+			t.Errorf("problem %d = %v, want index %d with fix %q", i, p, w.index, w.fix)
+		}
+	}
+	if len(res.Changes) != 2 || res.Changes[0].After != nil || !strings.Contains(string(res.Changes[1].After), `"milk-dup2"`) {
+		t.Errorf("Changes = %+v, want the copy removed and the other renamed", res.Changes)
+	}
+}
+
+func TestRepairsAndExitCodes(t *testing.T) {
+	tests := []struct {
+		name      string
+		inventory []models.InventoryItem
+		fix       bool
+		wantDiff  []string
+		wantExit  int
+		// wantAfter are the problems a second Run finds.
+		wantAfter int
+	}{
+		{
+			name:      "clean data",
+			inventory: []models.InventoryItem{{IngredientID: "milk", Name: "Milk", Quantity: 1, Unit: "ml"}},
+			wantExit:  0,
+		},
+		{
+			name:      "dry run shows the repair and writes nothing",
+			inventory: []models.InventoryItem{{IngredientID: "milk", Name: "Milk", Quantity: -5, Unit: "ml"}},
+			wantDiff:  []string{"--- inventory.json\n", `-{"ingredient_id":"milk","name":"Milk","quantity":-5`, `+{"ingredient_id":"milk","name":"Milk","quantity":0`},
+			wantExit:  1,
+			wantAfter: 1,
+		},
+		{
+			name:      "fix repairs everything",
+			inventory: []models.InventoryItem{{IngredientID: "milk", Name: "Milk", Quantity: -5, Unit: "ml"}},
+			fix:       true,
+			wantExit:  0,
+		},
+		{
+			name: "fix leaves a problem without a safe repair",
+			inventory: []models.InventoryItem{
+				{IngredientID: "milk", Name: "Milk", Quantity: -5, Unit: "ml"},
+				{IngredientID: "oat", Name: "Milk", Quantity: 1, Unit: "ml"},
+			},
+			fix:       true,
+			wantExit:  1,
+			wantAfter: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			stored := writeFile(t, dir, inventoryFile, tt.inventory)
+			writeFile(t, dir, menuFile, []models.MenuItem{})
+			writeFile(t, dir, ordersFile, []models.Order{})
+
+			res := Run(dir)
+			var diff strings.Builder
+			res.WriteDiff(&diff)
+			for _, want := range tt.wantDiff {
+				if !strings.Contains(diff.String(), want) {
+					t.Errorf("WriteDiff() = %q, want it to contain %q", diff.String(), want)
+				}
+			}
+			if tt.fix {
+				if err := res.Apply(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := res.ExitCode(tt.fix); got != tt.wantExit {
+				t.Errorf("ExitCode(%v) = %d, want %d", tt.fix, got, tt.wantExit)
+			}
+
+			raw, err := os.ReadFile(filepath.Join(dir, inventoryFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := !bytes.Equal(raw, stored); changed != (tt.fix && len(res.Changes) > 0) {
+				t.Errorf("inventory.json changed = %v, fix %v with %d changes", changed, tt.fix, len(res.Changes))
+			}
+			if after := Run(dir).Problems; len(after) != tt.wantAfter {
+				t.Errorf("second Run() = %v, want %d problems", after, tt.wantAfter)
+			}
+		})
+	}
+}
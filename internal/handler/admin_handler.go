@@ -1,38 +1,121 @@
 package handler
 
 import (
-	"io/ioutil"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
-	"path/filepath"
+	"strconv"
+	"strings"
+
+	"hot-coffee/internal/logging"
+	"hot-coffee/internal/service"
+	"hot-coffee/models"
 )
 
 type AdminHandler struct {
-	dataDir string
+	snapshots service.SnapshotService
+	resets    service.ResetService
 }
 
-func NewAdminHandler(dir string) *AdminHandler {
-	return &AdminHandler{dataDir: dir}
+func NewAdminHandler(snapshots service.SnapshotService, resets service.ResetService) *AdminHandler {
+	return &AdminHandler{snapshots: snapshots, resets: resets}
 }
 
-func (h *AdminHandler) ResetAll(w http.ResponseWriter, r *http.Request) {
+// Reset serves DELETE /reset?collections=orders,menu&fixtures=true. The
+// first request answers 428 with a confirmation token; repeating it with
+// confirm=<token> performs the reset and reports the snapshot taken before.
+func (h *AdminHandler) Reset(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
 	if r.Method != http.MethodDelete {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
 		return
 	}
+	q := r.URL.Query()
+	var req models.ResetRequest
+	if v := q.Get("collections"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			req.Collections = append(req.Collections, strings.TrimSpace(name))
+		}
+	}
+	if v := q.Get("fixtures"); v != "" {
+		fixtures, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, service.NewValidationError("fixtures", "must be true or false"))
+			return
+		}
+		req.Fixtures = fixtures
+	}
 
-	files := []string{
-		filepath.Join(h.dataDir, "orders.json"),
-		filepath.Join(h.dataDir, "menu_items.json"),
-		filepath.Join(h.dataDir, "inventory.json"),
+	result, err := h.resets.Reset(r.Context(), req, q.Get("confirm"))
+	if err != nil {
+		logger.Warn("Reset", slog.Any("error", err))
+		writeError(w, err)
+		return
 	}
+	writeAdminJSON(r, w, http.StatusOK, result)
+}
+
+// Snapshots serves GET /admin/snapshots, listing the snapshots newest
+// first, and POST /admin/snapshots, taking one. The POST body may give a
+// reason: {"reason": "before menu change"}.
+func (h *AdminHandler) Snapshots(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	switch r.Method {
+	case http.MethodGet:
+		snaps, err := h.snapshots.ListSnapshots(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeAdminJSON(r, w, http.StatusOK, snaps)
 
-	for _, path := range files {
-		if err := ioutil.WriteFile(path, []byte("[]"), 0o644); err != nil {
-			http.Error(w, "failed to clear "+filepath.Base(path)+": "+err.Error(),
-				http.StatusInternalServerError)
+	case http.MethodPost:
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			logger.Warn("Snapshots POST decode", slog.Any("error", err))
+			writeDecodeError(w, err)
 			return
 		}
+		snap, err := h.snapshots.CreateSnapshot(r.Context(), body.Reason)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeAdminJSON(r, w, http.StatusCreated, snap)
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+	}
+}
+
+// SnapshotByID serves POST /admin/snapshots/{id}/restore. It answers with
+// the snapshot taken of the data that was replaced.
+func (h *AdminHandler) SnapshotByID(w http.ResponseWriter, r *http.Request) {
+	id, action := splitAction(r.URL.Path, "/admin/snapshots/")
+	if action != "restore" {
+		writeJSONError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
 	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	before, err := h.snapshots.RestoreSnapshot(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeAdminJSON(r, w, http.StatusOK, before)
+}
 
-	w.WriteHeader(http.StatusNoContent)
+func writeAdminJSON(r *http.Request, w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logging.FromContext(r.Context()).Error("Encode admin response failed", slog.Any("error", err))
+	}
 }
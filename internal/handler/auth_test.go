@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"hot-coffee/internal/service"
+	"hot-coffee/models"
+)
+
+// keyUsers authenticates the keys it maps to users.
+type keyUsers struct {
+	service.UserService
+	users map[string]models.User
+}
+
+func (k keyUsers) Authenticate(_ context.Context, key string) (models.User, error) {
+	if user, ok := k.users[key]; ok {
+		return user, nil
+	}
+	return models.User{}, service.ErrUnauthenticated
+}
+
+var testUsers = keyUsers{users: map[string]models.User{
+	"cashier-key": {Name: "cam", Role: models.RoleCashier},
+	"manager-key": {Name: "max", Role: models.RoleManager},
+	"admin-key":   {Name: "ada", Role: models.RoleAdmin},
+}}
+
+// serveAs sends a request through Require(access) with key in the X-API-Key
+// header, unless key is empty, and returns the status.
+func serveAs(access Access, method, target, key string) int {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	r := httptest.NewRequest(method, target, nil)
+	if key != "" {
+		r.Header.Set("X-API-Key", key)
+	}
+	w := httptest.NewRecorder()
+	NewAuthenticator(testUsers, true).Require(access, ok).ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestRequireRoles(t *testing.T) {
+	// The access of /orders/{id} in cmd/main.go.
+	orders := Access{Read: models.RoleCashier, Write: models.RoleCashier, Delete: models.RoleManager}
+	manager := Access{Read: models.RoleCashier, Write: models.RoleManager}
+	tests := []struct {
+		name   string
+		access Access
+		method string
+		target string
+		want   map[string]int
+	}{
+		{name: "read an order", access: orders, method: http.MethodGet, target: "/orders/o1",
+			want: map[string]int{"": 401, "cashier-key": 204, "manager-key": 204, "admin-key": 204}},
+		{name: "update an order", access: orders, method: http.MethodPut, target: "/orders/o1",
+			want: map[string]int{"": 401, "cashier-key": 204, "manager-key": 204, "admin-key": 204}},
+		{name: "patch an order", access: orders, method: http.MethodPatch, target: "/orders/o1",
+			want: map[string]int{"cashier-key": 204, "manager-key": 204, "admin-key": 204}},
+		{name: "close an order", access: orders, method: http.MethodPost, target: "/orders/o1/close",
+			want: map[string]int{"cashier-key": 204, "manager-key": 204, "admin-key": 204}},
+		{name: "delete an order", access: orders, method: http.MethodDelete, target: "/orders/o1",
+			want: map[string]int{"": 401, "cashier-key": 403, "manager-key": 204, "admin-key": 204}},
+		{name: "delete falls back to write", access: manager, method: http.MethodDelete, target: "/menu/latte",
+			want: map[string]int{"cashier-key": 403, "manager-key": 204, "admin-key": 204}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, want := range tt.want {
+				if got := serveAs(tt.access, tt.method, tt.target, key); got != want {
+					t.Errorf("%s %s with key %q = %d, want %d", tt.method, tt.target, key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRequireQueryKey(t *testing.T) {
+	cashier := Access{Read: models.RoleCashier, Write: models.RoleCashier}
+	stream := cashier
+	stream.QueryKey = true
+	tests := []struct {
+		name   string
+		access Access
+		target string
+		header string
+		want   int
+	}{
+		{name: "header key", access: stream, target: "/orders/stream", header: "cashier-key", want: http.StatusNoContent},
+		{name: "query key where allowed", access: stream, target: "/orders/stream?access_token=cashier-key", want: http.StatusNoContent},
+		{name: "unknown query key", access: stream, target: "/orders/stream?access_token=nope", want: http.StatusUnauthorized},
+		{name: "query key elsewhere", access: cashier, target: "/orders?access_token=cashier-key", want: http.StatusUnauthorized},
+		{name: "header wins over query", access: stream, target: "/orders/stream?access_token=cashier-key", header: "nope", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serveAs(tt.access, http.MethodGet, tt.target, tt.header); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactedQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "status=open", want: "status=open"},
+		{query: "access_token=s3cret&status=open", want: "access_token=REDACTED&status=open"},
+		{query: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := redactedQuery(&url.URL{RawQuery: tt.query}); got != tt.want {
+			t.Errorf("redactedQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"hot-coffee/models"
+)
+
+// csvFlushEvery is the number of records written between flushes, so large
+// exports reach the client progressively instead of being buffered whole.
+// The items themselves come from one read of the repository: scanning the
+// file while writing to the client would hold the repository lock for as
+// long as the slowest client takes.
+const csvFlushEvery = 100
+
+// wantsCSV reports whether the client asked for CSV, either with
+// ?format=csv or an Accept header listing text/csv. An explicit format
+// parameter wins over the header. Since the answer may depend on Accept, the
+// response is marked Vary: Accept so caches keep JSON and CSV apart.
+func wantsCSV(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Add("Vary", "Accept")
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "csv":
+		return true
+	case "json":
+		return false
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == "text/csv" {
+			return true
+		}
+	}
+	return false
+}
+
+// csvFormulaPrefixes start cells that spreadsheets evaluate as formulas,
+// following OWASP's CSV injection guidance.
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvSafe keeps a spreadsheet opening the export from running a cell as a
+// formula, e.g. a customer named "=HYPERLINK(...)", by prefixing cells that
+// start like one with a single quote. Plain numbers such as "-2.5" are left
+// as they are. Cells that already start with quotes before such a character
+// get one more, so csvUnescape restores every cell exactly.
+func csvSafe(record []string) []string {
+	for i, cell := range record {
+		if !csvFormulaLike(cell) {
+			continue
+		}
+		if _, err := strconv.ParseFloat(cell, 64); err != nil {
+			record[i] = "'" + cell
+		}
+	}
+	return record
+}
+
+// csvUnescape undoes csvSafe on an imported cell.
+func csvUnescape(cell string) string {
+	if strings.HasPrefix(cell, "'") && csvFormulaLike(cell) {
+		return cell[1:]
+	}
+	return cell
+}
+
+// csvFormulaLike reports whether cell, after any leading quotes, starts
+// with a formula character.
+func csvFormulaLike(cell string) bool {
+	rest := strings.TrimLeft(cell, "'")
+	return rest != "" && strings.ContainsRune(csvFormulaPrefixes, rune(rest[0]))
+}
+
+// writeCSV writes items as CSV under the given header, flushing every
+// csvFlushEvery records. Each item may expand to several records, e.g. one
+// per order line. Cells are passed through csvSafe.
+func writeCSV[T any](w http.ResponseWriter, name string, header []string, items []T, records func(T) [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".csv"))
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		slog.Error("CSV write header failed", slog.String("name", name), slog.Any("error", err))
+		return
+	}
+	written := 0
+	for _, item := range items {
+		for _, record := range records(item) {
+			if err := cw.Write(csvSafe(record)); err != nil {
+				slog.Error("CSV write record failed", slog.String("name", name), slog.Any("error", err))
+				return
+			}
+			written++
+			if written%csvFlushEvery == 0 {
+				cw.Flush()
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		slog.Error("CSV flush failed", slog.String("name", name), slog.Any("error", err))
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// orderCSVHeader only ever gains columns at the end, so spreadsheets built
+// on an older export keep working.
+var orderCSVHeader = []string{"order_id", "customer_name", "status", "created_at", "product_id", "quantity", "item_status", "customer_id", "unit_price"}
+
+func orderCSVRecords(order models.Order) [][]string {
+	if len(order.Items) == 0 {
+		return [][]string{{order.ID, order.CustomerName, order.Status, order.CreatedAt, "", "", "", order.CustomerID, ""}}
+	}
+	records := make([][]string, 0, len(order.Items))
+	for _, item := range order.Items {
+		records = append(records, []string{
+			order.ID, order.CustomerName, order.Status, order.CreatedAt,
+			item.ProductID, strconv.Itoa(item.Quantity), item.Status, order.CustomerID, formatFloat(item.UnitPrice),
+		})
+	}
+	return records
+}
+
+var menuCSVHeader = []string{"product_id", "name", "description", "price", "ingredients", "station"}
+
+// menuCSVRequired are the columns an import must have; station came later
+// and may be left out.
+var menuCSVRequired = menuCSVHeader[:5]
+
+func menuCSVRecords(item models.MenuItem) [][]string {
+	ingredients := make([]string, 0, len(item.Ingredients))
+	for _, ing := range item.Ingredients {
+		ingredients = append(ingredients, ing.IngredientID+"="+formatFloat(ing.Quantity))
+	}
+	return [][]string{{item.ID, item.Name, item.Description, formatFloat(item.Price), strings.Join(ingredients, ";"), item.Station}}
+}
+
+var inventoryCSVHeader = []string{"ingredient_id", "name", "quantity", "unit"}
+
+func inventoryCSVRecords(item models.InventoryItem) [][]string {
+	return [][]string{{item.IngredientID, item.Name, formatFloat(item.Quantity), item.Unit}}
+}
+
+var totalSalesCSVHeader = []string{"total_sales"}
+
+func totalSalesCSVRecords(total models.Total) [][]string {
+	return [][]string{{formatFloat(total.TotalSales)}}
+}
+
+var popularItemCSVHeader = []string{"product_id", "name", "quantity", "revenue", "share_of_sales"}
+
+func popularItemCSVRecords(item models.PopularItem) [][]string {
+	return [][]string{{
+		item.ProductID, item.Name, strconv.Itoa(item.Quantity),
+		formatFloat(item.Revenue), formatFloat(item.ShareOfSales),
+	}}
+}
+
+var consumptionCSVHeader = []string{"ingredient_id", "name", "unit", "total_quantity", "daily_average", "date", "quantity"}
+
+func consumptionCSVRecords(c models.IngredientConsumption) [][]string {
+	records := make([][]string, 0, len(c.Daily))
+	for _, day := range c.Daily {
+		records = append(records, []string{
+			c.IngredientID, c.Name, c.Unit, formatFloat(c.Quantity), formatFloat(c.DailyAverage),
+			day.Date, formatFloat(day.Quantity),
+		})
+	}
+	return records
+}
+
+var forecastCSVHeader = []string{"ingredient_id", "name", "unit", "quantity", "daily_consumption", "days_remaining", "depletion_date"}
+
+func forecastCSVRecords(f models.InventoryForecast) [][]string {
+	daysRemaining := ""
+	if f.DaysRemaining != nil {
+		daysRemaining = formatFloat(*f.DaysRemaining)
+	}
+	return [][]string{{
+		f.IngredientID, f.Name, f.Unit, formatFloat(f.Quantity),
+		formatFloat(f.DailyConsumption), daysRemaining, f.DepletionDate,
+	}}
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"hot-coffee/models"
+)
+
+func TestCSVSafe(t *testing.T) {
+	tests := []struct {
+		cell string
+		want string
+	}{
+		{cell: "Ana", want: "Ana"},
+		{cell: "", want: ""},
+		{cell: "=HYPERLINK(\"http://x\")", want: "'=HYPERLINK(\"http://x\")"},
+		{cell: "+1 555 0100", want: "'+1 555 0100"},
+		{cell: "-cmd", want: "'-cmd"},
+		{cell: "@SUM(A1)", want: "'@SUM(A1)"},
+		{cell: "\tcmd", want: "'\tcmd"},
+		{cell: "\rcmd", want: "'\rcmd"},
+		{cell: "-2.5", want: "-2.5"},
+		{cell: "a=b", want: "a=b"},
+		{cell: "'quoted", want: "'quoted"},
+		{cell: "'=1+1", want: "''=1+1"},
+	}
+	for _, tt := range tests {
+		if got := csvSafe([]string{tt.cell}); got[0] != tt.want {
+			t.Errorf("csvSafe(%q) = %q, want %q", tt.cell, got[0], tt.want)
+		}
+	}
+}
+
+func TestWriteCSVVariesOnAccept(t *testing.T) {
+	r := httptest.NewRequest("GET", "/orders", nil)
+	r.Header.Set("Accept", "text/csv")
+	w := httptest.NewRecorder()
+	if !wantsCSV(w, r) {
+		t.Fatal("wantsCSV() = false for Accept: text/csv")
+	}
+	orders := []models.Order{{ID: "o1", CustomerName: "=1+1", Items: []models.OrderItem{{ProductID: "latte", Quantity: 1}}}}
+	writeCSV(w, "orders", orderCSVHeader, orders, orderCSVRecords)
+
+	if vary := w.Header().Values("Vary"); !slices.Contains(vary, "Accept") {
+		t.Errorf("Vary = %v, want Accept", vary)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "'=1+1") {
+		t.Errorf("formula not escaped in:\n%s", body)
+	}
+}
+
+func TestCSVExportImportRoundTrip(t *testing.T) {
+	items := []models.MenuItem{
+		{ID: "formula", Name: "=HYPERLINK(\"http://x\")", Description: "+1 shot", Price: 3.5, Station: "@bar",
+			Ingredients: []models.MenuItemIngredient{{IngredientID: "milk", Quantity: 200}}},
+		{ID: "quoted", Name: "'=not a formula", Description: "'tis sweet", Price: 2},
+		{ID: "control", Name: "\tTabbed", Description: "-2.5", Price: 4, Station: "-"},
+	}
+	w := httptest.NewRecorder()
+	writeCSV(w, "menu", menuCSVHeader, items, menuCSVRecords)
+
+	got, parseErrs, err := parseMenuCSV(strings.NewReader(w.Body.String()))
+	if err != nil || len(parseErrs) != 0 {
+		t.Fatalf("parseMenuCSV() = %v, %v", parseErrs, err)
+	}
+	if !reflect.DeepEqual(got, items) {
+		t.Errorf("round trip changed the items:\n got %+v\nwant %+v", got, items)
+	}
+}
+
+func TestOrderCSVCarriesUnitPrice(t *testing.T) {
+	order := models.Order{ID: "o1", CustomerName: "Ana", Items: []models.OrderItem{{ProductID: "latte", Quantity: 2, UnitPrice: 3.5}}}
+	records := orderCSVRecords(order)
+	if len(records) != 1 || len(records[0]) != len(orderCSVHeader) {
+		t.Fatalf("records = %v for header %v", records, orderCSVHeader)
+	}
+	if i := slices.Index(orderCSVHeader, "unit_price"); records[0][i] != "3.5" {
+		t.Errorf("unit_price = %q, want 3.5", records[0][i])
+	}
+}
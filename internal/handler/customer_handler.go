@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"hot-coffee/internal/logging"
+	"hot-coffee/internal/service"
+	"hot-coffee/models"
+)
+
+type CustomerHandler struct {
+	svc service.CustomerService
+}
+
+func NewCustomerHandler(customerService service.CustomerService) *CustomerHandler {
+	return &CustomerHandler{svc: customerService}
+}
+
+// Customers serves GET /customers, optionally narrowed with ?q= matching the
+// name, email or phone, and POST /customers, registering a customer.
+func (h *CustomerHandler) Customers(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	logger.Info("Customers", slog.String("method", r.Method), slog.String("path", r.URL.Path))
+	switch r.Method {
+	case http.MethodGet:
+		customers, err := h.svc.ListCustomers(r.Context(), r.URL.Query().Get("q"))
+		if err != nil {
+			logger.Error("Customers GET failed", slog.Any("error", err))
+			writeError(w, err)
+			return
+		}
+		writeAdminJSON(r, w, http.StatusOK, customers)
+
+	case http.MethodPost:
+		var customer models.Customer
+		if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
+			logger.Warn("Customers POST decode", slog.Any("error", err))
+			writeDecodeError(w, err)
+			return
+		}
+		created, err := h.svc.CreateCustomer(r.Context(), customer)
+		if err != nil {
+			logger.Warn("Customers POST service", slog.Any("error", err))
+			writeError(w, err)
+			return
+		}
+		logger.Info("Customers POST success", slog.String("customer_id", created.ID))
+		w.Header().Set("ETag", versionETag(created.Version))
+		writeAdminJSON(r, w, http.StatusCreated, created)
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+	}
+}
+
+// CustomerByID serves GET, PUT, PATCH and DELETE /customers/{id} and
+// GET /customers/{id}/orders, the customer's order history.
+func (h *CustomerHandler) CustomerByID(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	id, action := splitAction(r.URL.Path, "/customers/")
+	logger.Info("CustomerByID", slog.String("method", r.Method), slog.String("id", id), slog.String("action", action))
+	switch {
+	case id == "":
+		writeJSONError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+
+	case action == "orders":
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		history, err := h.svc.GetCustomerHistory(r.Context(), id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeAdminJSON(r, w, http.StatusOK, history)
+
+	case action != "":
+		writeJSONError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+
+	case r.Method == http.MethodGet:
+		customer, err := h.svc.GetCustomer(r.Context(), id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if notModified(w, r, versionETag(customer.Version)) {
+			return
+		}
+		writeAdminJSON(r, w, http.StatusOK, customer)
+
+	case r.Method == http.MethodPut:
+		var updated models.Customer
+		if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+			logger.Warn("CustomerByID PUT decode", slog.Any("error", err))
+			writeDecodeError(w, err)
+			return
+		}
+		version, err := ifMatchVersion(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		updated.Version = version
+		h.updateCustomer(w, r, id, updated)
+
+	case r.Method == http.MethodPatch:
+		if !isMergePatch(r) {
+			writeJSONError(w, http.StatusUnsupportedMediaType, "PATCH requires application/merge-patch+json")
+			return
+		}
+		customer, err := h.svc.GetCustomer(r.Context(), id)
+		if err != nil {
+			writeError(w, ifMatchError(r, err))
+			return
+		}
+		updated, err := applyMergePatch(r, customer)
+		if err != nil {
+			logger.Warn("CustomerByID PATCH decode", slog.Any("error", err))
+			writeDecodeError(w, err)
+			return
+		}
+		version, err := ifMatchVersion(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if version == 0 {
+			version = customer.Version
+		}
+		updated.Version = version
+		h.updateCustomer(w, r, id, updated)
+
+	case r.Method == http.MethodDelete:
+		version, err := ifMatchVersion(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		mode, err := service.ParseDeleteMode(r.URL.Query().Get("dependents"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := h.svc.DeleteCustomer(r.Context(), id, version, mode); err != nil {
+			logger.Warn("CustomerByID DELETE failed", slog.String("id", id), slog.Any("error", err))
+			writeError(w, ifMatchError(r, err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+	}
+}
+
+func (h *CustomerHandler) updateCustomer(w http.ResponseWriter, r *http.Request, id string, updated models.Customer) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	if err := h.svc.UpdateCustomer(ctx, id, updated); err != nil {
+		logger.Warn("CustomerByID update service", slog.Any("error", err))
+		writeError(w, ifMatchError(r, err))
+		return
+	}
+	logger.Info("CustomerByID update success", slog.String("id", id))
+	w.WriteHeader(http.StatusNoContent)
+}
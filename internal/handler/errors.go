@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"hot-coffee/internal/service"
+)
+
+// Stable machine-readable error codes carried in the "code" member of every
+// error response. Errors without a domain meaning derive their code from the
+// HTTP status, e.g. "bad_request" or "method_not_allowed".
+const (
+	codeValidationFailed  = "validation_failed"
+	codeInsufficientStock = "insufficient_stock"
+	codeNotFound          = "not_found"
+	codeConflict          = "conflict"
+	codeHasDependents     = "has_dependents"
+	codeVersionMismatch   = "version_mismatch"
+	codeIfMatchRequired   = "if_match_required"
+	codeConfirmation      = "confirmation_required"
+	codeTimeout           = "timeout"
+	codeCanceled          = "canceled"
+	codeInternal          = "internal_error"
+)
+
+// problem is an RFC 9457 problem details object extended with a stable code
+// and, where they apply, field errors, stock shortages, dependents and a
+// confirmation token.
+type problem struct {
+	Type       string               `json:"type"`
+	Title      string               `json:"title"`
+	Status     int                  `json:"status"`
+	Detail     string               `json:"detail,omitempty"`
+	Code       string               `json:"code"`
+	Errors     []service.FieldError `json:"errors,omitempty"`
+	Shortages  []service.Shortage   `json:"shortages,omitempty"`
+	Dependents []service.Dependent  `json:"dependents,omitempty"`
+	// ConfirmToken and ExpiresAt answer requests that must be confirmed.
+	ConfirmToken string `json:"confirm_token,omitempty"`
+	ExpiresAt    string `json:"expires_at,omitempty"`
+}
+
+func writeProblem(w http.ResponseWriter, p problem) {
+	p.Type = "about:blank"
+	p.Title = http.StatusText(p.Status)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		slog.Error("Encode problem failed", slog.Any("error", err))
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeProblem(w, problem{Status: status, Detail: msg, Code: statusCode(status)})
+}
+
+// writeDecodeError reports a request body that could not be decoded, using
+// 413 when it was cut off by the body size limit.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var mberr *http.MaxBytesError
+	if errors.As(err, &mberr) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", mberr.Limit))
+		return
+	}
+	writeJSONError(w, http.StatusBadRequest, err.Error())
+}
+
+// writeError maps an error returned by the service layer to its status and
+// code. Errors of unknown type are reported as internal errors.
+func writeError(w http.ResponseWriter, err error) {
+	var verr *service.ValidationError
+	var serr *service.InsufficientStockError
+	var derr *service.DependentsError
+	var cerr *service.ConfirmationRequiredError
+	switch {
+	case errors.As(err, &verr):
+		writeProblem(w, problem{Status: http.StatusBadRequest, Detail: err.Error(), Code: codeValidationFailed, Errors: verr.Fields})
+	case errors.As(err, &serr):
+		writeProblem(w, problem{Status: http.StatusBadRequest, Detail: err.Error(), Code: codeInsufficientStock, Shortages: serr.Shortages})
+	case errors.As(err, &derr):
+		writeProblem(w, problem{Status: http.StatusConflict, Detail: err.Error(), Code: codeHasDependents, Dependents: derr.Dependents})
+	case errors.As(err, &cerr):
+		writeProblem(w, problem{Status: http.StatusPreconditionRequired, Detail: err.Error(), Code: codeConfirmation,
+			ConfirmToken: cerr.Token, ExpiresAt: cerr.ExpiresAt.UTC().Format(time.RFC3339)})
+	case errors.Is(err, service.ErrVersionMismatch):
+		writeProblem(w, problem{Status: http.StatusPreconditionFailed, Detail: err.Error(), Code: codeVersionMismatch})
+	case errors.Is(err, service.ErrNotFound):
+		writeProblem(w, problem{Status: http.StatusNotFound, Detail: err.Error(), Code: codeNotFound})
+	case errors.Is(err, service.ErrConflict):
+		writeProblem(w, problem{Status: http.StatusConflict, Detail: err.Error(), Code: codeConflict})
+	case errors.Is(err, service.ErrUnauthenticated):
+		writeJSONError(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		writeProblem(w, problem{Status: http.StatusServiceUnavailable, Detail: "request timed out", Code: codeTimeout})
+	case errors.Is(err, context.Canceled):
+		// The client is usually gone by now; the status mostly shows up in
+		// the access log.
+		writeProblem(w, problem{Status: http.StatusServiceUnavailable, Detail: "request canceled", Code: codeCanceled})
+	default:
+		writeProblem(w, problem{Status: http.StatusInternalServerError, Detail: err.Error(), Code: codeInternal})
+	}
+}
+
+func statusCode(status int) string {
+	if status == http.StatusInternalServerError {
+		return codeInternal
+	}
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
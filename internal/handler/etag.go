@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"hot-coffee/internal/service"
+)
+
+func versionETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// listETag derives a weak validator for a list response from the version
+// of every item it contains, so polling clients can revalidate without
+// the server re-encoding the body. asCSV tells the CSV representation from
+// the JSON one.
+func listETag[T any](asCSV bool, items []T, total int, key func(T) string) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%t|%d", asCSV, total)
+	for _, item := range items {
+		fmt.Fprintf(h, "|%s", key(item))
+	}
+	return fmt.Sprintf("W/\"%x\"", h.Sum64())
+}
+
+// notModified sets the ETag header and, when If-None-Match already names
+// that tag, answers 304 and reports true.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return false
+	}
+	for _, tag := range strings.Split(inm, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// RequireIfMatch answers 428 to PUT, PATCH and DELETE requests without an
+// If-Match header, so clients cannot overwrite a version they never saw.
+// "If-Match: *" still opts out explicitly.
+func RequireIfMatch(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut, http.MethodPatch, http.MethodDelete:
+			if strings.TrimSpace(r.Header.Get("If-Match")) == "" {
+				writeProblem(w, problem{
+					Status: http.StatusPreconditionRequired,
+					Detail: "send the ETag from GET as If-Match",
+					Code:   codeIfMatchRequired,
+				})
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// ifMatchVersion returns the version named by the If-Match header, or 0
+// when the header is absent or "*", meaning any stored version is accepted.
+// A missing resource is still a failed precondition for "*"; see
+// ifMatchError.
+func ifMatchVersion(r *http.Request) (int, error) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	if v == "" || v == "*" {
+		return 0, nil
+	}
+	unquoted, err := strconv.Unquote(strings.TrimPrefix(v, "W/"))
+	if err != nil {
+		return 0, errors.New("If-Match must contain a single ETag")
+	}
+	version, err := strconv.Atoi(unquoted)
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("If-Match %s does not name a version", v)
+	}
+	return version, nil
+}
+
+// ifMatchError reports a missing resource as 412 rather than 404 when the
+// request carried If-Match: RFC 9110 §13.1.1 evaluates the condition, "*"
+// included, as false when there is no current representation.
+func ifMatchError(r *http.Request, err error) error {
+	if errors.Is(err, service.ErrNotFound) && strings.TrimSpace(r.Header.Get("If-Match")) != "" {
+		return fmt.Errorf("%w: %w", service.ErrVersionMismatch, err)
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hot-coffee/internal/service"
+	"hot-coffee/models"
+)
+
+// versionedOrders keeps one version per order and checks it the way the
+// repositories do: 0 accepts any stored version.
+type versionedOrders struct {
+	service.OrderService
+	versions map[string]int
+}
+
+func (s *versionedOrders) check(id string, version int) error {
+	stored, ok := s.versions[id]
+	if !ok {
+		return fmt.Errorf("order %s: %w", id, service.ErrNotFound)
+	}
+	if version != 0 && version != stored {
+		return fmt.Errorf("order %s is at version %d: %w", id, stored, service.ErrVersionMismatch)
+	}
+	return nil
+}
+
+func (s *versionedOrders) GetOrderById(_ context.Context, id string) (models.Order, error) {
+	if err := s.check(id, 0); err != nil {
+		return models.Order{}, err
+	}
+	return models.Order{ID: id, Version: s.versions[id]}, nil
+}
+
+func (s *versionedOrders) UpdateOrder(_ context.Context, id string, order models.Order) error {
+	if err := s.check(id, order.Version); err != nil {
+		return err
+	}
+	s.versions[id]++
+	return nil
+}
+
+func (s *versionedOrders) DeleteOrder(_ context.Context, id string, version int) error {
+	if err := s.check(id, version); err != nil {
+		return err
+	}
+	delete(s.versions, id)
+	return nil
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		ifMatch  string
+		require  bool
+		want     int
+		wantCode string
+	}{
+		{name: "current ETag", method: http.MethodPut, target: "/orders/o1", ifMatch: `"3"`, want: http.StatusNoContent},
+		{name: "stale ETag", method: http.MethodPut, target: "/orders/o1", ifMatch: `"2"`, want: http.StatusPreconditionFailed, wantCode: codeVersionMismatch},
+		{name: "stale weak ETag", method: http.MethodDelete, target: "/orders/o1", ifMatch: `W/"2"`, want: http.StatusPreconditionFailed, wantCode: codeVersionMismatch},
+		{name: "stale ETag on PATCH", method: http.MethodPatch, target: "/orders/o1", ifMatch: `"2"`, want: http.StatusPreconditionFailed, wantCode: codeVersionMismatch},
+		{name: "star on an existing order", method: http.MethodDelete, target: "/orders/o1", ifMatch: "*", want: http.StatusNoContent},
+		{name: "star on a missing order", method: http.MethodPut, target: "/orders/gone", ifMatch: "*", want: http.StatusPreconditionFailed, wantCode: codeVersionMismatch},
+		{name: "star on a missing order with PATCH", method: http.MethodPatch, target: "/orders/gone", ifMatch: "*", want: http.StatusPreconditionFailed, wantCode: codeVersionMismatch},
+		{name: "ETag on a missing order", method: http.MethodDelete, target: "/orders/gone", ifMatch: `"1"`, want: http.StatusPreconditionFailed, wantCode: codeVersionMismatch},
+		{name: "no If-Match on a missing order", method: http.MethodDelete, target: "/orders/gone", want: http.StatusNotFound, wantCode: codeNotFound},
+		{name: "no If-Match is applied by default", method: http.MethodPut, target: "/orders/o1", want: http.StatusNoContent},
+		{name: "no If-Match when required", method: http.MethodPut, target: "/orders/o1", require: true, want: http.StatusPreconditionRequired, wantCode: codeIfMatchRequired},
+		{name: "no If-Match on DELETE when required", method: http.MethodDelete, target: "/orders/o1", require: true, want: http.StatusPreconditionRequired, wantCode: codeIfMatchRequired},
+		{name: "GET is not required to send If-Match", method: http.MethodGet, target: "/orders/o1", require: true, want: http.StatusOK},
+		{name: "star satisfies the requirement", method: http.MethodPut, target: "/orders/o1", ifMatch: "*", require: true, want: http.StatusNoContent},
+		{name: "malformed If-Match", method: http.MethodPut, target: "/orders/o1", ifMatch: "3", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewOrderHandler(&versionedOrders{versions: map[string]int{"o1": 3}}).OrderByID
+			if tt.require {
+				h = RequireIfMatch(h)
+			}
+			body := `{"customer_name":"Ana"}`
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(body))
+			if tt.method == http.MethodPatch {
+				req.Header.Set("Content-Type", "application/merge-patch+json")
+			}
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("%s %s with If-Match %q = %d, want %d: %s", tt.method, tt.target, tt.ifMatch, rec.Code, tt.want, rec.Body)
+			}
+			if tt.wantCode != "" && !strings.Contains(rec.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("body = %s, want code %s", rec.Body, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"hot-coffee/internal/logging"
+	"hot-coffee/models"
+)
+
+// HealthHandler serves the liveness, readiness and build info endpoints used
+// by process supervisors.
+type HealthHandler struct {
+	dataDir string
+	started time.Time
+}
+
+func NewHealthHandler(dir string) *HealthHandler {
+	return &HealthHandler{dataDir: dir, started: time.Now()}
+}
+
+type healthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Healthz reports that the process is up and serving requests.
+func (h *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	writeHealth(r, w, http.StatusOK, healthStatus{Status: "ok"})
+}
+
+// Readyz reports whether the data directory can be read and written and
+// every data file holds a valid JSON array of its model. Each check is listed
+// as "ok" or its error; any failure answers 503.
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	checks := map[string]error{
+		"data_dir":        h.checkDataDir(),
+		"orders.json":     checkDataFile[models.Order](h.dataDir, "orders.json"),
+		"menu_items.json": checkDataFile[models.MenuItem](h.dataDir, "menu_items.json"),
+		"inventory.json":  checkDataFile[models.InventoryItem](h.dataDir, "inventory.json"),
+	}
+
+	status, code := healthStatus{Status: "ready", Checks: map[string]string{}}, http.StatusOK
+	for name, err := range checks {
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("Readiness check failed", slog.String("check", name), slog.Any("error", err))
+			status.Checks[name] = err.Error()
+			status.Status, code = "not_ready", http.StatusServiceUnavailable
+			continue
+		}
+		status.Checks[name] = "ok"
+	}
+	writeHealth(r, w, code, status)
+}
+
+// checkDataDir verifies the data directory exists and accepts new files.
+func (h *HealthHandler) checkDataDir() error {
+	info, err := os.Stat(h.dataDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", h.dataDir)
+	}
+	f, err := os.CreateTemp(h.dataDir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func checkDataFile[T any](dir, name string) error {
+	raw, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	var items []T
+	return json.Unmarshal(raw, &items)
+}
+
+type buildInfo struct {
+	Version      string `json:"version"`
+	Module       string `json:"module"`
+	GoVersion    string `json:"go_version"`
+	Revision     string `json:"revision,omitempty"`
+	RevisionTime string `json:"revision_time,omitempty"`
+	Modified     bool   `json:"modified"`
+	StartedAt    string `json:"started_at"`
+	Uptime       string `json:"uptime"`
+}
+
+// Version reports the build information embedded by the Go toolchain.
+func (h *HealthHandler) Version(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	info := buildInfo{
+		Version:   "(devel)",
+		GoVersion: runtime.Version(),
+		StartedAt: h.started.UTC().Format(time.RFC3339),
+		Uptime:    time.Since(h.started).Round(time.Second).String(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.Module = bi.Main.Path
+		if bi.Main.Version != "" {
+			info.Version = bi.Main.Version
+		}
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Revision = setting.Value
+			case "vcs.time":
+				info.RevisionTime = setting.Value
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+	writeHealth(r, w, http.StatusOK, info)
+}
+
+func writeHealth(r *http.Request, w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logging.FromContext(r.Context()).Error("Encode health response failed", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name      string
+		breakData func(t *testing.T, dir string)
+		want      int
+		wantFail  string
+	}{
+		{name: "all files valid", breakData: func(*testing.T, string) {}, want: http.StatusOK},
+		{
+			// A directory in place of the file cannot be read, even by root.
+			name: "unreadable data file",
+			breakData: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, "orders.json")
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Mkdir(path, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:     http.StatusServiceUnavailable,
+			wantFail: "orders.json",
+		},
+		{
+			name: "missing data file",
+			breakData: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, "inventory.json")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:     http.StatusServiceUnavailable,
+			wantFail: "inventory.json",
+		},
+		{
+			name: "data file that is not an array",
+			breakData: func(t *testing.T, dir string) {
+				if err := os.WriteFile(filepath.Join(dir, "menu_items.json"), []byte(`{"product_id":"latte"}`), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:     http.StatusServiceUnavailable,
+			wantFail: "menu_items.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range []string{"orders.json", "menu_items.json", "inventory.json"} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(`[]`), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tt.breakData(t, dir)
+
+			w := httptest.NewRecorder()
+			NewHealthHandler(dir).Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != tt.want {
+				t.Fatalf("GET /readyz = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			var status healthStatus
+			if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+				t.Fatal(err)
+			}
+			for check, result := range status.Checks {
+				if failed := result != "ok"; failed != (check == tt.wantFail) {
+					t.Errorf("check %s = %q, want only %q to fail", check, result, tt.wantFail)
+				}
+			}
+			wantStatus := "ready"
+			if tt.wantFail != "" {
+				wantStatus = "not_ready"
+			}
+			if status.Status != wantStatus {
+				t.Errorf("status = %q, want %q", status.Status, wantStatus)
+			}
+		})
+	}
+}
+
+func TestReadyzHead(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewHealthHandler(filepath.Join(t.TempDir(), "missing")).Readyz(w, httptest.NewRequest(http.MethodHead, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Body.Len() != 0 {
+		t.Errorf("HEAD /readyz of a missing data dir = %d with %d bytes, want 503 without a body", w.Code, w.Body.Len())
+	}
+}
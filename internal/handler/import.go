@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"hot-coffee/internal/logging"
+	"hot-coffee/internal/service"
+	"hot-coffee/models"
+)
+
+type importer[T any] struct {
+	name     string
+	parseCSV func(io.Reader) ([]T, map[int]string, error)
+	validate func(T) error
+	id       func(T) string
+	apply    func(ctx context.Context, items []T, dryRun bool) (models.ImportResult, error)
+}
+
+// serve decodes a JSON array or CSV body, checks every row with the same
+// validation the single-item handlers use and hands the batch to apply.
+// Rows failing validation force a dry run so nothing is written unless the
+// whole batch is valid.
+func (im importer[T]) serve(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	logger.Info("Import", slog.String("name", im.name), slog.String("method", r.Method))
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	dryRun, err := parseBoolParam(r, "dry_run")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var items []T
+	parseErrs := map[int]string{}
+	if isCSVBody(r) {
+		items, parseErrs, err = im.parseCSV(r.Body)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&items)
+	}
+	if err != nil {
+		logger.Warn("Import decode failed", slog.String("name", im.name), slog.Any("error", err))
+		writeDecodeError(w, err)
+		return
+	}
+	if len(items) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "import must contain at least one row")
+		return
+	}
+
+	var rowErrs []models.ImportRowError
+	for i, item := range items {
+		if msg, ok := parseErrs[i]; ok {
+			rowErrs = append(rowErrs, models.ImportRowError{Row: i + 1, ID: im.id(item), Error: msg})
+			continue
+		}
+		if err := im.validate(item); err != nil {
+			rowErrs = append(rowErrs, models.ImportRowError{Row: i + 1, ID: im.id(item), Error: err.Error()})
+		}
+	}
+
+	result, err := im.apply(r.Context(), items, dryRun || len(rowErrs) != 0)
+	if err != nil {
+		logger.Error("Import apply failed", slog.String("name", im.name), slog.Any("error", err))
+		writeError(w, err)
+		return
+	}
+	result.DryRun = dryRun
+	result.Errors = append(append([]models.ImportRowError{}, rowErrs...), result.Errors...)
+	sort.SliceStable(result.Errors, func(i, j int) bool {
+		return result.Errors[i].Row < result.Errors[j].Row
+	})
+
+	status := http.StatusCreated
+	switch {
+	case dryRun:
+		status = http.StatusOK
+	case len(result.Errors) != 0:
+		status = http.StatusBadRequest
+	}
+	logger.Info("Import done",
+		slog.String("name", im.name),
+		slog.Bool("dry_run", dryRun),
+		slog.Int("imported", result.Imported),
+		slog.Int("errors", len(result.Errors)),
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.Error("Encode import result failed", slog.Any("error", err))
+	}
+}
+
+func isCSVBody(r *http.Request) bool {
+	if strings.EqualFold(r.URL.Query().Get("format"), "csv") {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "text/csv"
+}
+
+func parseBoolParam(r *http.Request, name string) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, service.NewValidationError(name, "must be a boolean")
+	}
+	return b, nil
+}
+
+// readCSVRows reads a CSV document with a header line and returns each data
+// row keyed by column name, so columns may come in any order. Cells escaped
+// by an export are unescaped.
+func readCSVRows(r io.Reader, required []string) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("csv header is missing")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]bool, len(header))
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+		columns[header[i]] = true
+	}
+	for _, col := range required {
+		if !columns[col] {
+			return nil, fmt.Errorf("csv column %s is missing", col)
+		}
+	}
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]string, 0, len(records))
+	for _, record := range records {
+		row := make(map[string]string, len(header))
+		for i, col := range header {
+			row[col] = csvUnescape(strings.TrimSpace(record[i]))
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseMenuCSV(r io.Reader) ([]models.MenuItem, map[int]string, error) {
+	rows, err := readCSVRows(r, menuCSVRequired)
+	if err != nil {
+		return nil, nil, err
+	}
+	items := make([]models.MenuItem, len(rows))
+	parseErrs := map[int]string{}
+	for i, row := range rows {
+		items[i] = models.MenuItem{ID: row["product_id"], Name: row["name"], Description: row["description"], Station: row["station"]}
+		price, err := strconv.ParseFloat(row["price"], 64)
+		if err != nil {
+			parseErrs[i] = "invalid price: " + row["price"]
+			continue
+		}
+		items[i].Price = price
+		if row["ingredients"] == "" {
+			continue
+		}
+		for _, part := range strings.Split(row["ingredients"], ";") {
+			id, qty, ok := strings.Cut(strings.TrimSpace(part), "=")
+			quantity, err := strconv.ParseFloat(qty, 64)
+			if !ok || err != nil {
+				parseErrs[i] = "invalid ingredient: " + part
+				break
+			}
+			items[i].Ingredients = append(items[i].Ingredients, models.MenuItemIngredient{IngredientID: id, Quantity: quantity})
+		}
+	}
+	return items, parseErrs, nil
+}
+
+func parseInventoryCSV(r io.Reader) ([]models.InventoryItem, map[int]string, error) {
+	rows, err := readCSVRows(r, inventoryCSVHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+	items := make([]models.InventoryItem, len(rows))
+	parseErrs := map[int]string{}
+	for i, row := range rows {
+		items[i] = models.InventoryItem{IngredientID: row["ingredient_id"], Name: row["name"], Unit: row["unit"]}
+		quantity, err := strconv.ParseFloat(row["quantity"], 64)
+		if err != nil {
+			parseErrs[i] = "invalid quantity: " + row["quantity"]
+			continue
+		}
+		items[i].Quantity = quantity
+	}
+	return items, parseErrs, nil
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"hot-coffee/internal/service"
+	"hot-coffee/models"
+)
+
+// importMenu records the batch handed to ImportMenuItems and reports it as
+// imported unless it is a dry run.
+type importMenu struct {
+	service.MenuService
+	called bool
+	dryRun bool
+	items  []models.MenuItem
+}
+
+func (s *importMenu) ImportMenuItems(_ context.Context, items []models.MenuItem, dryRun bool) (models.ImportResult, error) {
+	s.called, s.dryRun, s.items = true, dryRun, items
+	result := models.ImportResult{DryRun: dryRun, Total: len(items), Errors: []models.ImportRowError{}}
+	if !dryRun {
+		result.Imported = len(items)
+	}
+	return result, nil
+}
+
+func TestMenuImport(t *testing.T) {
+	const latte = `{"product_id":"latte","name":"Latte","description":"Milk coffee","price":3.5,"ingredients":[{"ingredient_id":"milk","quantity":200}]}`
+	const csvHeader = "product_id,name,description,price,ingredients\n"
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		want        int
+		wantApplied bool
+		wantDryRun  bool
+		wantErrRows []int
+	}{
+		{name: "JSON", target: "/menu/import", body: "[" + latte + "]", want: http.StatusCreated, wantApplied: true},
+		{name: "dry run", target: "/menu/import?dry_run=true", body: "[" + latte + "]", want: http.StatusOK, wantApplied: true, wantDryRun: true},
+		{
+			name: "CSV by content type", target: "/menu/import", contentType: "text/csv",
+			body: csvHeader + "latte,Latte,Milk coffee,3.5,milk=200;sugar=5\n", want: http.StatusCreated, wantApplied: true,
+		},
+		{
+			name: "CSV by format", target: "/menu/import?format=csv",
+			body: csvHeader + "latte,Latte,Milk coffee,3.5,milk=200\n", want: http.StatusCreated, wantApplied: true,
+		},
+		{
+			name: "unparsable CSV row forces a dry run", target: "/menu/import", contentType: "text/csv",
+			body: csvHeader + "latte,Latte,Milk coffee,3.5,milk=200\ntea,Tea,Black tea,cheap,leaves=3\n",
+			want: http.StatusBadRequest, wantApplied: true, wantDryRun: true, wantErrRows: []int{2},
+		},
+		{
+			name: "invalid row forces a dry run", target: "/menu/import",
+			body: "[" + latte + `,{"product_id":"tea","name":"Tea","description":"Black tea","price":-1,"ingredients":[{"ingredient_id":"leaves","quantity":3}]}]`,
+			want: http.StatusBadRequest, wantApplied: true, wantDryRun: true, wantErrRows: []int{2},
+		},
+		{name: "empty batch", target: "/menu/import", body: "[]", want: http.StatusBadRequest},
+		{name: "missing CSV column", target: "/menu/import?format=csv", body: "product_id,name\nlatte,Latte\n", want: http.StatusBadRequest},
+		{name: "bad dry_run", target: "/menu/import?dry_run=maybe", body: "[" + latte + "]", want: http.StatusBadRequest},
+		{name: "GET", method: http.MethodGet, target: "/menu/import", want: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &importMenu{}
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			r := httptest.NewRequest(method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			NewMenuHandler(svc).Import(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("%s %s = %d, want %d: %s", method, tt.target, w.Code, tt.want, w.Body)
+			}
+			if svc.called != tt.wantApplied || svc.dryRun != tt.wantDryRun {
+				t.Errorf("ImportMenuItems called %v with dry run %v, want %v with %v", svc.called, svc.dryRun, tt.wantApplied, tt.wantDryRun)
+			}
+			if !tt.wantApplied {
+				return
+			}
+			var result models.ImportResult
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			var rows []int
+			for _, e := range result.Errors {
+				rows = append(rows, e.Row)
+			}
+			if !reflect.DeepEqual(rows, tt.wantErrRows) {
+				t.Errorf("error rows = %v, want %v", rows, tt.wantErrRows)
+			}
+			// The report shows whether the client asked for a dry run, not
+			// whether one was forced.
+			if asked := strings.Contains(tt.target, "dry_run=true"); result.DryRun != asked {
+				t.Errorf("dry_run = %v, want %v", result.DryRun, asked)
+			}
+		})
+	}
+}
@@ -1,11 +1,13 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"log/slog"
 	"net/http"
-	"strings"
+	"strconv"
 
+	"hot-coffee/internal/logging"
 	"hot-coffee/internal/service"
 	"hot-coffee/models"
 )
@@ -19,145 +21,244 @@ func NewInventoryHandler(invService service.InventoryService) *InventoryHandler
 }
 
 func (h *InventoryHandler) Inventory(w http.ResponseWriter, r *http.Request) {
-	slog.Info("Inventory", slog.String("method", r.Method), slog.String("path", r.URL.Path))
+	logger := logging.FromContext(r.Context())
+	logger.Info("Inventory", slog.String("method", r.Method), slog.String("path", r.URL.Path))
 
 	switch r.Method {
 	case http.MethodGet:
-		items, err := h.svc.GetAllInventoryItem()
+		page, err := parsePage(r.URL.Query(), "ingredient_id", "name", "quantity", "unit")
 		if err != nil {
-			slog.Error("Inventory GET failed", slog.Any("error", err))
-			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			writeError(w, err)
+			return
+		}
+		archived, err := parseArchived(r.URL.Query())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		query := models.InventoryQuery{Page: page, Name: r.URL.Query().Get("name"), Unit: r.URL.Query().Get("unit"), Archived: archived}
+		items, total, err := h.svc.ListInventoryItems(r.Context(), query)
+		if err != nil {
+			logger.Error("Inventory GET failed", slog.Any("error", err))
+			writeError(w, err)
+			return
+		}
+		logger.Info("Inventory GET ok", slog.Int("count", len(items)))
+		setPageHeaders(w, r, page, total)
+		asCSV := wantsCSV(w, r)
+		etag := listETag(asCSV, items, total, func(item models.InventoryItem) string {
+			return item.IngredientID + "@" + strconv.Itoa(item.Version)
+		})
+		if notModified(w, r, etag) {
+			return
+		}
+		if asCSV {
+			writeCSV(w, "inventory", inventoryCSVHeader, items, inventoryCSVRecords)
 			return
 		}
-		slog.Info("Inventory GET ok", slog.Int("count", len(items)))
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(items); err != nil {
-			slog.Error("Inventory GET encode failed", slog.Any("error", err))
+			logger.Error("Inventory GET encode failed", slog.Any("error", err))
 		}
 
 	case http.MethodPost:
 		var item models.InventoryItem
 		if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
-			slog.Warn("Inventory POST bad JSON", slog.Any("error", err))
-			writeJSONError(w, http.StatusBadRequest, err.Error())
+			logger.Warn("Inventory POST bad JSON", slog.Any("error", err))
+			writeDecodeError(w, err)
 			return
 		}
-		slog.Info("Inventory POST decoded", slog.String("id", item.IngredientID))
+		logger.Info("Inventory POST decoded", slog.String("id", item.IngredientID))
 
-		if item.IngredientID == "" {
-			slog.Warn("Inventory POST validation", slog.String("field", "IngredientID"))
-			writeJSONError(w, http.StatusBadRequest, "ingredient_id is required")
-			return
-		}
-		if item.Name == "" {
-			slog.Warn("Inventory POST validation", slog.String("field", "Name"))
-			writeJSONError(w, http.StatusBadRequest, "name is required")
-			return
-		}
-		if item.Quantity < 0 {
-			slog.Warn("Inventory POST validation", slog.Int("quantity", int(item.Quantity)))
-			writeJSONError(w, http.StatusBadRequest, "quantity must be non‑negative")
-			return
-		}
-		if item.Unit == "" {
-			slog.Warn("Inventory POST validation", slog.String("field", "Unit"))
-			writeJSONError(w, http.StatusBadRequest, "unit is required")
+		if err := validateInventoryItem(item); err != nil {
+			logger.Warn("Inventory POST validation", slog.Any("error", err))
+			writeError(w, err)
 			return
 		}
 
-		if err := h.svc.AddInventoryItem(item); err != nil {
-			slog.Warn("Inventory POST service error", slog.Any("error", err))
-			switch err.Error() {
-			case "Item ID already exists", "Item Name already exists":
-				writeJSONError(w, http.StatusConflict, err.Error())
-			default:
-				writeJSONError(w, http.StatusInternalServerError, err.Error())
-			}
+		if err := h.svc.AddInventoryItem(r.Context(), item); err != nil {
+			logger.Warn("Inventory POST service error", slog.Any("error", err))
+			writeError(w, err)
 			return
 		}
 
-		slog.Info("Inventory POST created", slog.String("id", item.IngredientID))
+		logger.Info("Inventory POST created", slog.String("id", item.IngredientID))
 		w.WriteHeader(http.StatusCreated)
 
 	default:
-		slog.Warn("Inventory unsupported method", slog.String("method", r.Method))
+		logger.Warn("Inventory unsupported method", slog.String("method", r.Method))
 		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
 	}
 }
 
 func (h *InventoryHandler) InventoryByID(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/inventory/")
-	slog.Info("InventoryByID", slog.String("method", r.Method), slog.String("id", id))
+	logger := logging.FromContext(r.Context())
+	id, action := splitAction(r.URL.Path, "/inventory/")
+	logger.Info("InventoryByID", slog.String("method", r.Method), slog.String("id", id), slog.String("action", action))
+	if action != "" {
+		h.setArchived(w, r, id, action)
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
-		item, err := h.svc.GetInventoryItemByID(id)
+		item, err := h.svc.GetInventoryItemByID(r.Context(), id)
 		if err != nil {
-			slog.Warn("InventoryByID GET not found", slog.String("id", id))
-			writeJSONError(w, http.StatusNotFound, "inventory item not found")
+			logger.Warn("InventoryByID GET failed", slog.String("id", id), slog.Any("error", err))
+			writeError(w, err)
+			return
+		}
+		logger.Info("InventoryByID GET ok", slog.String("id", id))
+		if notModified(w, r, versionETag(item.Version)) {
 			return
 		}
-		slog.Info("InventoryByID GET ok", slog.String("id", id))
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(item); err != nil {
-			slog.Error("InventoryByID GET encode failed", slog.Any("error", err))
+			logger.Error("InventoryByID GET encode failed", slog.Any("error", err))
 		}
 
 	case http.MethodPut:
 		var updated models.InventoryItem
 		if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
-			slog.Warn("InventoryByID PUT bad JSON", slog.Any("error", err))
+			logger.Warn("InventoryByID PUT bad JSON", slog.Any("error", err))
+			writeDecodeError(w, err)
+			return
+		}
+		logger.Info("InventoryByID PUT decoded", slog.String("id", id))
+		version, err := ifMatchVersion(r)
+		if err != nil {
 			writeJSONError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		slog.Info("InventoryByID PUT decoded", slog.String("id", id))
+		updated.Version = version
+		h.updateInventoryItem(w, r, id, updated)
 
-		if updated.IngredientID == "" {
-			slog.Warn("InventoryByID PUT validation", slog.String("field", "IngredientID"))
-			writeJSONError(w, http.StatusBadRequest, "ingredient_id is required")
+	case http.MethodPatch:
+		if !isMergePatch(r) {
+			writeJSONError(w, http.StatusUnsupportedMediaType, "PATCH requires application/merge-patch+json")
 			return
 		}
-		if updated.Name == "" {
-			slog.Warn("InventoryByID PUT validation", slog.String("field", "Name"))
-			writeJSONError(w, http.StatusBadRequest, "name is required")
+		item, err := h.svc.GetInventoryItemByID(r.Context(), id)
+		if err != nil {
+			logger.Warn("InventoryByID PATCH failed", slog.String("id", id), slog.Any("error", err))
+			writeError(w, ifMatchError(r, err))
 			return
 		}
-		if updated.Quantity < 0 {
-			slog.Warn("InventoryByID PUT validation", slog.Int("quantity", int(updated.Quantity)))
-			writeJSONError(w, http.StatusBadRequest, "quantity must be non‑negative")
+		updated, err := applyMergePatch(r, item)
+		if err != nil {
+			logger.Warn("InventoryByID PATCH bad JSON", slog.Any("error", err))
+			writeDecodeError(w, err)
 			return
 		}
-		if updated.Unit == "" {
-			slog.Warn("InventoryByID PUT validation", slog.String("field", "Unit"))
-			writeJSONError(w, http.StatusBadRequest, "unit is required")
+		logger.Info("InventoryByID PATCH applied", slog.String("id", id))
+		version, err := ifMatchVersion(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-
-		if err := h.svc.UpdateInventoryItem(id, updated); err != nil {
-			slog.Warn("InventoryByID PUT service error", slog.Any("error", err))
-			switch err.Error() {
-			case "Inventory item ID already exists", "Inventory item name already exists":
-				writeJSONError(w, http.StatusConflict, err.Error())
-			default:
-				writeJSONError(w, http.StatusNotFound, err.Error())
-			}
-			return
+		if version == 0 {
+			version = item.Version
 		}
-
-		slog.Info("InventoryByID PUT success", slog.String("id", id))
-		w.WriteHeader(http.StatusNoContent)
+		updated.Version = version
+		h.updateInventoryItem(w, r, id, updated)
 
 	case http.MethodDelete:
-		if err := h.svc.DeleteInventoryItem(id); err != nil {
-			slog.Warn("InventoryByID DELETE failed", slog.String("id", id), slog.Any("error", err))
-			writeJSONError(w, http.StatusNotFound, err.Error())
+		version, err := ifMatchVersion(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		mode, err := service.ParseDeleteMode(r.URL.Query().Get("dependents"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := h.svc.DeleteInventoryItem(r.Context(), id, version, mode); err != nil {
+			logger.Warn("InventoryByID DELETE failed", slog.String("id", id), slog.Any("error", err))
+			writeError(w, ifMatchError(r, err))
 			return
 		}
-		slog.Info("InventoryByID DELETE success", slog.String("id", id))
+		logger.Info("InventoryByID DELETE success", slog.String("id", id))
 		w.WriteHeader(http.StatusNoContent)
 
 	default:
-		slog.Warn("InventoryByID unsupported method", slog.String("method", r.Method))
+		logger.Warn("InventoryByID unsupported method", slog.String("method", r.Method))
 		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
 	}
 }
+
+func (h *InventoryHandler) updateInventoryItem(w http.ResponseWriter, r *http.Request, id string, updated models.InventoryItem) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	if err := validateInventoryItem(updated); err != nil {
+		logger.Warn("InventoryByID update validation", slog.Any("error", err))
+		writeError(w, err)
+		return
+	}
+
+	if err := h.svc.UpdateInventoryItem(ctx, id, updated); err != nil {
+		logger.Warn("InventoryByID update service error", slog.Any("error", err))
+		writeError(w, ifMatchError(r, err))
+		return
+	}
+
+	logger.Info("InventoryByID update success", slog.String("id", id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *InventoryHandler) Import(w http.ResponseWriter, r *http.Request) {
+	importer[models.InventoryItem]{
+		name:     "inventory",
+		parseCSV: parseInventoryCSV,
+		validate: validateInventoryItem,
+		id:       func(item models.InventoryItem) string { return item.IngredientID },
+		apply:    h.svc.ImportInventoryItems,
+	}.serve(w, r)
+}
+
+func validateInventoryItem(item models.InventoryItem) error {
+	verr := &service.ValidationError{}
+	if item.IngredientID == "" {
+		verr.Add("ingredient_id", "ingredient_id is required")
+	}
+	if item.Name == "" {
+		verr.Add("name", "name is required")
+	}
+	if item.Quantity < 0 {
+		verr.Add("quantity", "quantity must be non‑negative")
+	}
+	if item.Unit == "" {
+		verr.Add("unit", "unit is required")
+	}
+	return verr.OrNil()
+}
+
+// setArchived serves POST {id}/archive and POST {id}/restore. An If-Match
+// version, when given, must match the stored item.
+func (h *InventoryHandler) setArchived(w http.ResponseWriter, r *http.Request, id, action string) {
+	logger := logging.FromContext(r.Context())
+	apply := map[string]func(context.Context, string, int) error{
+		"archive": h.svc.ArchiveInventoryItem,
+		"restore": h.svc.RestoreInventoryItem,
+	}[action]
+	if apply == nil {
+		writeJSONError(w, http.StatusNotFound, "unknown action "+action)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := apply(r.Context(), id, version); err != nil {
+		logger.Warn("InventoryByID "+action+" failed", slog.String("id", id), slog.Any("error", err))
+		writeError(w, ifMatchError(r, err))
+		return
+	}
+	logger.Info("InventoryByID "+action+" success", slog.String("id", id))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,11 +1,14 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
-	"strings"
+	"strconv"
 
+	"hot-coffee/internal/logging"
 	"hot-coffee/internal/service"
 	"hot-coffee/models"
 )
@@ -19,182 +22,254 @@ func NewMenuHandler(menuService service.MenuService) *MenuHandler {
 }
 
 func (h *MenuHandler) Menu(w http.ResponseWriter, r *http.Request) {
-	slog.Info("Menu", slog.String("method", r.Method), slog.String("path", r.URL.Path))
+	logger := logging.FromContext(r.Context())
+	logger.Info("Menu", slog.String("method", r.Method), slog.String("path", r.URL.Path))
 	switch r.Method {
 	case http.MethodPost:
 		var item models.MenuItem
 		if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
-			slog.Warn("Menu POST decode", slog.Any("error", err))
-			writeJSONError(w, http.StatusBadRequest, err.Error())
+			logger.Warn("Menu POST decode", slog.Any("error", err))
+			writeDecodeError(w, err)
 			return
 		}
-		slog.Info("Menu POST decoded", slog.String("id", item.ID))
+		logger.Info("Menu POST decoded", slog.String("id", item.ID))
 
-		if item.ID == "" {
-			slog.Warn("Menu POST validation", slog.String("field", "ID"))
-			writeJSONError(w, http.StatusBadRequest, "product_id is required")
+		if err := validateMenuItem(item); err != nil {
+			logger.Warn("Menu POST validation", slog.Any("error", err))
+			writeError(w, err)
 			return
 		}
-		if item.Name == "" {
-			slog.Warn("Menu POST validation", slog.String("field", "Name"))
-			writeJSONError(w, http.StatusBadRequest, "name is required")
+
+		if err := h.svc.AddMenuItem(r.Context(), item); err != nil {
+			logger.Warn("Menu POST service", slog.Any("error", err))
+			writeError(w, err)
 			return
 		}
-		if item.Description == "" {
-			slog.Warn("Menu POST validation", slog.String("field", "Description"))
-			writeJSONError(w, http.StatusBadRequest, "description is required")
+
+		logger.Info("Menu POST success", slog.String("id", item.ID))
+		w.WriteHeader(http.StatusCreated)
+
+	case http.MethodGet:
+		logger.Info("Menu GET", slog.String("method", r.Method))
+		page, err := parsePage(r.URL.Query(), "product_id", "name", "price")
+		if err != nil {
+			writeError(w, err)
 			return
 		}
-		if item.Price < 0 {
-			slog.Warn("Menu POST validation", slog.Float64("price", item.Price))
-			writeJSONError(w, http.StatusBadRequest, "price must be non-negative")
+		archived, err := parseArchived(r.URL.Query())
+		if err != nil {
+			writeError(w, err)
 			return
 		}
-		if len(item.Ingredients) == 0 {
-			slog.Warn("Menu POST validation", slog.String("field", "Ingredients"))
-			writeJSONError(w, http.StatusBadRequest, "ingredients cannot be empty")
+		query := models.MenuQuery{Page: page, Name: r.URL.Query().Get("name"), Archived: archived}
+		items, total, err := h.svc.ListMenuItems(r.Context(), query)
+		if err != nil {
+			logger.Error("Menu GET failed", slog.Any("error", err))
+			writeError(w, err)
 			return
 		}
-		for _, ing := range item.Ingredients {
-			if ing.IngredientID == "" {
-				slog.Warn("Menu POST validation", slog.String("field", "IngredientID"))
-				writeJSONError(w, http.StatusBadRequest, "ingredient_id is required")
-				return
-			}
-			if ing.Quantity <= 0 {
-				slog.Warn("Menu POST validation", slog.String("ingredient_id", ing.IngredientID), slog.Int("quantity", int(ing.Quantity)))
-				writeJSONError(w, http.StatusBadRequest, "ingredient quantity must be positive")
-				return
-			}
-		}
-
-		if err := h.svc.AddMenuItem(item); err != nil {
-			slog.Warn("Menu POST service", slog.Any("error", err))
-			switch {
-			case strings.Contains(err.Error(), "following ingredients missing:"):
-				writeJSONError(w, http.StatusNotFound, err.Error())
-			case err.Error() == "Menu item ID already exists", err.Error() == "Menu item name already exists":
-				writeJSONError(w, http.StatusConflict, err.Error())
-			case err.Error() == "quantity must be non-negative value":
-				writeJSONError(w, http.StatusBadRequest, err.Error())
-			default:
-				writeJSONError(w, http.StatusInternalServerError, err.Error())
-			}
+		logger.Info("Menu GET success", slog.Int("count", len(items)))
+		setPageHeaders(w, r, page, total)
+		asCSV := wantsCSV(w, r)
+		etag := listETag(asCSV, items, total, func(item models.MenuItem) string {
+			return item.ID + "@" + strconv.Itoa(item.Version)
+		})
+		if notModified(w, r, etag) {
 			return
 		}
-
-		slog.Info("Menu POST success", slog.String("id", item.ID))
-		w.WriteHeader(http.StatusCreated)
-
-	case http.MethodGet:
-		slog.Info("Menu GET", slog.String("method", r.Method))
-		items, err := h.svc.GetAllMenuItems()
-		if err != nil {
-			slog.Error("Menu GET failed", slog.Any("error", err))
-			writeJSONError(w, http.StatusInternalServerError, err.Error())
+		if asCSV {
+			writeCSV(w, "menu", menuCSVHeader, items, menuCSVRecords)
 			return
 		}
-		slog.Info("Menu GET success", slog.Int("count", len(items)))
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(items); err != nil {
-			slog.Error("Menu GET encode", slog.Any("error", err))
+			logger.Error("Menu GET encode", slog.Any("error", err))
 		}
 
 	default:
-		slog.Warn("Menu unsupported", slog.String("method", r.Method))
+		logger.Warn("Menu unsupported", slog.String("method", r.Method))
 		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
 	}
 }
 
 func (h *MenuHandler) MenuByID(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/menu/")
-	slog.Info("MenuByID", slog.String("method", r.Method), slog.String("id", id))
+	logger := logging.FromContext(r.Context())
+	id, action := splitAction(r.URL.Path, "/menu/")
+	logger.Info("MenuByID", slog.String("method", r.Method), slog.String("id", id), slog.String("action", action))
+	if action != "" {
+		h.setArchived(w, r, id, action)
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
-		item, err := h.svc.GetMenuItemByID(id)
+		item, err := h.svc.GetMenuItemByID(r.Context(), id)
 		if err != nil {
-			slog.Warn("MenuByID GET not found", slog.String("id", id))
-			writeJSONError(w, http.StatusNotFound, err.Error())
+			logger.Warn("MenuByID GET failed", slog.String("id", id), slog.Any("error", err))
+			writeError(w, err)
+			return
+		}
+		logger.Info("MenuByID GET success", slog.String("id", id))
+		if notModified(w, r, versionETag(item.Version)) {
 			return
 		}
-		slog.Info("MenuByID GET success", slog.String("id", id))
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(item); err != nil {
-			slog.Error("MenuByID GET encode", slog.Any("error", err))
+			logger.Error("MenuByID GET encode", slog.Any("error", err))
 		}
 
 	case http.MethodPut:
 		var updated models.MenuItem
 		if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
-			slog.Warn("MenuByID PUT decode", slog.Any("error", err))
-			writeJSONError(w, http.StatusBadRequest, err.Error())
+			logger.Warn("MenuByID PUT decode", slog.Any("error", err))
+			writeDecodeError(w, err)
 			return
 		}
-		slog.Info("MenuByID PUT decoded", slog.String("id", id))
-
-		if updated.ID == "" {
-			slog.Warn("MenuByID PUT validation", slog.String("field", "ID"))
-			writeJSONError(w, http.StatusBadRequest, "product_id is required")
+		logger.Info("MenuByID PUT decoded", slog.String("id", id))
+		version, err := ifMatchVersion(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		if updated.Name == "" {
-			slog.Warn("MenuByID PUT validation", slog.String("field", "Name"))
-			writeJSONError(w, http.StatusBadRequest, "name is required")
+		updated.Version = version
+		h.updateMenuItem(w, r, id, updated)
+
+	case http.MethodPatch:
+		if !isMergePatch(r) {
+			writeJSONError(w, http.StatusUnsupportedMediaType, "PATCH requires application/merge-patch+json")
 			return
 		}
-		if updated.Description == "" {
-			slog.Warn("MenuByID PUT validation", slog.String("field", "Description"))
-			writeJSONError(w, http.StatusBadRequest, "description is required")
+		item, err := h.svc.GetMenuItemByID(r.Context(), id)
+		if err != nil {
+			logger.Warn("MenuByID PATCH failed", slog.String("id", id), slog.Any("error", err))
+			writeError(w, ifMatchError(r, err))
 			return
 		}
-		if updated.Price < 0 {
-			slog.Warn("MenuByID PUT validation", slog.Float64("price", updated.Price))
-			writeJSONError(w, http.StatusBadRequest, "price must be non-negative")
+		updated, err := applyMergePatch(r, item)
+		if err != nil {
+			logger.Warn("MenuByID PATCH decode", slog.Any("error", err))
+			writeDecodeError(w, err)
 			return
 		}
-		if len(updated.Ingredients) == 0 {
-			slog.Warn("MenuByID PUT validation", slog.String("field", "Ingredients"))
-			writeJSONError(w, http.StatusBadRequest, "ingredients cannot be empty")
+		logger.Info("MenuByID PATCH applied", slog.String("id", id))
+		version, err := ifMatchVersion(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		for _, ing := range updated.Ingredients {
-			if ing.IngredientID == "" {
-				slog.Warn("MenuByID PUT validation", slog.String("field", "IngredientID"))
-				writeJSONError(w, http.StatusBadRequest, "ingredient_id is required")
-				return
-			}
-			if ing.Quantity <= 0 {
-				slog.Warn("MenuByID PUT validation", slog.String("ingredient_id", ing.IngredientID), slog.Int("quantity", int(ing.Quantity)))
-				writeJSONError(w, http.StatusBadRequest, "ingredient quantity must be positive")
-				return
-			}
+		if version == 0 {
+			version = item.Version
 		}
+		updated.Version = version
+		h.updateMenuItem(w, r, id, updated)
 
-		if err := h.svc.UpdateMenuItem(id, updated); err != nil {
-			slog.Warn("MenuByID PUT service", slog.Any("error", err))
-			switch {
-			case err.Error() == "Menu item ID already exists", err.Error() == "Menu item name already exists":
-				writeJSONError(w, http.StatusConflict, err.Error())
-			default:
-				writeJSONError(w, http.StatusNotFound, err.Error())
-			}
+	case http.MethodDelete:
+		version, err := ifMatchVersion(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-
-		slog.Info("MenuByID PUT success", slog.String("id", id))
-		w.WriteHeader(http.StatusNoContent)
-
-	case http.MethodDelete:
-		if err := h.svc.DeleteMenuItem(id); err != nil {
-			slog.Warn("MenuByID DELETE failed", slog.String("id", id), slog.Any("error", err))
-			writeJSONError(w, http.StatusNotFound, err.Error())
+		mode, err := service.ParseDeleteMode(r.URL.Query().Get("dependents"))
+		if err != nil {
+			writeError(w, err)
 			return
 		}
-		slog.Info("MenuByID DELETE success", slog.String("id", id))
+		if err := h.svc.DeleteMenuItem(r.Context(), id, version, mode); err != nil {
+			logger.Warn("MenuByID DELETE failed", slog.String("id", id), slog.Any("error", err))
+			writeError(w, ifMatchError(r, err))
+			return
+		}
+		logger.Info("MenuByID DELETE success", slog.String("id", id))
 		w.WriteHeader(http.StatusNoContent)
 
 	default:
-		slog.Warn("MenuByID unsupported", slog.String("method", r.Method))
+		logger.Warn("MenuByID unsupported", slog.String("method", r.Method))
 		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
 	}
 }
+
+func (h *MenuHandler) updateMenuItem(w http.ResponseWriter, r *http.Request, id string, updated models.MenuItem) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	if err := validateMenuItem(updated); err != nil {
+		logger.Warn("MenuByID update validation", slog.Any("error", err))
+		writeError(w, err)
+		return
+	}
+
+	if err := h.svc.UpdateMenuItem(ctx, id, updated); err != nil {
+		logger.Warn("MenuByID update service", slog.Any("error", err))
+		writeError(w, ifMatchError(r, err))
+		return
+	}
+
+	logger.Info("MenuByID update success", slog.String("id", id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *MenuHandler) Import(w http.ResponseWriter, r *http.Request) {
+	importer[models.MenuItem]{
+		name:     "menu",
+		parseCSV: parseMenuCSV,
+		validate: validateMenuItem,
+		id:       func(item models.MenuItem) string { return item.ID },
+		apply:    h.svc.ImportMenuItems,
+	}.serve(w, r)
+}
+
+func validateMenuItem(item models.MenuItem) error {
+	verr := &service.ValidationError{}
+	if item.ID == "" {
+		verr.Add("product_id", "product_id is required")
+	}
+	if item.Name == "" {
+		verr.Add("name", "name is required")
+	}
+	if item.Description == "" {
+		verr.Add("description", "description is required")
+	}
+	if item.Price < 0 {
+		verr.Add("price", "price must be non-negative")
+	}
+	if len(item.Ingredients) == 0 {
+		verr.Add("ingredients", "ingredients cannot be empty")
+	}
+	for i, ing := range item.Ingredients {
+		if ing.IngredientID == "" {
+			verr.Add(fmt.Sprintf("ingredients[%d].ingredient_id", i), "ingredient_id is required")
+		}
+		if ing.Quantity <= 0 {
+			verr.Add(fmt.Sprintf("ingredients[%d].quantity", i), "ingredient quantity must be positive")
+		}
+	}
+	return verr.OrNil()
+}
+
+// setArchived serves POST {id}/archive and POST {id}/restore. An If-Match
+// version, when given, must match the stored item.
+func (h *MenuHandler) setArchived(w http.ResponseWriter, r *http.Request, id, action string) {
+	logger := logging.FromContext(r.Context())
+	apply := map[string]func(context.Context, string, int) error{
+		"archive": h.svc.ArchiveMenuItem,
+		"restore": h.svc.RestoreMenuItem,
+	}[action]
+	if apply == nil {
+		writeJSONError(w, http.StatusNotFound, "unknown action "+action)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := apply(r.Context(), id, version); err != nil {
+		logger.Warn("MenuByID "+action+" failed", slog.String("id", id), slog.Any("error", err))
+		writeError(w, ifMatchError(r, err))
+		return
+	}
+	logger.Info("MenuByID "+action+" success", slog.String("id", id))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+
+	"hot-coffee/internal/logging"
+	"hot-coffee/internal/metrics"
+)
+
+// Metrics serves every registered metric in the Prometheus text exposition
+// format. The output is rendered before anything is written so a failing
+// gauge yields a 500 rather than a truncated scrape.
+func Metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	var buf bytes.Buffer
+	if err := metrics.WriteText(r.Context(), &buf); err != nil {
+		logging.FromContext(r.Context()).Error("Render metrics failed", slog.Any("error", err))
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := buf.WriteTo(w); err != nil {
+		logging.FromContext(r.Context()).Error("Write metrics failed", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrape serves GET /metrics and returns every sample by its series, e.g.
+// `hotcoffee_http_requests_total{route="/orders/",method="GET",code="200"}`.
+func scrape(t *testing.T) map[string]float64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	Metrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("GET /metrics = %d, %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	samples := map[string]float64{}
+	sc := bufio.NewScanner(w.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("sample %q: %v", line, err)
+		}
+		samples[line[:i]] = v
+	}
+	return samples
+}
+
+func TestInstrumentRecordsHandlerRequests(t *testing.T) {
+	// The metrics are process-wide, so the route is one no other test uses
+	// and only the increase since the first scrape is compared.
+	const route = "/metrics-test/orders/"
+	before := scrape(t)
+	h := Instrument(route, http.HandlerFunc(NewOrderHandler(&versionedOrders{versions: map[string]int{"o1": 3}}).OrderByID))
+	requests := []struct {
+		method  string
+		target  string
+		ifMatch string
+	}{
+		{method: http.MethodGet, target: "/orders/o1"},
+		{method: http.MethodGet, target: "/orders/o1"},
+		{method: http.MethodGet, target: "/orders/gone"},
+		{method: http.MethodPut, target: "/orders/o1", ifMatch: `"1"`},
+		{method: http.MethodDelete, target: "/orders/o1"},
+	}
+	for _, req := range requests {
+		r := httptest.NewRequest(req.method, req.target, strings.NewReader(`{"customer_name":"Ana"}`))
+		if req.ifMatch != "" {
+			r.Header.Set("If-Match", req.ifMatch)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	after := scrape(t)
+	delta := func(series string) float64 { return after[series] - before[series] }
+	counters := []struct {
+		method string
+		code   string
+		want   float64
+	}{
+		{method: "GET", code: "200", want: 2},
+		{method: "GET", code: "404", want: 1},
+		{method: "PUT", code: "412", want: 1},
+		{method: "DELETE", code: "204", want: 1},
+	}
+	for _, c := range counters {
+		series := `hotcoffee_http_requests_total{route="` + route + `",method="` + c.method + `",code="` + c.code + `"}`
+		if got := delta(series); got != c.want {
+			t.Errorf("%s = %v, want %v", series, got, c.want)
+		}
+	}
+
+	histograms := []struct {
+		method string
+		want   float64
+	}{
+		{method: "GET", want: 3},
+		{method: "PUT", want: 1},
+		{method: "DELETE", want: 1},
+	}
+	for _, hist := range histograms {
+		labels := `route="` + route + `",method="` + hist.method + `"`
+		count := delta("hotcoffee_http_request_duration_seconds_count{" + labels + "}")
+		if count != hist.want {
+			t.Errorf("%s duration count = %v, want %v", hist.method, count, hist.want)
+		}
+		if inf := delta("hotcoffee_http_request_duration_seconds_bucket{" + labels + `,le="+Inf"}`); inf != count {
+			t.Errorf("%s +Inf bucket = %v, want the count %v", hist.method, inf, count)
+		}
+		// Buckets are cumulative: the 10s bucket holds every fast test request.
+		if slow := delta("hotcoffee_http_request_duration_seconds_bucket{" + labels + `,le="10"}`); slow != count {
+			t.Errorf("%s le=10 bucket = %v, want the count %v", hist.method, slow, count)
+		}
+		if _, ok := after["hotcoffee_http_request_duration_seconds_sum{"+labels+"}"]; !ok {
+			t.Errorf("%s duration has no _sum", hist.method)
+		}
+	}
+}
+
+func TestMetricsRejectsWrites(t *testing.T) {
+	w := httptest.NewRecorder()
+	Metrics(w, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /metrics = %d, want 405", w.Code)
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
+	"hot-coffee/internal/logging"
 	"hot-coffee/internal/service"
 	"hot-coffee/models"
 )
@@ -19,24 +22,42 @@ func NewOrderHandler(orderService service.OrderService) *OrderHandler {
 }
 
 func (h *OrderHandler) Orders(w http.ResponseWriter, r *http.Request) {
-	slog.Info("Orders endpoint hit",
+	logger := logging.FromContext(r.Context())
+	logger.Info("Orders endpoint hit",
 		slog.String("method", r.Method),
 		slog.String("path", r.URL.Path),
 	)
 
 	switch r.Method {
 	case http.MethodGet:
-		orders, err := h.svc.GetOrders()
+		query, err := parseOrderQuery(r.URL.Query())
 		if err != nil {
-			slog.Error("GetOrders failed",
+			writeError(w, err)
+			return
+		}
+		orders, total, err := h.svc.ListOrders(r.Context(), query)
+		if err != nil {
+			logger.Error("GetOrders failed",
 				slog.Any("error", err),
 			)
-			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			writeError(w, err)
+			return
+		}
+		setPageHeaders(w, r, query.Page, total)
+		asCSV := wantsCSV(w, r)
+		etag := listETag(asCSV, orders, total, func(order models.Order) string {
+			return order.ID + "@" + strconv.Itoa(order.Version)
+		})
+		if notModified(w, r, etag) {
+			return
+		}
+		if asCSV {
+			writeCSV(w, "orders", orderCSVHeader, orders, orderCSVRecords)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(orders); err != nil {
-			slog.Error("Encode orders failed",
+			logger.Error("Encode orders failed",
 				slog.Any("error", err),
 			)
 		}
@@ -44,31 +65,18 @@ func (h *OrderHandler) Orders(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		var newOrder models.Order
 		if err := json.NewDecoder(r.Body).Decode(&newOrder); err != nil {
-			slog.Error("Decode new order failed",
+			logger.Error("Decode new order failed",
 				slog.Any("error", err),
 			)
-			writeJSONError(w, http.StatusBadRequest, err.Error())
+			writeDecodeError(w, err)
 			return
 		}
 
-		conflicts, err := h.svc.CreateOrder(newOrder)
-		if err != nil {
-			slog.Error("CreateOrder failed",
+		if err := h.svc.CreateOrder(r.Context(), newOrder); err != nil {
+			logger.Error("CreateOrder failed",
 				slog.Any("error", err),
 			)
-			if strings.Contains(err.Error(), "invalid quantity") {
-				writeJSONError(w, http.StatusBadRequest, err.Error())
-			} else {
-				writeJSONError(w, http.StatusInternalServerError, err.Error())
-			}
-			return
-		}
-		if len(conflicts) != 0 {
-			msg := strings.Join(conflicts, "\n")
-			slog.Warn("CreateOrder conflicts",
-				slog.String("conflicts", msg),
-			)
-			writeJSONError(w, http.StatusBadRequest, msg)
+			writeError(w, err)
 			return
 		}
 
@@ -80,7 +88,8 @@ func (h *OrderHandler) Orders(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *OrderHandler) OrderByID(w http.ResponseWriter, r *http.Request) {
-	slog.Info("OrderByID endpoint hit",
+	logger := logging.FromContext(r.Context())
+	logger.Info("OrderByID endpoint hit",
 		slog.String("method", r.Method),
 		slog.String("path", r.URL.Path),
 	)
@@ -88,37 +97,42 @@ func (h *OrderHandler) OrderByID(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(r.URL.Path, "/")
 	// POST /orders/{id}/close
 	if len(parts) == 4 && r.Method == http.MethodPost && parts[3] == "close" {
-		if err := h.svc.CloseOrder(parts[2]); err != nil {
-			slog.Error("CloseOrder failed",
+		if err := h.svc.CloseOrder(r.Context(), parts[2]); err != nil {
+			logger.Error("CloseOrder failed",
 				slog.String("order_id", parts[2]),
 				slog.Any("error", err),
 			)
-			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			writeError(w, err)
 		}
 		return
 	}
 
-	// GET, PUT, DELETE /orders/{id}
+	// POST /orders/{id}/items/{line}/done
+	if len(parts) == 6 && parts[3] == "items" && parts[5] == "done" {
+		h.bumpLine(w, r, parts[2], parts[4])
+		return
+	}
+
+	// GET, PUT, PATCH, DELETE /orders/{id}
 	if len(parts) == 3 {
 		id := parts[2]
 		switch r.Method {
 		case http.MethodGet:
-			order, err := h.svc.GetOrderById(id)
+			order, err := h.svc.GetOrderById(r.Context(), id)
 			if err != nil {
-				slog.Error("GetOrderById failed",
+				logger.Error("GetOrderById failed",
 					slog.String("order_id", id),
 					slog.Any("error", err),
 				)
-				if strings.Contains(err.Error(), "not found") {
-					writeJSONError(w, http.StatusNotFound, err.Error())
-				} else {
-					writeJSONError(w, http.StatusInternalServerError, err.Error())
-				}
+				writeError(w, err)
+				return
+			}
+			if notModified(w, r, versionETag(order.Version)) {
 				return
 			}
 			w.Header().Set("Content-Type", "application/json")
 			if err := json.NewEncoder(w).Encode(order); err != nil {
-				slog.Error("Encode order failed",
+				logger.Error("Encode order failed",
 					slog.Any("error", err),
 				)
 			}
@@ -126,50 +140,65 @@ func (h *OrderHandler) OrderByID(w http.ResponseWriter, r *http.Request) {
 		case http.MethodPut:
 			var upd models.Order
 			if err := json.NewDecoder(r.Body).Decode(&upd); err != nil {
-				slog.Error("Decode update order failed",
+				logger.Error("Decode update order failed",
 					slog.Any("error", err),
 				)
+				writeDecodeError(w, err)
+				return
+			}
+			version, err := ifMatchVersion(r)
+			if err != nil {
 				writeJSONError(w, http.StatusBadRequest, err.Error())
 				return
 			}
-			conflicts, err := h.svc.UpdateOrder(id, upd)
+			upd.Version = version
+			h.updateOrder(w, r, id, upd)
+
+		case http.MethodPatch:
+			if !isMergePatch(r) {
+				writeJSONError(w, http.StatusUnsupportedMediaType, "PATCH requires application/merge-patch+json")
+				return
+			}
+			order, err := h.svc.GetOrderById(r.Context(), id)
 			if err != nil {
-				slog.Error("UpdateOrder failed",
+				logger.Error("GetOrderById failed",
 					slog.String("order_id", id),
 					slog.Any("error", err),
 				)
-				switch {
-				case strings.Contains(err.Error(), "not found"):
-					writeJSONError(w, http.StatusNotFound, err.Error())
-				case err.Error() == "items cannot be empty":
-					writeJSONError(w, http.StatusBadRequest, err.Error())
-				default:
-					writeJSONError(w, http.StatusInternalServerError, err.Error())
-				}
+				writeError(w, ifMatchError(r, err))
 				return
 			}
-			if len(conflicts) != 0 {
-				msg := strings.Join(conflicts, "\n")
-				slog.Warn("UpdateOrder conflicts",
-					slog.String("order_id", id),
-					slog.String("conflicts", msg),
+			upd, err := applyMergePatch(r, order)
+			if err != nil {
+				logger.Error("Apply order patch failed",
+					slog.Any("error", err),
 				)
-				writeJSONError(w, http.StatusBadRequest, msg)
+				writeDecodeError(w, err)
 				return
 			}
-			w.WriteHeader(http.StatusNoContent)
+			version, err := ifMatchVersion(r)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if version == 0 {
+				version = order.Version
+			}
+			upd.Version = version
+			h.updateOrder(w, r, id, upd)
 
 		case http.MethodDelete:
-			if err := h.svc.DeleteOrder(id); err != nil {
-				slog.Error("DeleteOrder failed",
+			version, err := ifMatchVersion(r)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if err := h.svc.DeleteOrder(r.Context(), id, version); err != nil {
+				logger.Error("DeleteOrder failed",
 					slog.String("order_id", id),
 					slog.Any("error", err),
 				)
-				if strings.Contains(err.Error(), "not found") {
-					writeJSONError(w, http.StatusNotFound, err.Error())
-				} else {
-					writeJSONError(w, http.StatusInternalServerError, err.Error())
-				}
+				writeError(w, ifMatchError(r, err))
 				return
 			}
 			w.WriteHeader(http.StatusNoContent)
@@ -184,7 +213,8 @@ func (h *OrderHandler) OrderByID(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *OrderHandler) GetTotalSales(w http.ResponseWriter, r *http.Request) {
-	slog.Info("GetTotalSales endpoint hit",
+	logger := logging.FromContext(r.Context())
+	logger.Info("GetTotalSales endpoint hit",
 		slog.String("method", r.Method),
 		slog.String("path", r.URL.Path),
 	)
@@ -193,24 +223,29 @@ func (h *OrderHandler) GetTotalSales(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	totalSales, err := h.svc.GetTotalSales()
+	totalSales, err := h.svc.GetTotalSales(r.Context())
 	if err != nil {
-		slog.Error("GetTotalSales failed",
+		logger.Error("GetTotalSales failed",
 			slog.Any("error", err),
 		)
-		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		writeError(w, err)
+		return
+	}
+	if wantsCSV(w, r) {
+		writeCSV(w, "total-sales", totalSalesCSVHeader, []models.Total{totalSales}, totalSalesCSVRecords)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(totalSales); err != nil {
-		slog.Error("Encode totalSales failed",
+		logger.Error("Encode totalSales failed",
 			slog.Any("error", err),
 		)
 	}
 }
 
 func (h *OrderHandler) GetPopularMenuItems(w http.ResponseWriter, r *http.Request) {
-	slog.Info("GetPopularMenuItems endpoint hit",
+	logger := logging.FromContext(r.Context())
+	logger.Info("GetPopularMenuItems endpoint hit",
 		slog.String("method", r.Method),
 		slog.String("path", r.URL.Path),
 	)
@@ -218,18 +253,150 @@ func (h *OrderHandler) GetPopularMenuItems(w http.ResponseWriter, r *http.Reques
 		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
 		return
 	}
-	items, err := h.svc.GetPopularMenuItems()
+	query := service.PopularItemsQuery{Limit: 3, SortBy: r.URL.Query().Get("sort_by")}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			writeError(w, service.NewValidationError("limit", "must be a non-negative integer"))
+			return
+		}
+		query.Limit = limit
+	}
+	if query.SortBy != "" && query.SortBy != service.SortByQuantity && query.SortBy != service.SortByRevenue {
+		writeError(w, service.NewValidationError("sort_by", "must be quantity or revenue"))
+		return
+	}
+	from, to, err := parseDateRange(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	query.From, query.To = from, to
+
+	items, err := h.svc.GetPopularMenuItems(r.Context(), query)
 	if err != nil {
-		slog.Error("GetPopularMenuItems failed",
+		logger.Error("GetPopularMenuItems failed",
 			slog.Any("error", err),
 		)
-		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		writeError(w, err)
+		return
+	}
+	if wantsCSV(w, r) {
+		writeCSV(w, "popular-items", popularItemCSVHeader, items, popularItemCSVRecords)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(items); err != nil {
-		slog.Error("Encode popular items failed",
+		logger.Error("Encode popular items failed",
+			slog.Any("error", err),
+		)
+	}
+}
+
+func (h *OrderHandler) GetIngredientConsumption(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	logger.Info("GetIngredientConsumption endpoint hit",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+	)
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	from, to, err := parseDateRange(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	consumption, err := h.svc.GetIngredientConsumption(r.Context(), from, to)
+	if err != nil {
+		logger.Error("GetIngredientConsumption failed",
+			slog.Any("error", err),
+		)
+		writeError(w, err)
+		return
+	}
+	if wantsCSV(w, r) {
+		writeCSV(w, "ingredient-consumption", consumptionCSVHeader, consumption, consumptionCSVRecords)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(consumption); err != nil {
+		logger.Error("Encode ingredient consumption failed",
+			slog.Any("error", err),
+		)
+	}
+}
+
+func (h *OrderHandler) GetInventoryForecast(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	logger.Info("GetInventoryForecast endpoint hit",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+	)
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, service.NewValidationError("days", "must be a positive integer"))
+			return
+		}
+		days = n
+	}
+	forecast, err := h.svc.GetInventoryForecast(r.Context(), days)
+	if err != nil {
+		logger.Error("GetInventoryForecast failed",
 			slog.Any("error", err),
 		)
+		writeError(w, err)
+		return
+	}
+	if wantsCSV(w, r) {
+		writeCSV(w, "inventory-forecast", forecastCSVHeader, forecast, forecastCSVRecords)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(forecast); err != nil {
+		logger.Error("Encode inventory forecast failed",
+			slog.Any("error", err),
+		)
+	}
+}
+
+func parseOrderQuery(q url.Values) (models.OrderQuery, error) {
+	page, err := parsePage(q, "order_id", "customer_name", "status", "created_at")
+	if err != nil {
+		return models.OrderQuery{}, err
+	}
+	from, to, err := parseDateRange(q)
+	if err != nil {
+		return models.OrderQuery{}, err
+	}
+	return models.OrderQuery{
+		Page:         page,
+		Status:       q.Get("status"),
+		CustomerName: q.Get("customer_name"),
+		CustomerID:   q.Get("customer_id"),
+		ProductID:    q.Get("product_id"),
+		From:         from,
+		To:           to,
+	}, nil
+}
+
+func (h *OrderHandler) updateOrder(w http.ResponseWriter, r *http.Request, id string, upd models.Order) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	if err := h.svc.UpdateOrder(ctx, id, upd); err != nil {
+		logger.Error("UpdateOrder failed",
+			slog.String("order_id", id),
+			slog.Any("error", err),
+		)
+		writeError(w, ifMatchError(r, err))
+		return
 	}
+	w.WriteHeader(http.StatusNoContent)
 }
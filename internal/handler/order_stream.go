@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"hot-coffee/internal/logging"
+	"hot-coffee/models"
+)
+
+const (
+	// streamHeartbeat is how often an idle stream sends a comment, so proxies
+	// keep the connection open and dead clients are noticed.
+	streamHeartbeat = 15 * time.Second
+	// streamRetry is the reconnection delay suggested to EventSource clients.
+	streamRetry = 3 * time.Second
+)
+
+// Stream serves GET /orders/stream: order events as Server-Sent Events. A
+// client resuming with Last-Event-ID (or ?last_event_id=) first receives the
+// events it missed; if they are no longer buffered it gets a "resync" event
+// and should reload GET /orders. ?status=open,closed limits the stream to
+// events of orders that have, or had before the change, one of the statuses.
+func (h *OrderHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	logger.Info("Stream endpoint hit",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+	)
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	rc := http.NewResponseController(w)
+	// A stream outlives the server's write timeout.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logger.Warn("Clear write deadline failed", slog.Any("error", err))
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	statuses := map[string]bool{}
+	for _, status := range strings.Split(r.URL.Query().Get("status"), ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			statuses[status] = true
+		}
+	}
+	wanted := func(event models.OrderEvent) bool {
+		return len(statuses) == 0 || statuses[event.Order.Status] || statuses[event.PreviousStatus]
+	}
+
+	sub := h.svc.SubscribeOrderEvents(r.Context(), lastEventID)
+	defer sub.Cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+	if sub.Gap {
+		fmt.Fprint(w, "event: resync\ndata: {}\n\n")
+	}
+	for _, event := range sub.Backlog {
+		if wanted(event) {
+			writeEvent(w, event)
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		logger.Error("Flush stream failed", slog.Any("error", err))
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				// Too far behind or shutting down; the client reconnects
+				// and resumes from its last event.
+				return
+			}
+			if !wanted(event) {
+				continue
+			}
+			writeEvent(w, event)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			logger.Info("Stream closed", slog.Any("error", err))
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event models.OrderEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Encode order event failed", slog.String("event_id", event.ID), slog.Any("error", err))
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hot-coffee/internal/service"
+	"hot-coffee/models"
+)
+
+// streamService answers subscriptions with a fixed backlog and an already
+// closed event channel, so Stream returns once the backlog is written.
+type streamService struct {
+	service.OrderService
+	backlog     []models.OrderEvent
+	gap         bool
+	lastEventID string
+}
+
+func (s *streamService) SubscribeOrderEvents(_ context.Context, lastEventID string) *service.OrderSubscription {
+	s.lastEventID = lastEventID
+	events := service.NewOrderEvents(1)
+	sub := events.Subscribe("")
+	sub.Cancel()
+	sub.Backlog, sub.Gap = s.backlog, s.gap
+	return sub
+}
+
+func TestStreamResume(t *testing.T) {
+	backlog := []models.OrderEvent{
+		{ID: "e-2", Type: models.OrderCreated, Order: models.Order{ID: "o2", Status: "open"}},
+		{ID: "e-3", Type: models.OrderClosed, Order: models.Order{ID: "o1", Status: "closed"}, PreviousStatus: "open"},
+	}
+	tests := []struct {
+		name       string
+		header     string
+		target     string
+		gap        bool
+		wantResume string
+		want       []string
+		dontWant   []string
+	}{
+		{
+			name:       "Last-Event-ID header replays the backlog",
+			header:     "e-1",
+			target:     "/orders/stream",
+			wantResume: "e-1",
+			want:       []string{"id: e-2\nevent: order.created\n", "id: e-3\nevent: order.closed\n"},
+			dontWant:   []string{"event: resync"},
+		},
+		{
+			name:       "query parameter for clients that cannot set headers",
+			target:     "/orders/stream?last_event_id=e-1",
+			wantResume: "e-1",
+			want:       []string{"id: e-2\n", "id: e-3\n"},
+		},
+		{
+			name:       "header wins over the query parameter",
+			header:     "e-1",
+			target:     "/orders/stream?last_event_id=e-0",
+			wantResume: "e-1",
+		},
+		{
+			name:       "status filter applies to the backlog",
+			header:     "e-1",
+			target:     "/orders/stream?status=closed",
+			wantResume: "e-1",
+			want:       []string{"id: e-3\n"},
+			dontWant:   []string{"id: e-2\n"},
+		},
+		{
+			name:       "lost events ask for a resync",
+			header:     "old-7",
+			target:     "/orders/stream",
+			gap:        true,
+			wantResume: "old-7",
+			want:       []string{"event: resync\ndata: {}\n\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &streamService{gap: tt.gap}
+			if !tt.gap {
+				svc.backlog = backlog
+			}
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set("Last-Event-ID", tt.header)
+			}
+			w := httptest.NewRecorder()
+			NewOrderHandler(svc).Stream(w, r)
+
+			if svc.lastEventID != tt.wantResume {
+				t.Errorf("resumed after %q, want %q", svc.lastEventID, tt.wantResume)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			body := w.Body.String()
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("stream missing %q:\n%s", want, body)
+				}
+			}
+			for _, dontWant := range tt.dontWant {
+				if strings.Contains(body, dontWant) {
+					t.Errorf("stream contains %q:\n%s", dontWant, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+)
+
+// isMergePatch reports whether the PATCH body is declared as a JSON Merge
+// Patch. Anything else, including plain application/json and a missing
+// Content-Type, is refused so a JSON Patch or a full document is never
+// misread as a merge patch.
+func isMergePatch(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/merge-patch+json"
+}
+
+// applyMergePatch applies the RFC 7396 merge patch in the request body to
+// current and decodes the result back into a fresh value.
+func applyMergePatch[T any](r *http.Request, current T) (T, error) {
+	var patched T
+	var patch any
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		return patched, err
+	}
+	if _, ok := patch.(map[string]any); !ok {
+		return patched, errors.New("merge patch must be a JSON object")
+	}
+
+	raw, err := json.Marshal(current)
+	if err != nil {
+		return patched, err
+	}
+	var target any
+	if err := json.Unmarshal(raw, &target); err != nil {
+		return patched, err
+	}
+	raw, err = json.Marshal(mergePatch(target, patch))
+	if err != nil {
+		return patched, err
+	}
+	err = json.Unmarshal(raw, &patched)
+	return patched, err
+}
+
+func mergePatch(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+	return targetObj
+}
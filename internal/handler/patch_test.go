@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"hot-coffee/internal/service"
+	"hot-coffee/models"
+)
+
+func TestIsMergePatch(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{contentType: "application/merge-patch+json", want: true},
+		{contentType: "application/merge-patch+json; charset=utf-8", want: true},
+		{contentType: "application/json", want: false},
+		{contentType: "application/json-patch+json", want: false},
+		{contentType: "", want: false},
+		{contentType: "not a media type", want: false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("PATCH", "/menu/latte", nil)
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		if got := isMergePatch(r); got != tt.want {
+			t.Errorf("isMergePatch(%q) = %v, want %v", tt.contentType, got, tt.want)
+		}
+	}
+}
+
+// TestMergePatch runs the examples of RFC 7396, Appendix A.
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		patch  string
+		want   string
+	}{
+		{name: "replace a member", target: `{"a":"b"}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{name: "add a member", target: `{"a":"b"}`, patch: `{"b":"c"}`, want: `{"a":"b","b":"c"}`},
+		{name: "null deletes a member", target: `{"a":"b"}`, patch: `{"a":null}`, want: `{}`},
+		{name: "null keeps the others", target: `{"a":"b","b":"c"}`, patch: `{"a":null}`, want: `{"b":"c"}`},
+		{name: "null of a missing member", target: `{"a":"b"}`, patch: `{"c":null}`, want: `{"a":"b"}`},
+		{name: "array replaces a string", target: `{"a":["b"]}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{name: "string replaces an array", target: `{"a":"c"}`, patch: `{"a":["b"]}`, want: `{"a":["b"]}`},
+		{name: "nested objects merge", target: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, want: `{"a":{"b":"d"}}`},
+		{name: "arrays are replaced, not merged", target: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, want: `{"a":[1]}`},
+		{name: "array replaces an array", target: `["a","b"]`, patch: `["c","d"]`, want: `["c","d"]`},
+		{name: "array replaces an object", target: `{"a":"b"}`, patch: `["c"]`, want: `["c"]`},
+		{name: "null patch replaces the target", target: `{"a":"foo"}`, patch: `null`, want: `null`},
+		{name: "string patch replaces the target", target: `{"a":"foo"}`, patch: `"bar"`, want: `"bar"`},
+		{name: "null already in the target is kept", target: `{"e":null}`, patch: `{"a":1}`, want: `{"a":1,"e":null}`},
+		{name: "object patch of a non-object", target: `[1,2]`, patch: `{"a":"b","c":null}`, want: `{"a":"b"}`},
+		{name: "nulls inside a new object are dropped", target: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, want: `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decode := func(raw string) any {
+				var v any
+				if err := json.Unmarshal([]byte(raw), &v); err != nil {
+					t.Fatal(err)
+				}
+				return v
+			}
+			if got := mergePatch(decode(tt.target), decode(tt.patch)); !reflect.DeepEqual(got, decode(tt.want)) {
+				t.Errorf("mergePatch(%s, %s) = %v, want %s", tt.target, tt.patch, got, tt.want)
+			}
+		})
+	}
+}
+
+// patchOrders keeps a single order and checks versions like the repository.
+type patchOrders struct {
+	service.OrderService
+	order models.Order
+}
+
+func (s *patchOrders) GetOrderById(_ context.Context, id string) (models.Order, error) {
+	if id != s.order.ID {
+		return models.Order{}, fmt.Errorf("order %s: %w", id, service.ErrNotFound)
+	}
+	return s.order, nil
+}
+
+func (s *patchOrders) UpdateOrder(_ context.Context, id string, order models.Order) error {
+	if order.Version != s.order.Version {
+		return fmt.Errorf("order %s is at version %d: %w", id, s.order.Version, service.ErrVersionMismatch)
+	}
+	order.ID, order.Version = id, s.order.Version+1
+	s.order = order
+	return nil
+}
+
+func TestPatchOrder(t *testing.T) {
+	svc := &patchOrders{order: models.Order{
+		ID: "o1", CustomerName: "Ana", Status: "open", Version: 3,
+		Items: []models.OrderItem{{ProductID: "latte", Quantity: 1, UnitPrice: 3.5, Status: models.LinePending}},
+	}}
+	h := NewOrderHandler(svc).OrderByID
+	send := func(method, body, ifMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/orders/o1", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	etag := send(http.MethodGet, "", "").Header().Get("ETag")
+	if etag != `"3"` {
+		t.Fatalf("GET ETag = %s, want \"3\"", etag)
+	}
+	if w := send(http.MethodPatch, `{"customer_name":"Bo"}`, etag); w.Code != http.StatusNoContent {
+		t.Fatalf("PATCH with the current ETag = %d: %s", w.Code, w.Body)
+	}
+	if svc.order.CustomerName != "Bo" || len(svc.order.Items) != 1 || svc.order.Items[0].UnitPrice != 3.5 {
+		t.Errorf("patched order = %+v, want the name changed and the rest kept", svc.order)
+	}
+	if got := send(http.MethodGet, "", "").Header().Get("ETag"); got != `"4"` {
+		t.Errorf("ETag after PATCH = %s, want \"4\"", got)
+	}
+
+	if w := send(http.MethodPatch, `{"customer_name":"Cy"}`, etag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PATCH with the stale ETag = %d, want 412", w.Code)
+	}
+	if svc.order.CustomerName != "Bo" {
+		t.Errorf("stale PATCH changed the order to %+v", svc.order)
+	}
+	// Without If-Match the patch applies to the version it was merged into.
+	if w := send(http.MethodPatch, `{"items":[{"product_id":"mocha","quantity":2}]}`, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("PATCH without If-Match = %d: %s", w.Code, w.Body)
+	}
+	if len(svc.order.Items) != 1 || svc.order.Items[0].ProductID != "mocha" || svc.order.CustomerName != "Bo" {
+		t.Errorf("order after replacing the items = %+v", svc.order)
+	}
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"hot-coffee/internal/logging"
+	"hot-coffee/internal/service"
+)
+
+// Stations serves GET /stations: every prep station with its number of
+// pending lines.
+func (h *OrderHandler) Stations(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	logger.Info("Stations endpoint hit",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+	)
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	stations, err := h.svc.GetStations(r.Context())
+	if err != nil {
+		logger.Error("GetStations failed",
+			slog.Any("error", err),
+		)
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stations); err != nil {
+		logger.Error("Encode stations failed",
+			slog.Any("error", err),
+		)
+	}
+}
+
+// StationQueue serves GET /stations/{station}/queue: the pending lines of
+// open orders routed to the station, oldest order first.
+func (h *OrderHandler) StationQueue(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	logger.Info("StationQueue endpoint hit",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+	)
+	station, action := splitAction(r.URL.Path, "/stations/")
+	if station == "" || action != "queue" {
+		writeJSONError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	lines, err := h.svc.GetStationQueue(r.Context(), station)
+	if err != nil {
+		logger.Error("GetStationQueue failed",
+			slog.String("station", station),
+			slog.Any("error", err),
+		)
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(lines); err != nil {
+		logger.Error("Encode station queue failed",
+			slog.Any("error", err),
+		)
+	}
+}
+
+// bumpLine serves POST /orders/{id}/items/{line}/done and answers with the
+// updated order. If-Match is optional.
+func (h *OrderHandler) bumpLine(w http.ResponseWriter, r *http.Request, id, lineParam string) {
+	logger := logging.FromContext(r.Context())
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	line, err := strconv.Atoi(lineParam)
+	if err != nil {
+		writeError(w, service.NewValidationError("line", "must be an integer"))
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	order, err := h.svc.BumpOrderLine(r.Context(), id, line, version)
+	if err != nil {
+		logger.Error("BumpOrderLine failed",
+			slog.String("order_id", id),
+			slog.Int("line", line),
+			slog.Any("error", err),
+		)
+		writeError(w, ifMatchError(r, err))
+		return
+	}
+	w.Header().Set("ETag", versionETag(order.Version))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(order); err != nil {
+		logger.Error("Encode order failed",
+			slog.Any("error", err),
+		)
+	}
+}
@@ -1,16 +1,119 @@
 package handler
 
 import (
-	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"hot-coffee/internal/service"
+	"hot-coffee/models"
 )
 
-type errorResponse struct {
-	Error string `json:"error"`
+// parseDateRange reads the optional "from" and "to" query parameters. Both
+// accept RFC 3339 timestamps or plain dates; a plain "to" date covers the
+// whole day, so the returned upper bound is exclusive.
+func parseDateRange(q url.Values) (time.Time, time.Time, error) {
+	var from, to time.Time
+	if v := q.Get("from"); v != "" {
+		t, _, err := parseDate(v)
+		if err != nil {
+			return from, to, service.NewValidationError("from", "must be a date (YYYY-MM-DD) or an RFC 3339 timestamp")
+		}
+		from = t
+	}
+	if v := q.Get("to"); v != "" {
+		t, dateOnly, err := parseDate(v)
+		if err != nil {
+			return from, to, service.NewValidationError("to", "must be a date (YYYY-MM-DD) or an RFC 3339 timestamp")
+		}
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		to = t
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return from, to, service.NewValidationError("to", "must be after from")
+	}
+	return from, to, nil
+}
+
+func parseDate(v string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, false, err
+}
+
+// parsePage reads "offset", "limit" and "sort" from the query. A leading "-"
+// on the sort field requests descending order.
+func parsePage(q url.Values, sortFields ...string) (models.Page, error) {
+	var page models.Page
+	for name, dst := range map[string]*int{"offset": &page.Offset, "limit": &page.Limit} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return page, service.NewValidationError(name, "must be a non-negative integer")
+		}
+		*dst = n
+	}
+	if sort := q.Get("sort"); sort != "" {
+		page.Sort, page.Desc = strings.TrimPrefix(sort, "-"), strings.HasPrefix(sort, "-")
+		if !slices.Contains(sortFields, page.Sort) {
+			return page, service.NewValidationError("sort", "must be one of "+strings.Join(sortFields, ", "))
+		}
+	}
+	return page, nil
+}
+
+// parseArchived reads the "archived" list filter: absent lists active items,
+// "include" lists every item and "only" lists archived items.
+func parseArchived(q url.Values) (models.ArchivedFilter, error) {
+	switch f := models.ArchivedFilter(q.Get("archived")); f {
+	case models.ArchivedExclude, models.ArchivedInclude, models.ArchivedOnly:
+		return f, nil
+	}
+	return "", service.NewValidationError("archived", "must be include or only")
+}
+
+// splitAction separates a trailing action such as "archive" from a record
+// path: "/menu/latte/archive" yields "latte" and "archive".
+func splitAction(path, prefix string) (id, action string) {
+	id = strings.TrimPrefix(path, prefix)
+	if i := strings.LastIndex(id, "/"); i >= 0 {
+		return id[:i], id[i+1:]
+	}
+	return id, ""
 }
 
-func writeJSONError(w http.ResponseWriter, status int, msg string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	_ = json.NewEncoder(w).Encode(errorResponse{Error: msg})
+// setPageHeaders reports the size of the filtered collection and, for
+// limited pages, links to the neighbouring pages.
+func setPageHeaders(w http.ResponseWriter, r *http.Request, page models.Page, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if page.Limit <= 0 {
+		return
+	}
+	link := func(offset int, rel string) string {
+		q := r.URL.Query()
+		q.Set("offset", strconv.Itoa(offset))
+		q.Set("limit", strconv.Itoa(page.Limit))
+		return fmt.Sprintf("<%s?%s>; rel=%q", r.URL.Path, q.Encode(), rel)
+	}
+	var links []string
+	if page.Offset+page.Limit < total {
+		links = append(links, link(page.Offset+page.Limit, "next"))
+	}
+	if page.Offset > 0 {
+		links = append(links, link(max(page.Offset-page.Limit, 0), "prev"))
+	}
+	if len(links) != 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
 }
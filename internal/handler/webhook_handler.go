@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"hot-coffee/internal/logging"
+	"hot-coffee/internal/service"
+	"hot-coffee/models"
+)
+
+type WebhookHandler struct {
+	svc service.WebhookService
+}
+
+func NewWebhookHandler(webhookService service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{svc: webhookService}
+}
+
+// Webhooks serves GET /webhooks, listing the subscriptions without their
+// secrets, and POST /webhooks, creating one:
+// {"url": "...", "events": ["order.closed"], "secret": "..."}.
+func (h *WebhookHandler) Webhooks(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	logger.Info("Webhooks endpoint hit",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+	)
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := h.svc.ListWebhooks(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeAdminJSON(r, w, http.StatusOK, hooks)
+
+	case http.MethodPost:
+		var hook models.Webhook
+		if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+			logger.Error("Decode webhook failed",
+				slog.Any("error", err),
+			)
+			writeDecodeError(w, err)
+			return
+		}
+		created, err := h.svc.CreateWebhook(r.Context(), hook)
+		if err != nil {
+			logger.Error("CreateWebhook failed",
+				slog.Any("error", err),
+			)
+			writeError(w, err)
+			return
+		}
+		writeAdminJSON(r, w, http.StatusCreated, created)
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+	}
+}
+
+// WebhookByID serves GET and DELETE /webhooks/{id} and
+// GET /webhooks/{id}/deliveries, the delivery log newest first, optionally
+// filtered with ?status=pending|delivered|failed.
+func (h *WebhookHandler) WebhookByID(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	logger.Info("WebhookByID endpoint hit",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+	)
+	rest := strings.TrimPrefix(r.URL.Path, "/webhooks/")
+	id, action, _ := strings.Cut(rest, "/")
+	switch {
+	case id == "":
+		writeJSONError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+
+	case action == "deliveries":
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		deliveries, err := h.svc.ListDeliveries(r.Context(), id, r.URL.Query().Get("status"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeAdminJSON(r, w, http.StatusOK, deliveries)
+
+	case action != "":
+		writeJSONError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+
+	case r.Method == http.MethodGet:
+		hook, err := h.svc.GetWebhook(r.Context(), id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeAdminJSON(r, w, http.StatusOK, hook)
+
+	case r.Method == http.MethodDelete:
+		if err := h.svc.DeleteWebhook(r.Context(), id); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+	}
+}
@@ -0,0 +1,30 @@
+package metrics
+
+// Metrics recorded by the HTTP layer, the services and the repositories.
+// Gauges that mirror stored data are registered at startup with
+// NewGaugeFunc.
+var (
+	HTTPRequests = NewCounterVec("hotcoffee_http_requests_total",
+		"HTTP requests served, by route, method and status code.", "route", "method", "code")
+	HTTPDuration = NewHistogramVec("hotcoffee_http_request_duration_seconds",
+		"Time taken to serve HTTP requests, by route and method.", DefaultBuckets, "route", "method")
+
+	OrdersCreated = NewCounterVec("hotcoffee_orders_created_total",
+		"Orders created.")
+	OrdersClosed = NewCounterVec("hotcoffee_orders_closed_total",
+		"Orders closed.")
+	OrdersCancelled = NewCounterVec("hotcoffee_orders_cancelled_total",
+		"Orders deleted before they were closed.")
+	OrdersRejected = NewCounterVec("hotcoffee_orders_rejected_total",
+		"Order creations and updates refused, by reason.", "reason")
+	StockConflicts = NewCounterVec("hotcoffee_stock_conflicts_total",
+		"Ingredients that were short when an order was refused for insufficient stock.", "ingredient_id")
+
+	RepositoryIO = NewHistogramVec("hotcoffee_repository_io_duration_seconds",
+		"Time spent reading and writing the JSON data files, by file and operation.", DefaultBuckets, "file", "op")
+	DataReloads = NewCounterVec("hotcoffee_data_reloads_total",
+		"External edits of data files picked up or rejected by the file watcher, by file and result.", "file", "result")
+
+	WebhookDeliveries = NewCounterVec("hotcoffee_webhook_deliveries_total",
+		"Webhook delivery attempts, by event type and result.", "event", "result")
+)
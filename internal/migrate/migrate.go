@@ -0,0 +1,301 @@
+// Package migrate upgrades the data files to the schema this binary
+// understands. The schema version of a data directory is kept in
+// schema.json next to the data files; a directory without one is at
+// version 0, the layout from before versioning.
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"hot-coffee/internal/repository"
+)
+
+var dataFiles = []string{"orders.json", "menu_items.json", "inventory.json", "customers.json"}
+
+// optionalFiles may be missing in directories from before they existed;
+// they are read as empty and written by Apply.
+var optionalFiles = map[string]bool{"customers.json": true}
+
+// Data holds the data files as generic JSON records keyed by file name, so
+// migrations keep working however the models change later.
+type Data map[string][]map[string]any
+
+// Migration upgrades data from Version-1 to Version. Migrations must be
+// idempotent: a run interrupted before schema.json is written is repeated
+// in full.
+type Migration struct {
+	Version int
+	Name    string
+	Apply   func(data Data) error
+}
+
+// registry lists every migration in version order, starting at 1.
+var registry = []Migration{
+	{Version: 1, Name: "explicit record versions", Apply: explicitVersions},
+	{Version: 2, Name: "explicit archived flag", Apply: explicitArchived},
+	{Version: 3, Name: "order line status", Apply: lineStatus},
+	{Version: 4, Name: "customers file and order line prices", Apply: linePrices},
+}
+
+// Current is the schema version this binary reads and writes.
+var Current = registry[len(registry)-1].Version
+
+// Schema is the content of schema.json.
+type Schema struct {
+	Version    int    `json:"version"`
+	MigratedAt string `json:"migrated_at,omitempty"`
+}
+
+// TooNewError refuses data written by a newer binary, whose fields this one
+// would silently drop.
+type TooNewError struct {
+	Data   int
+	Binary int
+}
+
+func (e *TooNewError) Error() string {
+	return fmt.Sprintf("data schema version %d is newer than this binary supports (%d); upgrade hot-coffee", e.Data, e.Binary)
+}
+
+// Is makes a TooNewError a repository.ErrConflict, so restoring such a
+// snapshot is answered like any other conflicting state.
+func (e *TooNewError) Is(target error) bool {
+	return target == repository.ErrConflict
+}
+
+// ReadVersion returns the schema version of the data in dir.
+func ReadVersion(dir string) (int, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, repository.SchemaFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return parseVersion(raw)
+}
+
+func parseVersion(raw []byte) (int, error) {
+	var schema Schema
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return 0, fmt.Errorf("%s: %w", repository.SchemaFile, err)
+	}
+	return schema.Version, nil
+}
+
+// Pending returns the schema version of dir and the migrations that bring
+// it to Current, or a TooNewError.
+func Pending(dir string) (int, []Migration, error) {
+	version, err := ReadVersion(dir)
+	if err != nil {
+		return 0, nil, err
+	}
+	steps, err := stepsFrom(version)
+	return version, steps, err
+}
+
+func stepsFrom(version int) ([]Migration, error) {
+	if version > Current {
+		return nil, &TooNewError{Data: version, Binary: Current}
+	}
+	var steps []Migration
+	for _, m := range registry {
+		if m.Version > version {
+			steps = append(steps, m)
+		}
+	}
+	return steps, nil
+}
+
+// Apply runs steps in order on the data files of dir and then records the
+// last step's version in schema.json. Nothing is written if a step fails.
+// The server must not be running.
+func Apply(dir string, steps []Migration) error {
+	if len(steps) == 0 {
+		return nil
+	}
+	data := Data{}
+	for _, name := range dataFiles {
+		raw, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) && optionalFiles[name] {
+			raw = []byte("[]")
+		} else if err != nil {
+			return err
+		}
+		if err := data.decode(name, raw); err != nil {
+			return err
+		}
+	}
+	if err := run(data, steps); err != nil {
+		return err
+	}
+	for _, name := range dataFiles {
+		records := data[name]
+		if records == nil {
+			records = []map[string]any{}
+		}
+		write := repository.WriteJSONFile
+		if name == "customers.json" {
+			write = repository.WritePrivateJSONFile
+		}
+		if err := write(filepath.Join(dir, name), records); err != nil {
+			return err
+		}
+	}
+	return repository.WriteJSONFile(filepath.Join(dir, repository.SchemaFile), schemaAt(steps))
+}
+
+// Upgrade is Apply for data files held in memory, such as the content of
+// a snapshot being restored: files maps file names to their content and
+// holds schema.json unless the data predates versioning. The data files
+// are replaced by their upgraded content and schema.json by Current. A
+// data file missing from files stays missing. Data newer than Current is
+// refused with a TooNewError.
+func Upgrade(files map[string][]byte) error {
+	version := 0
+	if raw, ok := files[repository.SchemaFile]; ok {
+		var err error
+		if version, err = parseVersion(raw); err != nil {
+			return err
+		}
+	}
+	steps, err := stepsFrom(version)
+	if err != nil || len(steps) == 0 {
+		return err
+	}
+	data := Data{}
+	for _, name := range dataFiles {
+		if raw, ok := files[name]; ok {
+			if err := data.decode(name, raw); err != nil {
+				return err
+			}
+		}
+	}
+	if err := run(data, steps); err != nil {
+		return err
+	}
+	for name, records := range data {
+		raw, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		files[name] = raw
+	}
+	raw, err := json.MarshalIndent(schemaAt(steps), "", "  ")
+	if err != nil {
+		return err
+	}
+	files[repository.SchemaFile] = raw
+	return nil
+}
+
+func (d Data) decode(name string, raw []byte) error {
+	var records []map[string]any
+	if err := json.Unmarshal(raw, &records); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if records == nil {
+		records = []map[string]any{}
+	}
+	d[name] = records
+	return nil
+}
+
+func run(data Data, steps []Migration) error {
+	for _, m := range steps {
+		if err := m.Apply(data); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+func schemaAt(steps []Migration) Schema {
+	return Schema{Version: steps[len(steps)-1].Version, MigratedAt: time.Now().UTC().Format(time.RFC3339)}
+}
+
+// explicitVersions writes version 1 into records stored before optimistic
+// locking, which the repositories otherwise assume on every read.
+func explicitVersions(data Data) error {
+	for _, records := range data {
+		for _, record := range records {
+			if v, ok := record["version"].(float64); !ok || v < 1 {
+				record["version"] = 1
+			}
+		}
+	}
+	return nil
+}
+
+// explicitArchived writes archived=false into menu and inventory records
+// stored before archiving existed.
+func explicitArchived(data Data) error {
+	for _, name := range []string{"menu_items.json", "inventory.json"} {
+		for _, record := range data[name] {
+			if _, ok := record["archived"].(bool); !ok {
+				record["archived"] = false
+			}
+		}
+	}
+	return nil
+}
+
+// lineStatus gives order lines stored before station queues a status: the
+// lines of closed orders count as done, all others as pending.
+func lineStatus(data Data) error {
+	for _, order := range data["orders.json"] {
+		items, _ := order["items"].([]any)
+		for _, item := range items {
+			line, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			if _, ok := line["status"].(string); ok {
+				continue
+			}
+			if order["status"] == "closed" {
+				line["status"] = "done"
+			} else {
+				line["status"] = "pending"
+			}
+		}
+	}
+	return nil
+}
+
+// linePrices gives order lines stored before prices were kept on the line
+// the current menu price of their product, which is what reports charged
+// them at until then. Apply has already created an empty customers.json.
+func linePrices(data Data) error {
+	prices := make(map[string]any, len(data["menu_items.json"]))
+	for _, item := range data["menu_items.json"] {
+		if id, ok := item["product_id"].(string); ok {
+			prices[id] = item["price"]
+		}
+	}
+	for _, order := range data["orders.json"] {
+		items, _ := order["items"].([]any)
+		for _, item := range items {
+			line, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			if _, ok := line["unit_price"].(float64); ok {
+				continue
+			}
+			id, _ := line["product_id"].(string)
+			if price, ok := prices[id].(float64); ok {
+				line["unit_price"] = price
+			} else {
+				line["unit_price"] = 0
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"hot-coffee/internal/repository"
+)
+
+func writeJSON(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readRecords(t *testing.T, dir, name string) []map[string]any {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []map[string]any
+	if err := json.Unmarshal(raw, &records); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// legacyDir is a data directory from before schema versioning.
+func legacyDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeJSON(t, dir, "inventory.json", `[{"ingredient_id":"milk","name":"Milk","quantity":5,"unit":"ml"}]`)
+	writeJSON(t, dir, "menu_items.json", `[{"product_id":"latte","name":"Latte","price":3.5,"ingredients":[{"ingredient_id":"milk","quantity":1}]}]`)
+	writeJSON(t, dir, "orders.json", `[
+		{"order_id":"o1","customer_name":"Ana","status":"open","items":[{"product_id":"latte","quantity":1}]},
+		{"order_id":"o2","customer_name":"Bo","status":"closed","version":4,"items":[{"product_id":"latte","quantity":2,"unit_price":3}]}
+	]`)
+	return dir
+}
+
+func TestPending(t *testing.T) {
+	tests := []struct {
+		name        string
+		schema      string
+		wantVersion int
+		wantSteps   int
+		wantTooNew  bool
+	}{
+		{name: "no schema file is version 0", wantVersion: 0, wantSteps: len(registry)},
+		{name: "partly migrated", schema: `{"version":2}`, wantVersion: 2, wantSteps: len(registry) - 2},
+		{name: "current", schema: `{"version":` + itoa(Current) + `}`, wantVersion: Current, wantSteps: 0},
+		{name: "newer than the binary", schema: `{"version":` + itoa(Current+1) + `}`, wantVersion: Current + 1, wantTooNew: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := legacyDir(t)
+			if tt.schema != "" {
+				writeJSON(t, dir, repository.SchemaFile, tt.schema)
+			}
+			version, steps, err := Pending(dir)
+			var tooNew *TooNewError
+			if errors.As(err, &tooNew) != tt.wantTooNew {
+				t.Fatalf("Pending() error = %v, want too new %v", err, tt.wantTooNew)
+			}
+			if tt.wantTooNew {
+				if tooNew.Data != Current+1 || tooNew.Binary != Current {
+					t.Errorf("TooNewError = %+v", tooNew)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != tt.wantVersion || len(steps) != tt.wantSteps {
+				t.Errorf("Pending() = %d, %d steps, want %d, %d steps", version, len(steps), tt.wantVersion, tt.wantSteps)
+			}
+		})
+	}
+}
+
+func itoa(n int) string {
+	raw, _ := json.Marshal(n)
+	return string(raw)
+}
+
+func TestApplyUpgradesLegacyData(t *testing.T) {
+	dir := legacyDir(t)
+	_, steps, err := Pending(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(dir, steps); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	if version, err := ReadVersion(dir); err != nil || version != Current {
+		t.Errorf("ReadVersion() = %d, %v, want %d", version, err, Current)
+	}
+	menu := readRecords(t, dir, "menu_items.json")
+	if menu[0]["version"] != 1.0 || menu[0]["archived"] != false {
+		t.Errorf("menu item = %v, want version 1 and archived false", menu[0])
+	}
+	orders := readRecords(t, dir, "orders.json")
+	tests := []struct {
+		order     int
+		version   float64
+		status    string
+		unitPrice float64
+	}{
+		{order: 0, version: 1, status: "pending", unitPrice: 3.5},
+		// Values already present are kept.
+		{order: 1, version: 4, status: "done", unitPrice: 3},
+	}
+	for _, tt := range tests {
+		order := orders[tt.order]
+		line := order["items"].([]any)[0].(map[string]any)
+		if order["version"] != tt.version || line["status"] != tt.status || line["unit_price"] != tt.unitPrice {
+			t.Errorf("order %v = version %v, line %v; want version %v, status %s, unit_price %v",
+				order["order_id"], order["version"], line, tt.version, tt.status, tt.unitPrice)
+		}
+	}
+	if customers := readRecords(t, dir, "customers.json"); len(customers) != 0 {
+		t.Errorf("customers.json = %v, want an empty array", customers)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "customers.json")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("customers.json mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	// A second run is a no-op.
+	if _, steps, err := Pending(dir); err != nil || len(steps) != 0 {
+		t.Errorf("Pending() after Apply = %d steps, %v, want none", len(steps), err)
+	}
+}
+
+func TestApplyWritesNothingOnFailure(t *testing.T) {
+	dir := legacyDir(t)
+	writeJSON(t, dir, "menu_items.json", `{"not":"an array"}`)
+	_, steps, err := Pending(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(dir, steps); err == nil {
+		t.Fatal("Apply() succeeded on a broken file")
+	}
+	if version, _ := ReadVersion(dir); version != 0 {
+		t.Errorf("version = %d after a failed Apply, want 0", version)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "customers.json")); !os.IsNotExist(err) {
+		t.Errorf("customers.json written by a failed Apply: %v", err)
+	}
+}
+
+func snapshots(dir string, upgrade repository.Upgrader) repository.SnapshotRepository {
+	return repository.NewJSONSnapshotRepo(dir,
+		repository.NewJSONOrderRepo(dir), repository.NewJSONMenuRepo(dir), repository.NewJSONInventoryRepo(dir),
+		repository.NewJSONCustomerRepo(dir), upgrade)
+}
+
+func TestRestoreUpgradesOldSnapshots(t *testing.T) {
+	tests := []struct {
+		name        string
+		migrated    int
+		schema      string
+		wantErr     error
+		wantVersion int
+	}{
+		{name: "before versioning", wantVersion: Current},
+		{name: "partly migrated", migrated: 2, wantVersion: Current},
+		{name: "newer than the binary", schema: `{"version":` + itoa(Current+1) + `}`, wantErr: repository.ErrConflict, wantVersion: Current},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := legacyDir(t)
+			if err := Apply(dir, registry[:tt.migrated]); err != nil {
+				t.Fatal(err)
+			}
+			// customers.json only exists from version 4 on.
+			os.Remove(filepath.Join(dir, "customers.json"))
+			if tt.schema != "" {
+				writeJSON(t, dir, repository.SchemaFile, tt.schema)
+			}
+			ctx := context.Background()
+			old, err := snapshots(dir, nil).Create(ctx, "old")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The server has since moved the data to the current schema.
+			os.Remove(filepath.Join(dir, repository.SchemaFile))
+			if err := Apply(dir, registry); err != nil {
+				t.Fatal(err)
+			}
+			writeJSON(t, dir, "customers.json", `[{"customer_id":"c1","name":"Ana","version":1}]`)
+
+			_, err = snapshots(dir, Upgrade).Restore(ctx, old.ID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Restore() error = %v, want %v", err, tt.wantErr)
+			}
+			if version, err := ReadVersion(dir); err != nil || version != tt.wantVersion {
+				t.Errorf("ReadVersion() = %d, %v, want %d", version, err, tt.wantVersion)
+			}
+			line := readRecords(t, dir, "orders.json")[0]["items"].([]any)[0].(map[string]any)
+			if line["status"] != "pending" || line["unit_price"] != 3.5 {
+				t.Errorf("restored line = %v, want status and unit_price", line)
+			}
+			if menu := readRecords(t, dir, "menu_items.json")[0]; menu["archived"] != false || menu["version"] != 1.0 {
+				t.Errorf("restored menu item = %v, want archived and version", menu)
+			}
+			// The old snapshot has no customers, which are kept.
+			if customers := readRecords(t, dir, "customers.json"); len(customers) != 1 {
+				t.Errorf("customers = %v, want the current customer kept", customers)
+			}
+		})
+	}
+}
+
+func TestResetUpgradesOldFixtures(t *testing.T) {
+	fixtures := legacyDir(t)
+	dir := t.TempDir()
+	for _, name := range dataFiles {
+		writeJSON(t, dir, name, `[]`)
+	}
+	if err := Apply(dir, registry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshots(dir, Upgrade).Reset(context.Background(), []string{"orders", "menu"}, fixtures); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	line := readRecords(t, dir, "orders.json")[0]["items"].([]any)[0].(map[string]any)
+	if line["status"] != "pending" || line["unit_price"] != 3.5 {
+		t.Errorf("reset line = %v, want status and unit_price", line)
+	}
+	if inventory := readRecords(t, dir, "inventory.json"); len(inventory) != 0 {
+		t.Errorf("inventory = %v, want it left alone", inventory)
+	}
+	if version, _ := ReadVersion(dir); version != Current {
+		t.Errorf("ReadVersion() = %d, want %d", version, Current)
+	}
+}
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"hot-coffee/internal/auth"
+	"hot-coffee/internal/logging"
+	"hot-coffee/models"
+)
+
+type CustomerRepository interface {
+	Add(ctx context.Context, customer models.Customer) error
+	FindAll(ctx context.Context) ([]models.Customer, error)
+	FindByID(ctx context.Context, id string) (*models.Customer, error)
+	Update(ctx context.Context, id string, updated models.Customer) error
+	Delete(ctx context.Context, id string, version int) error
+	Flush() error
+}
+
+// jsonCustomerRepo keeps customers in customers.json, which schema version
+// 4 introduced; until a directory is migrated a missing file means no
+// customers have been registered yet.
+type jsonCustomerRepo struct {
+	dataDir string
+	mu      sync.Mutex
+	src     *source
+}
+
+func NewJSONCustomerRepo(dir string) CustomerRepository {
+	src := newSource(filepath.Join(dir, "customers.json"), checkJSON[models.Customer])
+	// Contact details are personal data; keep the file private to the
+	// server user.
+	src.perm = 0o600
+	return &jsonCustomerRepo{dataDir: dir, src: src}
+}
+
+func (r *jsonCustomerRepo) loadCustomers(ctx context.Context) ([]models.Customer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	customers, err := readOptional[models.Customer](r.src)
+	if err != nil {
+		logging.FromContext(ctx).Error("loadCustomers failed", "path", r.src.path, "err", err)
+		return nil, err
+	}
+	for i := range customers {
+		customers[i].Version = max(customers[i].Version, 1)
+	}
+	return customers, nil
+}
+
+func (r *jsonCustomerRepo) saveCustomers(ctx context.Context, customers []models.Customer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if customers == nil {
+		customers = []models.Customer{}
+	}
+	raw, err := json.MarshalIndent(customers, "", "  ")
+	if err != nil {
+		return err
+	}
+	return r.src.write(raw)
+}
+
+// customerConflict reports another customer already using the ID, email or
+// phone of c. Email addresses compare case-insensitively; empty contact
+// fields never conflict.
+func customerConflict(customers []models.Customer, skipID string, c models.Customer) error {
+	for _, other := range customers {
+		if other.ID == skipID {
+			continue
+		}
+		switch {
+		case other.ID == c.ID:
+			return &ConflictError{Entity: "customer", Field: "customer_id", Value: c.ID}
+		case c.Email != "" && strings.EqualFold(other.Email, c.Email):
+			return &ConflictError{Entity: "customer", Field: "email", Value: c.Email}
+		case c.Phone != "" && other.Phone == c.Phone:
+			return &ConflictError{Entity: "customer", Field: "phone", Value: c.Phone}
+		}
+	}
+	return nil
+}
+
+func (r *jsonCustomerRepo) Add(ctx context.Context, customer models.Customer) error {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	customers, err := r.loadCustomers(ctx)
+	if err != nil {
+		return err
+	}
+	if err := customerConflict(customers, "", customer); err != nil {
+		logger.Warn("Add: duplicate customer", "id", customer.ID, "err", err)
+		return err
+	}
+	customer.Version = 1
+	customer.UpdatedBy = auth.Actor(ctx)
+	if err := r.saveCustomers(ctx, append(customers, customer)); err != nil {
+		logger.Error("Add: saveCustomers failed", "err", err)
+		return err
+	}
+	logger.Info("Add: success", "id", customer.ID)
+	return nil
+}
+
+func (r *jsonCustomerRepo) FindAll(ctx context.Context) ([]models.Customer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadCustomers(ctx)
+}
+
+func (r *jsonCustomerRepo) FindByID(ctx context.Context, id string) (*models.Customer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	customers, err := r.loadCustomers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range customers {
+		if c.ID == id {
+			return &c, nil
+		}
+	}
+	return nil, &NotFoundError{Entity: "customer", ID: id}
+}
+
+func (r *jsonCustomerRepo) Update(ctx context.Context, id string, updated models.Customer) error {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	customers, err := r.loadCustomers(ctx)
+	if err != nil {
+		return err
+	}
+	if err := customerConflict(customers, id, updated); err != nil {
+		logger.Warn("Update: duplicate customer", "id", id, "err", err)
+		return err
+	}
+	for i, c := range customers {
+		if c.ID != id {
+			continue
+		}
+		if updated.Version != 0 && updated.Version != c.Version {
+			logger.Warn("Update: version mismatch", "id", id, "expected", updated.Version, "actual", c.Version)
+			return models.ErrVersionMismatch
+		}
+		updated.Version = c.Version + 1
+		updated.UpdatedBy = auth.Actor(ctx)
+		customers[i] = updated
+		if err := r.saveCustomers(ctx, customers); err != nil {
+			logger.Error("Update: saveCustomers failed", "err", err)
+			return err
+		}
+		logger.Info("Update: success", "id", id)
+		return nil
+	}
+	return &NotFoundError{Entity: "customer", ID: id}
+}
+
+func (r *jsonCustomerRepo) Delete(ctx context.Context, id string, version int) error {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	customers, err := r.loadCustomers(ctx)
+	if err != nil {
+		return err
+	}
+	for i, c := range customers {
+		if c.ID != id {
+			continue
+		}
+		if version != 0 && version != c.Version {
+			logger.Warn("Delete: version mismatch", "id", id, "expected", version, "actual", c.Version)
+			return models.ErrVersionMismatch
+		}
+		logger.Info("Delete customer", "id", id)
+		return r.saveCustomers(ctx, append(customers[:i], customers[i+1:]...))
+	}
+	return &NotFoundError{Entity: "customer", ID: id}
+}
+
+// Flush waits for a write in progress to finish.
+func (r *jsonCustomerRepo) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return nil
+}
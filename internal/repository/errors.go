@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("already exists")
+)
+
+// NotFoundError reports that no record of the given entity has the ID.
+type NotFoundError struct {
+	Entity string
+	ID     string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %s not found", e.Entity, e.ID)
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// ConflictError reports that a unique field is already taken by another
+// record of the entity.
+type ConflictError struct {
+	Entity string
+	Field  string
+	Value  string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s with %s %q already exists", e.Entity, e.Field, e.Value)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// StateConflictError reports a change a record's current state does not
+// allow, such as closing an order twice. Like ConflictError it matches
+// ErrConflict.
+type StateConflictError struct {
+	Entity string
+	ID     string
+	State  string
+}
+
+func (e *StateConflictError) Error() string {
+	return fmt.Sprintf("%s %s is already %s", e.Entity, e.ID, e.State)
+}
+
+func (e *StateConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// Shortage is an ingredient an adjustment needs more of than is in stock.
+type Shortage struct {
+	IngredientID string  `json:"ingredient_id"`
+	Required     float64 `json:"required"`
+	Available    float64 `json:"available"`
+	Unit         string  `json:"unit"`
+}
+
+// ShortageError reports a stock adjustment that would leave ingredients
+// below zero; nothing was written.
+type ShortageError struct {
+	Shortages []Shortage
+}
+
+func (e *ShortageError) Error() string {
+	ids := make([]string, 0, len(e.Shortages))
+	for _, s := range e.Shortages {
+		ids = append(ids, s.IngredientID)
+	}
+	return "insufficient stock of " + strings.Join(ids, ", ")
+}
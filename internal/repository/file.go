@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"hot-coffee/internal/metrics"
+)
+
+// observeIO records how long op on the data file at path took.
+func observeIO(path, op string, start time.Time) {
+	metrics.RepositoryIO.Observe(time.Since(start).Seconds(), filepath.Base(path), op)
+}
+
+// readFile reads a whole data file.
+func readFile(path string) ([]byte, error) {
+	defer observeIO(path, "read", time.Now())
+	return os.ReadFile(path)
+}
+
+// writeFileAtomic replaces path with data by writing a temporary file in the
+// same directory and renaming it over the original, so a crash or kill during
+// the write leaves either the old or the new content, never a truncated file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	defer observeIO(path, "write", time.Now())
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// WriteJSONFile stores v as an indented JSON data file at path, atomically.
+// It is meant for offline tools that edit the data directory while the
+// server is stopped.
+func WriteJSONFile(path string, v any) error {
+	raw, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, raw, 0o644)
+}
+
+// WritePrivateJSONFile is WriteJSONFile for files holding personal data,
+// which only the server user may read.
+func WritePrivateJSONFile(path string, v any) error {
+	raw, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, raw, 0o600)
+}
+
+// SchemaFile is the data directory's schema version file, maintained by
+// the migrate package and carried along in snapshots.
+const SchemaFile = "schema.json"
@@ -1,173 +1,284 @@
 package repository
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"log/slog"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 
+	"hot-coffee/internal/auth"
+	"hot-coffee/internal/logging"
 	"hot-coffee/models"
 )
 
 type InventoryRepository interface {
-	Add(item models.InventoryItem) error
-	FindAll() ([]models.InventoryItem, error)
-	FindByID(id string) (*models.InventoryItem, error)
-	Update(id string, updated models.InventoryItem) error
-	Delete(id string) error
+	Add(ctx context.Context, item models.InventoryItem) error
+	AddMany(ctx context.Context, items []models.InventoryItem) error
+	FindAll(ctx context.Context) ([]models.InventoryItem, error)
+	FindPage(ctx context.Context, query models.InventoryQuery) ([]models.InventoryItem, int, error)
+	FindByID(ctx context.Context, id string) (*models.InventoryItem, error)
+	Update(ctx context.Context, id string, updated models.InventoryItem) error
+	Delete(ctx context.Context, id string, version int) error
+	Adjust(ctx context.Context, deltas map[string]float64) ([]models.InventoryItem, error)
+	Flush() error
 }
 
 type jsonInventoryRepo struct {
 	dataDir string
 	mu      sync.Mutex
+	src     *source
 }
 
 func NewJSONInventoryRepo(dir string) InventoryRepository {
-	return &jsonInventoryRepo{dataDir: dir}
+	return &jsonInventoryRepo{dataDir: dir, src: newSource(filepath.Join(dir, "inventory.json"), checkJSON[models.InventoryItem])}
 }
 
-func (r *jsonInventoryRepo) loadInventory() ([]models.InventoryItem, error) {
+func (r *jsonInventoryRepo) loadInventory(ctx context.Context) ([]models.InventoryItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	logger := logging.FromContext(ctx)
 	path := filepath.Join(r.dataDir, "inventory.json")
-	raw, err := ioutil.ReadFile(path)
+	raw, err := r.src.read()
 	if err != nil {
-		slog.Error("failed to read inventory file", "path", path, "err", err)
+		logger.Error("failed to read inventory file", "path", path, "err", err)
 		return nil, err
 	}
 	var inventory []models.InventoryItem
 	if err := json.Unmarshal(raw, &inventory); err != nil {
-		slog.Error("failed to unmarshal inventory JSON", "err", err)
+		logger.Error("failed to unmarshal inventory JSON", "err", err)
 		return nil, err
 	}
+	// Records written before versioning was introduced count as version 1.
+	for i := range inventory {
+		inventory[i].Version = max(inventory[i].Version, 1)
+	}
 
-	slog.Info("loadInventory: success", "count", len(inventory))
+	logger.Info("loadInventory: success", "count", len(inventory))
 	return inventory, nil
 }
 
-func (r *jsonInventoryRepo) saveInventory(inventory []models.InventoryItem) error {
+func (r *jsonInventoryRepo) saveInventory(ctx context.Context, inventory []models.InventoryItem) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	logger := logging.FromContext(ctx)
 	raw, err := json.MarshalIndent(inventory, "", "  ")
 	if err != nil {
 		return err
 	}
 	path := filepath.Join(r.dataDir, "inventory.json")
-	slog.Info("saving inventory file", "path", path, "count", len(inventory))
-	return ioutil.WriteFile(path, raw, 0o644)
+	logger.Info("saving inventory file", "path", path, "count", len(inventory))
+	return r.src.write(raw)
 }
 
-func (r *jsonInventoryRepo) Add(item models.InventoryItem) error {
+func (r *jsonInventoryRepo) Add(ctx context.Context, item models.InventoryItem) error {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	slog.Info("adding inventory item", "id", item.IngredientID, "name", item.Name)
-	inventory, err := r.loadInventory()
+	logger.Info("adding inventory item", "id", item.IngredientID, "name", item.Name)
+	inventory, err := r.loadInventory(ctx)
 	if err != nil {
-		slog.Error("Add: loadInventory failed", "err", err)
+		logger.Error("Add: loadInventory failed", "err", err)
 		return err
 	}
 	for i := range inventory {
 		if inventory[i].IngredientID == item.IngredientID {
-			slog.Warn("Add: duplicate ID", "id", item.IngredientID)
-			return fmt.Errorf("Item ID already exists")
+			logger.Warn("Add: duplicate ID", "id", item.IngredientID)
+			return &ConflictError{Entity: "inventory item", Field: "ingredient_id", Value: item.IngredientID}
 		}
 		if inventory[i].Name == item.Name {
-			slog.Warn("Add: duplicate Name", "name", item.Name)
-			return fmt.Errorf("Item Name already exists")
+			logger.Warn("Add: duplicate Name", "name", item.Name)
+			return &ConflictError{Entity: "inventory item", Field: "name", Value: item.Name}
 		}
 	}
+	item.Version = 1
+	item.UpdatedBy = auth.Actor(ctx)
 	inventory = append(inventory, item)
 
-	if err := r.saveInventory(inventory); err != nil {
-		slog.Error("Add: saveInventory failed", "err", err)
+	if err := r.saveInventory(ctx, inventory); err != nil {
+		logger.Error("Add: saveInventory failed", "err", err)
+		return err
+	}
+	logger.Info("Add: item added", "id", item.IngredientID)
+	return nil
+}
+
+func (r *jsonInventoryRepo) AddMany(ctx context.Context, items []models.InventoryItem) error {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	logger.Info("adding inventory items", "count", len(items))
+	inventory, err := r.loadInventory(ctx)
+	if err != nil {
+		logger.Error("AddMany: loadInventory failed", "err", err)
+		return err
+	}
+	ids := make(map[string]bool, len(inventory)+len(items))
+	names := make(map[string]bool, len(inventory)+len(items))
+	for _, item := range inventory {
+		ids[item.IngredientID] = true
+		names[item.Name] = true
+	}
+	for _, item := range items {
+		if ids[item.IngredientID] {
+			logger.Warn("AddMany: duplicate ID", "id", item.IngredientID)
+			return &ConflictError{Entity: "inventory item", Field: "ingredient_id", Value: item.IngredientID}
+		}
+		if names[item.Name] {
+			logger.Warn("AddMany: duplicate Name", "name", item.Name)
+			return &ConflictError{Entity: "inventory item", Field: "name", Value: item.Name}
+		}
+		ids[item.IngredientID] = true
+		names[item.Name] = true
+	}
+	for _, item := range items {
+		item.Version = 1
+		item.UpdatedBy = auth.Actor(ctx)
+		inventory = append(inventory, item)
+	}
+
+	if err := r.saveInventory(ctx, inventory); err != nil {
+		logger.Error("AddMany: saveInventory failed", "err", err)
 		return err
 	}
-	slog.Info("Add: item added", "id", item.IngredientID)
+	logger.Info("AddMany: items added", "count", len(items))
 	return nil
 }
 
-func (r *jsonInventoryRepo) FindAll() ([]models.InventoryItem, error) {
+func (r *jsonInventoryRepo) FindAll(ctx context.Context) ([]models.InventoryItem, error) {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	slog.Info("FindAll: called")
-	inventory, err := r.loadInventory()
+	logger.Info("FindAll: called")
+	inventory, err := r.loadInventory(ctx)
 	if err != nil {
-		slog.Error("FindAll: loadInventory failed", "err", err)
+		logger.Error("FindAll: loadInventory failed", "err", err)
 		return nil, err
 	}
-	slog.Info("FindAll: returning items", "count", len(inventory))
+	logger.Info("FindAll: returning items", "count", len(inventory))
 	return inventory, nil
 }
 
-func (r *jsonInventoryRepo) FindByID(id string) (*models.InventoryItem, error) {
+var inventorySortFields = map[string]func(a, b models.InventoryItem) bool{
+	"ingredient_id": func(a, b models.InventoryItem) bool { return a.IngredientID < b.IngredientID },
+	"name":          func(a, b models.InventoryItem) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) },
+	"quantity":      func(a, b models.InventoryItem) bool { return a.Quantity < b.Quantity },
+	"unit":          func(a, b models.InventoryItem) bool { return a.Unit < b.Unit },
+}
+
+func (r *jsonInventoryRepo) FindPage(ctx context.Context, query models.InventoryQuery) ([]models.InventoryItem, int, error) {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	logger.Info("FindPage: called", "name", query.Name, "unit", query.Unit, "archived", query.Archived, "sort", query.Sort)
 
-	slog.Info("FindByID: called", "id", id)
-	inventory, err := r.loadInventory()
+	p, err := newPager(query.Page, inventorySortFields)
+	if err != nil {
+		return nil, 0, err
+	}
+	name := strings.ToLower(query.Name)
+	path := filepath.Join(r.dataDir, "inventory.json")
+	err = scanJSONArray(ctx, r.src, func(item models.InventoryItem) {
+		item.Version = max(item.Version, 1)
+		if !strings.Contains(strings.ToLower(item.Name), name) {
+			return
+		}
+		if query.Unit != "" && !strings.EqualFold(item.Unit, query.Unit) {
+			return
+		}
+		if !query.Archived.Match(item.Archived) {
+			return
+		}
+		p.add(item)
+	})
 	if err != nil {
-		slog.Error("FindByID: loadInventory failed", "err", err)
+		logger.Error("FindPage: scan inventory failed", "path", path, "err", err)
+		return nil, 0, err
+	}
+	items, total := p.result()
+	logger.Info("FindPage: returning items", "count", len(items), "total", total)
+	return items, total, nil
+}
+
+func (r *jsonInventoryRepo) FindByID(ctx context.Context, id string) (*models.InventoryItem, error) {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	logger.Info("FindByID: called", "id", id)
+	inventory, err := r.loadInventory(ctx)
+	if err != nil {
+		logger.Error("FindByID: loadInventory failed", "err", err)
 		return nil, err
 	}
 
 	for _, item := range inventory {
 		if item.IngredientID == id {
-			slog.Info("FindByID: item found", "id", id)
+			logger.Info("FindByID: item found", "id", id)
 			return &item, nil
 		}
 	}
-	slog.Warn("FindByID: not found", "id", id)
-	return nil, fmt.Errorf("inventory item %s not found", id)
+	logger.Warn("FindByID: not found", "id", id)
+	return nil, &NotFoundError{Entity: "inventory item", ID: id}
 }
 
-func (r *jsonInventoryRepo) Update(id string, updated models.InventoryItem) error {
+func (r *jsonInventoryRepo) Update(ctx context.Context, id string, updated models.InventoryItem) error {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	slog.Info("Update: called", "id", id, "newID", updated.IngredientID)
-	inventory, err := r.loadInventory()
+	logger.Info("Update: called", "id", id, "newID", updated.IngredientID)
+	inventory, err := r.loadInventory(ctx)
 	if err != nil {
-		slog.Error("Update: loadInventory failed", "err", err)
+		logger.Error("Update: loadInventory failed", "err", err)
 		return err
 	}
 
-	if id == updated.IngredientID {
-		for i, item := range inventory {
-			if item.IngredientID == id {
-				inventory[i] = updated
-				slog.Info("Update: success", "id", updated.IngredientID)
-				return r.saveInventory(inventory)
-			}
+	// The item being updated may keep its own ID and name; only other items
+	// can conflict with them.
+	for _, item := range inventory {
+		if item.IngredientID == id {
+			continue
 		}
-	} else {
-		for _, item := range inventory {
-			if item.IngredientID == updated.IngredientID {
-				slog.Warn("Update: duplicate ID ", "existing")
-				return fmt.Errorf("Inventory item ID already exists")
-			}
-			if item.Name == updated.Name {
-				slog.Warn("Update: duplicate name", "existing")
-				return fmt.Errorf("Inventory item name already exists")
-			}
+		if item.IngredientID == updated.IngredientID {
+			logger.Warn("Update: duplicate ID", "existing", updated.IngredientID)
+			return &ConflictError{Entity: "inventory item", Field: "ingredient_id", Value: updated.IngredientID}
+		}
+		if item.Name == updated.Name {
+			logger.Warn("Update: duplicate name", "existing", updated.Name)
+			return &ConflictError{Entity: "inventory item", Field: "name", Value: updated.Name}
 		}
-		for i, item := range inventory {
-			if item.IngredientID == id {
-				inventory[i] = updated
-				slog.Info("Update: success", "id", updated.IngredientID)
-				return r.saveInventory(inventory)
+	}
+	for i, item := range inventory {
+		if item.IngredientID == id {
+			if updated.Version != 0 && updated.Version != item.Version {
+				logger.Warn("Update: version mismatch", "id", id, "expected", updated.Version, "actual", item.Version)
+				return models.ErrVersionMismatch
 			}
+			updated.Version = item.Version + 1
+			updated.UpdatedBy = auth.Actor(ctx)
+			inventory[i] = updated
+			logger.Info("Update: success", "id", updated.IngredientID)
+			return r.saveInventory(ctx, inventory)
 		}
 	}
 
-	return fmt.Errorf("inventory item %s not found", id)
+	return &NotFoundError{Entity: "inventory item", ID: id}
 }
 
-func (r *jsonInventoryRepo) Delete(id string) error {
+func (r *jsonInventoryRepo) Delete(ctx context.Context, id string, version int) error {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	inventory, err := r.loadInventory()
+	inventory, err := r.loadInventory(ctx)
 	if err != nil {
-		slog.Error("Delete: loadInventory failed", "err", err)
+		logger.Error("Delete: loadInventory failed", "err", err)
 		return err
 	}
 
@@ -175,18 +286,87 @@ func (r *jsonInventoryRepo) Delete(id string) error {
 	for _, item := range inventory {
 		if item.IngredientID != id {
 			filtered = append(filtered, item)
+			continue
+		}
+		if version != 0 && version != item.Version {
+			logger.Warn("Delete: version mismatch", "id", id, "expected", version, "actual", item.Version)
+			return models.ErrVersionMismatch
 		}
 	}
 
 	if len(filtered) == len(inventory) {
-		slog.Warn("Delete: item not found", "id", id)
-		return fmt.Errorf("inventory item %s not found", id)
+		logger.Warn("Delete: item not found", "id", id)
+		return &NotFoundError{Entity: "inventory item", ID: id}
 	}
 
-	if err := r.saveInventory(filtered); err != nil {
-		slog.Error("Delete: saveInventory failed", "err", err)
+	if err := r.saveInventory(ctx, filtered); err != nil {
+		logger.Error("Delete: saveInventory failed", "err", err)
 		return err
 	}
-	slog.Info("Delete: success", "id", id)
+	logger.Info("Delete: success", "id", id)
+	return nil
+}
+
+// Adjust adds each delta to the stock of its ingredient in a single write:
+// negative deltas take stock, positive ones return it. Either every delta is
+// applied or, when an ingredient is missing or would go below zero, none is.
+// It returns the adjusted items as they were before, sorted by ID.
+func (r *jsonInventoryRepo) Adjust(ctx context.Context, deltas map[string]float64) ([]models.InventoryItem, error) {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	inventory, err := r.loadInventory(ctx)
+	if err != nil {
+		logger.Error("Adjust: loadInventory failed", "err", err)
+		return nil, err
+	}
+	index := make(map[string]int, len(inventory))
+	for i, item := range inventory {
+		index[item.IngredientID] = i
+	}
+	ids := make([]string, 0, len(deltas))
+	for id := range deltas {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var shortages []Shortage
+	before := make([]models.InventoryItem, 0, len(ids))
+	for _, id := range ids {
+		i, ok := index[id]
+		if !ok {
+			return nil, &NotFoundError{Entity: "inventory item", ID: id}
+		}
+		item := inventory[i]
+		if delta := deltas[id]; delta < 0 && item.Quantity+delta < 0 {
+			shortages = append(shortages, Shortage{IngredientID: id, Required: -delta, Available: item.Quantity, Unit: item.Unit})
+		}
+		before = append(before, item)
+	}
+	if len(shortages) != 0 {
+		logger.Warn("Adjust: insufficient stock", "count", len(shortages))
+		return nil, &ShortageError{Shortages: shortages}
+	}
+	actor := auth.Actor(ctx)
+	for _, id := range ids {
+		item := &inventory[index[id]]
+		item.Quantity += deltas[id]
+		item.Version++
+		item.UpdatedBy = actor
+	}
+	if err := r.saveInventory(ctx, inventory); err != nil {
+		logger.Error("Adjust: saveInventory failed", "err", err)
+		return nil, err
+	}
+	logger.Info("Adjust: success", "count", len(ids))
+	return before, nil
+}
+
+// Flush waits for a write in progress to finish. Writes are synchronous, so
+// nothing is pending once the lock has been acquired.
+func (r *jsonInventoryRepo) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	return nil
 }
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"hot-coffee/models"
+)
+
+// scanJSONArray decodes the JSON array held by src one element at a time,
+// so callers can filter a collection without holding all of it in memory.
+// It stops with ctx's error as soon as ctx is done.
+func scanJSONArray[T any](ctx context.Context, src *source, fn func(T)) error {
+	path := src.path
+	defer observeIO(path, "scan", time.Now())
+	f, err := src.open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("%s: expected JSON array", path)
+	}
+	for dec.More() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var item T
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		fn(item)
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// pager collects the items of one page while a collection is scanned. With
+// a limit it retains at most offset+limit items, keeping them sorted as they
+// arrive; ties keep their scan order.
+type pager[T any] struct {
+	page  models.Page
+	less  func(a, b T) bool
+	items []T
+	total int
+}
+
+func newPager[T any](page models.Page, sortFields map[string]func(a, b T) bool) (*pager[T], error) {
+	p := &pager[T]{page: page}
+	if page.Sort != "" {
+		less, ok := sortFields[page.Sort]
+		if !ok {
+			return nil, fmt.Errorf("invalid sort field %q", page.Sort)
+		}
+		p.less = less
+		if page.Desc {
+			p.less = func(a, b T) bool { return less(b, a) }
+		}
+	}
+	return p, nil
+}
+
+func (p *pager[T]) add(item T) {
+	p.total++
+	keep := p.page.Offset + p.page.Limit
+	if p.page.Limit <= 0 {
+		p.items = append(p.items, item)
+		return
+	}
+	if p.less == nil {
+		if len(p.items) < keep {
+			p.items = append(p.items, item)
+		}
+		return
+	}
+	i := sort.Search(len(p.items), func(i int) bool { return p.less(item, p.items[i]) })
+	if i >= keep {
+		return
+	}
+	if len(p.items) < keep {
+		p.items = append(p.items, item)
+	}
+	copy(p.items[i+1:], p.items[i:])
+	p.items[i] = item
+}
+
+func (p *pager[T]) result() ([]T, int) {
+	if p.page.Limit <= 0 && p.less != nil {
+		sort.SliceStable(p.items, func(i, j int) bool { return p.less(p.items[i], p.items[j]) })
+	}
+	if p.page.Offset >= len(p.items) {
+		return []T{}, p.total
+	}
+	return p.items[p.page.Offset:], p.total
+}
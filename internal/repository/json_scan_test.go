@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"hot-coffee/models"
+)
+
+type scanned struct {
+	ID   string `json:"id"`
+	Rank int    `json:"rank"`
+}
+
+var scannedSortFields = map[string]func(a, b scanned) bool{
+	"rank": func(a, b scanned) bool { return a.Rank < b.Rank },
+}
+
+func TestPager(t *testing.T) {
+	// Ranks tie for b/c and d/e, which keep their scan order.
+	items := []scanned{{"a", 3}, {"b", 1}, {"c", 1}, {"d", 2}, {"e", 2}, {"f", 0}}
+	tests := []struct {
+		name string
+		page models.Page
+		want []string
+	}{
+		{name: "everything in scan order", page: models.Page{}, want: []string{"a", "b", "c", "d", "e", "f"}},
+		{name: "first page", page: models.Page{Limit: 2}, want: []string{"a", "b"}},
+		{name: "page boundary", page: models.Page{Offset: 2, Limit: 2}, want: []string{"c", "d"}},
+		{name: "last partial page", page: models.Page{Offset: 4, Limit: 4}, want: []string{"e", "f"}},
+		{name: "offset at the end", page: models.Page{Offset: 6, Limit: 2}, want: []string{}},
+		{name: "offset past the end", page: models.Page{Offset: 9, Limit: 2}, want: []string{}},
+		{name: "limit larger than the collection", page: models.Page{Limit: 100}, want: []string{"a", "b", "c", "d", "e", "f"}},
+		{name: "sorted without limit", page: models.Page{Sort: "rank"}, want: []string{"f", "b", "c", "d", "e", "a"}},
+		{name: "sorted first page", page: models.Page{Sort: "rank", Limit: 2}, want: []string{"f", "b"}},
+		{name: "sorted page splits a tie", page: models.Page{Sort: "rank", Offset: 2, Limit: 2}, want: []string{"c", "d"}},
+		{name: "sorted limit larger than the collection", page: models.Page{Sort: "rank", Limit: 100}, want: []string{"f", "b", "c", "d", "e", "a"}},
+		{name: "descending keeps ties in scan order", page: models.Page{Sort: "rank", Desc: true, Limit: 3}, want: []string{"a", "d", "e"}},
+		{name: "descending last page", page: models.Page{Sort: "rank", Desc: true, Offset: 3, Limit: 3}, want: []string{"b", "c", "f"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newPager(tt.page, scannedSortFields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, item := range items {
+				p.add(item)
+			}
+			page, total := p.result()
+			got := []string{}
+			for _, item := range page {
+				got = append(got, item.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) || total != len(items) {
+				t.Errorf("result() = %v, %d, want %v, %d", got, total, tt.want, len(items))
+			}
+		})
+	}
+}
+
+func TestPagerRejectsUnknownSort(t *testing.T) {
+	if _, err := newPager(models.Page{Sort: "name"}, scannedSortFields); err == nil {
+		t.Error("newPager() accepted an unknown sort field")
+	}
+}
+
+func TestScanJSONArray(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{name: "array", content: `[{"id":"a"},{"id":"b"}]`, want: []string{"a", "b"}},
+		{name: "empty array", content: `[]`},
+		{name: "not an array", content: `{"id":"a"}`, wantErr: true},
+		{name: "malformed element", content: `[{"id":"a"},{"id":`, want: []string{"a"}, wantErr: true},
+		{name: "wrong type", content: `[{"id":"a"},{"id":7}]`, want: []string{"a"}, wantErr: true},
+		{name: "truncated after an element", content: `[{"id":"a"},`, want: []string{"a"}, wantErr: true},
+		{name: "missing closing bracket", content: `[{"id":"a"}`, want: []string{"a"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "items.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			err := scanJSONArray(context.Background(), newSource(path, nil), func(item scanned) {
+				got = append(got, item.ID)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("scanJSONArray() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scanned %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanJSONArrayStopsWhenCanceled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.json")
+	if err := os.WriteFile(path, []byte(`[{"id":"a"},{"id":"b"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var got []string
+	err := scanJSONArray(ctx, newSource(path, nil), func(item scanned) {
+		got = append(got, item.ID)
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) || len(got) != 1 {
+		t.Errorf("scanJSONArray() = %v after %v, want context.Canceled after one item", err, got)
+	}
+}
@@ -1,177 +1,333 @@
 package repository
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"log/slog"
 	"path/filepath"
+	"strings"
 	"sync"
 
+	"hot-coffee/internal/auth"
+	"hot-coffee/internal/logging"
 	"hot-coffee/models"
 )
 
 type MenuRepository interface {
-	Add(item models.MenuItem) error
-	FindAll() ([]models.MenuItem, error)
-	FindByID(id string) (*models.MenuItem, error)
-	Update(id string, updated models.MenuItem) error
-	Delete(id string) error
+	Add(ctx context.Context, item models.MenuItem) error
+	AddMany(ctx context.Context, items []models.MenuItem) error
+	FindAll(ctx context.Context) ([]models.MenuItem, error)
+	FindPage(ctx context.Context, query models.MenuQuery) ([]models.MenuItem, int, error)
+	FindByID(ctx context.Context, id string) (*models.MenuItem, error)
+	Update(ctx context.Context, id string, updated models.MenuItem) error
+	UpdateMany(ctx context.Context, updated []models.MenuItem) error
+	Delete(ctx context.Context, id string, version int) error
+	Flush() error
 }
 
 type jsonMenuRepo struct {
 	dataDir string
 	mu      sync.Mutex
+	src     *source
 }
 
 func NewJSONMenuRepo(dir string) MenuRepository {
-	return &jsonMenuRepo{dataDir: dir}
+	return &jsonMenuRepo{dataDir: dir, src: newSource(filepath.Join(dir, "menu_items.json"), checkJSON[models.MenuItem])}
 }
 
-func (r *jsonMenuRepo) loadMenuItems() ([]models.MenuItem, error) {
+func (r *jsonMenuRepo) loadMenuItems(ctx context.Context) ([]models.MenuItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	logger := logging.FromContext(ctx)
 	path := filepath.Join(r.dataDir, "menu_items.json")
-	slog.Info("loadMenuItems", "path", path)
+	logger.Info("loadMenuItems", "path", path)
 
-	raw, err := ioutil.ReadFile(path)
+	raw, err := r.src.read()
 	if err != nil {
-		slog.Error("loadMenuItems: ReadFile failed", "path", path, "err", err)
+		logger.Error("loadMenuItems: ReadFile failed", "path", path, "err", err)
 		return nil, err
 	}
 	var menuItems []models.MenuItem
 	if err := json.Unmarshal(raw, &menuItems); err != nil {
-		slog.Error("loadMenuItems: Unmarshal failed", "err", err)
+		logger.Error("loadMenuItems: Unmarshal failed", "err", err)
 		return nil, err
 	}
+	// Records written before versioning was introduced count as version 1.
+	for i := range menuItems {
+		menuItems[i].Version = max(menuItems[i].Version, 1)
+	}
 
-	slog.Info("loadMenuItems: success", "count", len(menuItems))
+	logger.Info("loadMenuItems: success", "count", len(menuItems))
 	return menuItems, nil
 }
 
-func (r *jsonMenuRepo) saveMenuItems(menuItems []models.MenuItem) error {
-	slog.Info("saveMenuItems: called", "count", len(menuItems))
+func (r *jsonMenuRepo) saveMenuItems(ctx context.Context, menuItems []models.MenuItem) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	logger := logging.FromContext(ctx)
+	logger.Info("saveMenuItems: called", "count", len(menuItems))
 	raw, err := json.MarshalIndent(menuItems, "", "  ")
 	if err != nil {
-		slog.Error("saveMenuItems: MarshalIndent failed", "err", err)
+		logger.Error("saveMenuItems: MarshalIndent failed", "err", err)
 		return err
 	}
 	path := filepath.Join(r.dataDir, "menu_items.json")
-	if err := ioutil.WriteFile(path, raw, 0o644); err != nil {
-		slog.Error("saveMenuItems: WriteFile failed", "path", path, "err", err)
+	if err := r.src.write(raw); err != nil {
+		logger.Error("saveMenuItems: WriteFile failed", "path", path, "err", err)
 		return err
 	}
-	slog.Info("saveMenuItems: success", "path", path)
+	logger.Info("saveMenuItems: success", "path", path)
 	return nil
 }
 
-func (r *jsonMenuRepo) Add(menuItem models.MenuItem) error {
+func (r *jsonMenuRepo) Add(ctx context.Context, menuItem models.MenuItem) error {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	slog.Info("Add: called", "id", menuItem.ID, "name", menuItem.Name)
+	logger.Info("Add: called", "id", menuItem.ID, "name", menuItem.Name)
 
-	menuItems, err := r.loadMenuItems()
+	menuItems, err := r.loadMenuItems(ctx)
 	if err != nil {
-		slog.Error("Add: loadMenuItems failed", "err", err)
+		logger.Error("Add: loadMenuItems failed", "err", err)
 		return err
 	}
 	for _, item := range menuItems {
 		if item.ID == menuItem.ID {
-			slog.Warn("Add: duplicate ID", "id", menuItem.ID)
-			return fmt.Errorf("Menu item ID already exists")
+			logger.Warn("Add: duplicate ID", "id", menuItem.ID)
+			return &ConflictError{Entity: "menu item", Field: "product_id", Value: menuItem.ID}
 		}
 		if item.Name == menuItem.Name {
-			slog.Warn("Add: duplicate Name", "name", menuItem.Name)
-			return fmt.Errorf("Menu item name already exists")
+			logger.Warn("Add: duplicate Name", "name", menuItem.Name)
+			return &ConflictError{Entity: "menu item", Field: "name", Value: menuItem.Name}
 		}
 	}
-	slog.Info("Add: appending item")
+	logger.Info("Add: appending item")
+	menuItem.Version = 1
+	menuItem.UpdatedBy = auth.Actor(ctx)
 	menuItems = append(menuItems, menuItem)
 
-	if err := r.saveMenuItems(menuItems); err != nil {
-		slog.Error("Add: saveMenuItems failed", "err", err)
+	if err := r.saveMenuItems(ctx, menuItems); err != nil {
+		logger.Error("Add: saveMenuItems failed", "err", err)
+		return err
+	}
+	logger.Info("Add: success", "id", menuItem.ID)
+	return nil
+}
+
+func (r *jsonMenuRepo) AddMany(ctx context.Context, newItems []models.MenuItem) error {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	logger.Info("AddMany: called", "count", len(newItems))
+
+	menuItems, err := r.loadMenuItems(ctx)
+	if err != nil {
+		logger.Error("AddMany: loadMenuItems failed", "err", err)
+		return err
+	}
+	ids := make(map[string]bool, len(menuItems)+len(newItems))
+	names := make(map[string]bool, len(menuItems)+len(newItems))
+	for _, item := range menuItems {
+		ids[item.ID] = true
+		names[item.Name] = true
+	}
+	for _, item := range newItems {
+		if ids[item.ID] {
+			logger.Warn("AddMany: duplicate ID", "id", item.ID)
+			return &ConflictError{Entity: "menu item", Field: "product_id", Value: item.ID}
+		}
+		if names[item.Name] {
+			logger.Warn("AddMany: duplicate Name", "name", item.Name)
+			return &ConflictError{Entity: "menu item", Field: "name", Value: item.Name}
+		}
+		ids[item.ID] = true
+		names[item.Name] = true
+	}
+	for _, item := range newItems {
+		item.Version = 1
+		item.UpdatedBy = auth.Actor(ctx)
+		menuItems = append(menuItems, item)
+	}
+
+	if err := r.saveMenuItems(ctx, menuItems); err != nil {
+		logger.Error("AddMany: saveMenuItems failed", "err", err)
 		return err
 	}
-	slog.Info("Add: success", "id", menuItem.ID)
+	logger.Info("AddMany: success", "count", len(newItems))
 	return nil
 }
 
-func (r *jsonMenuRepo) FindAll() ([]models.MenuItem, error) {
+func (r *jsonMenuRepo) FindAll(ctx context.Context) ([]models.MenuItem, error) {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	slog.Info("FindAll: called")
+	logger.Info("FindAll: called")
 
-	items, err := r.loadMenuItems()
+	items, err := r.loadMenuItems(ctx)
 	if err != nil {
-		slog.Error("FindAll: loadMenuItems failed", "err", err)
+		logger.Error("FindAll: loadMenuItems failed", "err", err)
 		return nil, err
 	}
-	slog.Info("FindAll: returning items", "count", len(items))
+	logger.Info("FindAll: returning items", "count", len(items))
 	return items, nil
 }
 
-func (r *jsonMenuRepo) FindByID(id string) (*models.MenuItem, error) {
+var menuSortFields = map[string]func(a, b models.MenuItem) bool{
+	"product_id": func(a, b models.MenuItem) bool { return a.ID < b.ID },
+	"name":       func(a, b models.MenuItem) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) },
+	"price":      func(a, b models.MenuItem) bool { return a.Price < b.Price },
+}
+
+func (r *jsonMenuRepo) FindPage(ctx context.Context, query models.MenuQuery) ([]models.MenuItem, int, error) {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	logger.Info("FindPage: called", "name", query.Name, "archived", query.Archived, "sort", query.Sort)
+
+	p, err := newPager(query.Page, menuSortFields)
+	if err != nil {
+		return nil, 0, err
+	}
+	name := strings.ToLower(query.Name)
+	path := filepath.Join(r.dataDir, "menu_items.json")
+	err = scanJSONArray(ctx, r.src, func(item models.MenuItem) {
+		item.Version = max(item.Version, 1)
+		if strings.Contains(strings.ToLower(item.Name), name) && query.Archived.Match(item.Archived) {
+			p.add(item)
+		}
+	})
+	if err != nil {
+		logger.Error("FindPage: scan menu items failed", "path", path, "err", err)
+		return nil, 0, err
+	}
+	items, total := p.result()
+	logger.Info("FindPage: returning items", "count", len(items), "total", total)
+	return items, total, nil
+}
+
+func (r *jsonMenuRepo) FindByID(ctx context.Context, id string) (*models.MenuItem, error) {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	slog.Info("FindByID: called", "id", id)
-	menuItems, err := r.loadMenuItems()
+	logger.Info("FindByID: called", "id", id)
+	menuItems, err := r.loadMenuItems(ctx)
 	if err != nil {
-		slog.Error("FindByID: loadMenuItems failed", "err", err)
+		logger.Error("FindByID: loadMenuItems failed", "err", err)
 		return nil, err
 	}
 
 	for _, item := range menuItems {
 		if item.ID == id {
-			slog.Info("FindByID: found", "id", id)
+			logger.Info("FindByID: found", "id", id)
 			return &item, nil
 		}
 	}
-	slog.Warn("FindByID: not found", "id", id)
-	return nil, fmt.Errorf("menu item %s not found", id)
+	logger.Warn("FindByID: not found", "id", id)
+	return nil, &NotFoundError{Entity: "menu item", ID: id}
 }
 
-func (r *jsonMenuRepo) Update(id string, updated models.MenuItem) error {
+func (r *jsonMenuRepo) Update(ctx context.Context, id string, updated models.MenuItem) error {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	slog.Info("Update: called", "id", id)
+	logger.Info("Update: called", "id", id)
 
-	menuItems, err := r.loadMenuItems()
+	menuItems, err := r.loadMenuItems(ctx)
 	if err != nil {
-		slog.Error("Update: loadMenuItems failed", "err", err)
+		logger.Error("Update: loadMenuItems failed", "err", err)
 		return err
 	}
 
-	for i, item := range menuItems {
+	// The item being updated may keep its own ID and name; only other items
+	// can conflict with them.
+	for _, item := range menuItems {
 		if item.ID == id {
-			slog.Info("Update: applying update", "index", i)
-			menuItems[i] = updated
-			if err := r.saveMenuItems(menuItems); err != nil {
-				slog.Error("Update: saveMenuItems failed", "err", err)
-			}
-			slog.Info("Update: success", "id", id)
-			return err
+			continue
 		}
 		if item.ID == updated.ID {
-			slog.Warn("Update: duplicate ID", "conflictID", updated.ID)
-			return fmt.Errorf("Menu item ID already exists")
+			logger.Warn("Update: duplicate ID", "conflictID", updated.ID)
+			return &ConflictError{Entity: "menu item", Field: "product_id", Value: updated.ID}
 		}
 		if item.Name == updated.Name {
-			slog.Warn("Update: duplicate Name", "conflictName", updated.Name)
-			return fmt.Errorf("Menu item name already exists")
+			logger.Warn("Update: duplicate Name", "conflictName", updated.Name)
+			return &ConflictError{Entity: "menu item", Field: "name", Value: updated.Name}
 		}
 	}
+	for i, item := range menuItems {
+		if item.ID == id {
+			if updated.Version != 0 && updated.Version != item.Version {
+				logger.Warn("Update: version mismatch", "id", id, "expected", updated.Version, "actual", item.Version)
+				return models.ErrVersionMismatch
+			}
+			logger.Info("Update: applying update", "index", i)
+			updated.Version = item.Version + 1
+			updated.UpdatedBy = auth.Actor(ctx)
+			menuItems[i] = updated
+			if err := r.saveMenuItems(ctx, menuItems); err != nil {
+				logger.Error("Update: saveMenuItems failed", "err", err)
+				return err
+			}
+			logger.Info("Update: success", "id", id)
+			return nil
+		}
+	}
+
+	logger.Warn("Update: not found", "id", id)
+	return &NotFoundError{Entity: "menu item", ID: id}
+}
+
+// UpdateMany replaces each item with the stored one of the same ID in a
+// single write. It is meant for changes to recipes and flags, so names are
+// not checked for conflicts. Either every item is updated or, when one is
+// missing or its version does not match, none is.
+func (r *jsonMenuRepo) UpdateMany(ctx context.Context, updated []models.MenuItem) error {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	logger.Info("UpdateMany: called", "count", len(updated))
 
-	slog.Warn("Update: not found", "id", id)
-	return fmt.Errorf("menu item %s not found", id)
+	menuItems, err := r.loadMenuItems(ctx)
+	if err != nil {
+		logger.Error("UpdateMany: loadMenuItems failed", "err", err)
+		return err
+	}
+	index := make(map[string]int, len(menuItems))
+	for i := len(menuItems) - 1; i >= 0; i-- {
+		index[menuItems[i].ID] = i
+	}
+	actor := auth.Actor(ctx)
+	for _, u := range updated {
+		i, ok := index[u.ID]
+		if !ok {
+			return &NotFoundError{Entity: "menu item", ID: u.ID}
+		}
+		if u.Version != 0 && u.Version != menuItems[i].Version {
+			logger.Warn("UpdateMany: version mismatch", "id", u.ID, "expected", u.Version, "actual", menuItems[i].Version)
+			return models.ErrVersionMismatch
+		}
+		u.Version = menuItems[i].Version + 1
+		u.UpdatedBy = actor
+		menuItems[i] = u
+	}
+	if err := r.saveMenuItems(ctx, menuItems); err != nil {
+		logger.Error("UpdateMany: saveMenuItems failed", "err", err)
+		return err
+	}
+	logger.Info("UpdateMany: success", "count", len(updated))
+	return nil
 }
 
-func (r *jsonMenuRepo) Delete(id string) error {
+func (r *jsonMenuRepo) Delete(ctx context.Context, id string, version int) error {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	slog.Info("Delete: called", "id", id)
-	menuItems, err := r.loadMenuItems()
+	logger.Info("Delete: called", "id", id)
+	menuItems, err := r.loadMenuItems(ctx)
 	if err != nil {
-		slog.Error("Delete: loadMenuItems failed", "err", err)
+		logger.Error("Delete: loadMenuItems failed", "err", err)
 		return err
 	}
 
@@ -179,18 +335,31 @@ func (r *jsonMenuRepo) Delete(id string) error {
 	for _, item := range menuItems {
 		if item.ID != id {
 			filtered = append(filtered, item)
+			continue
+		}
+		if version != 0 && version != item.Version {
+			logger.Warn("Delete: version mismatch", "id", id, "expected", version, "actual", item.Version)
+			return models.ErrVersionMismatch
 		}
 	}
 
 	if len(filtered) == len(menuItems) {
-		slog.Warn("Delete: not found", "id", id)
-		return fmt.Errorf("menu item %s not found", id)
+		logger.Warn("Delete: not found", "id", id)
+		return &NotFoundError{Entity: "menu item", ID: id}
 	}
 
-	if err := r.saveMenuItems(filtered); err != nil {
-		slog.Error("Delete: saveMenuItems failed", "err", err)
+	if err := r.saveMenuItems(ctx, filtered); err != nil {
+		logger.Error("Delete: saveMenuItems failed", "err", err)
 		return err
 	}
-	slog.Info("Delete: success", "id", id)
+	logger.Info("Delete: success", "id", id)
+	return nil
+}
+
+// Flush waits for a write in progress to finish. Writes are synchronous, so
+// nothing is pending once the lock has been acquired.
+func (r *jsonMenuRepo) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	return nil
 }
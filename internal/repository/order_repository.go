@@ -1,146 +1,291 @@
 package repository
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"log/slog"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
+	"hot-coffee/internal/auth"
+	"hot-coffee/internal/logging"
 	"hot-coffee/models"
 )
 
 type OrderRepository interface {
-	Add(order models.Order) error
-	FindAll() ([]models.Order, error)
-	FindByID(id string) (*models.Order, error)
-	Update(id string, updated models.Order) error
-	Delete(id string) error
-	Close(id string) error
+	Add(ctx context.Context, order models.Order) error
+	FindAll(ctx context.Context) ([]models.Order, error)
+	FindPage(ctx context.Context, query models.OrderQuery) ([]models.Order, int, error)
+	FindByID(ctx context.Context, id string) (*models.Order, error)
+	Update(ctx context.Context, id string, updated models.Order) error
+	UpdateMany(ctx context.Context, updated []models.Order) error
+	Delete(ctx context.Context, id string, version int) error
+	Close(ctx context.Context, id string) error
+	Flush() error
 }
 
 type jsonOrderRepo struct {
 	dataDir string
 	mu      sync.Mutex
+	src     *source
 }
 
 func NewJSONOrderRepo(dir string) OrderRepository {
-	return &jsonOrderRepo{dataDir: dir}
+	return &jsonOrderRepo{dataDir: dir, src: newSource(filepath.Join(dir, "orders.json"), checkJSON[models.Order])}
 }
 
-func (r *jsonOrderRepo) loadOrders() ([]models.Order, error) {
+func (r *jsonOrderRepo) loadOrders(ctx context.Context) ([]models.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	logger := logging.FromContext(ctx)
 	path := filepath.Join(r.dataDir, "orders.json")
-	raw, err := ioutil.ReadFile(path)
+	raw, err := r.src.read()
 	if err != nil {
-		slog.Error("loadOrders: ReadFile failed", "path", path, "err", err)
+		logger.Error("loadOrders: ReadFile failed", "path", path, "err", err)
 		return nil, err
 	}
 	var orders []models.Order
 	if err := json.Unmarshal(raw, &orders); err != nil {
-		slog.Error("loadOrders: Unmarshal failed", "err", err)
+		logger.Error("loadOrders: Unmarshal failed", "err", err)
 		return nil, err
 	}
-	slog.Info("loadOrders: success", "count", len(orders))
+	// Records written before versioning was introduced count as version 1.
+	for i := range orders {
+		orders[i].Version = max(orders[i].Version, 1)
+	}
+	logger.Info("loadOrders: success", "count", len(orders))
 	return orders, nil
 }
 
-func (r *jsonOrderRepo) saveOrders(orders []models.Order) error {
+func (r *jsonOrderRepo) saveOrders(ctx context.Context, orders []models.Order) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	logger := logging.FromContext(ctx)
 	path := filepath.Join(r.dataDir, "orders.json")
 	raw, err := json.MarshalIndent(orders, "", "  ")
 	if err != nil {
-		slog.Error("saveOrders: MarshalIndent failed", "err", err)
+		logger.Error("saveOrders: MarshalIndent failed", "err", err)
 		return err
 	}
-	if err := ioutil.WriteFile(path, raw, 0o644); err != nil {
-		slog.Error("saveOrders: WriteFile failed", "path", path, "err", err)
+	if err := r.src.write(raw); err != nil {
+		logger.Error("saveOrders: WriteFile failed", "path", path, "err", err)
 		return err
 	}
-	slog.Info("saveOrders: success", "count", len(orders))
+	logger.Info("saveOrders: success", "count", len(orders))
 	return nil
 }
 
-func (r *jsonOrderRepo) Add(order models.Order) error {
+func (r *jsonOrderRepo) Add(ctx context.Context, order models.Order) error {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	slog.Info("Add order", "orderID", order.ID)
-	orders, err := r.loadOrders()
+	logger.Info("Add order", "orderID", order.ID)
+	orders, err := r.loadOrders(ctx)
 	if err != nil {
 		return err
 	}
+	for _, o := range orders {
+		if o.ID == order.ID {
+			logger.Warn("Add: duplicate ID", "orderID", order.ID)
+			return &ConflictError{Entity: "order", Field: "order_id", Value: order.ID}
+		}
+	}
+	order.Version = 1
+	order.UpdatedBy = auth.Actor(ctx)
 	orders = append(orders, order)
-	if err := r.saveOrders(orders); err != nil {
-		slog.Error("Add: saveOrders failed", "err", err)
+	if err := r.saveOrders(ctx, orders); err != nil {
+		logger.Error("Add: saveOrders failed", "err", err)
 		return err
 	}
-	slog.Info("Add: success", "orderID", order.ID)
+	logger.Info("Add: success", "orderID", order.ID)
 	return nil
 }
 
-func (r *jsonOrderRepo) FindAll() ([]models.Order, error) {
+func (r *jsonOrderRepo) FindAll(ctx context.Context) ([]models.Order, error) {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	orders, err := r.loadOrders()
+	orders, err := r.loadOrders(ctx)
 	if err != nil {
-		slog.Error("FindAll: loadOrders failed", "err", err)
+		logger.Error("FindAll: loadOrders failed", "err", err)
 		return nil, err
 	}
-	slog.Info("FindAll: returning orders", "count", len(orders))
+	logger.Info("FindAll: returning orders", "count", len(orders))
 	return orders, nil
 }
 
-func (r *jsonOrderRepo) FindByID(id string) (*models.Order, error) {
+var orderSortFields = map[string]func(a, b models.Order) bool{
+	"order_id":      func(a, b models.Order) bool { return a.ID < b.ID },
+	"customer_name": func(a, b models.Order) bool { return a.CustomerName < b.CustomerName },
+	"status":        func(a, b models.Order) bool { return a.Status < b.Status },
+	"created_at":    func(a, b models.Order) bool { return a.CreatedAt < b.CreatedAt },
+}
+
+func (r *jsonOrderRepo) FindPage(ctx context.Context, query models.OrderQuery) ([]models.Order, int, error) {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	slog.Info("FindByID", "orderID", id)
-	orders, err := r.loadOrders()
+	p, err := newPager(query.Page, orderSortFields)
 	if err != nil {
-		slog.Error("FindByID: loadOrders failed", "err", err)
+		return nil, 0, err
+	}
+	path := filepath.Join(r.dataDir, "orders.json")
+	err = scanJSONArray(ctx, r.src, func(o models.Order) {
+		o.Version = max(o.Version, 1)
+		if matchOrder(o, query) {
+			p.add(o)
+		}
+	})
+	if err != nil {
+		logger.Error("FindPage: scan orders failed", "path", path, "err", err)
+		return nil, 0, err
+	}
+	orders, total := p.result()
+	logger.Info("FindPage: returning orders", "count", len(orders), "total", total)
+	return orders, total, nil
+}
+
+func matchOrder(o models.Order, query models.OrderQuery) bool {
+	if query.Status != "" && o.Status != query.Status {
+		return false
+	}
+	if query.CustomerName != "" && !strings.Contains(strings.ToLower(o.CustomerName), strings.ToLower(query.CustomerName)) {
+		return false
+	}
+	if query.CustomerID != "" && o.CustomerID != query.CustomerID {
+		return false
+	}
+	if query.ProductID != "" {
+		found := false
+		for _, item := range o.Items {
+			if item.ProductID == query.ProductID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !query.From.IsZero() || !query.To.IsZero() {
+		created, err := time.Parse(time.RFC3339, o.CreatedAt)
+		if err != nil {
+			return false
+		}
+		if !query.From.IsZero() && created.Before(query.From) {
+			return false
+		}
+		if !query.To.IsZero() && !created.Before(query.To) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *jsonOrderRepo) FindByID(ctx context.Context, id string) (*models.Order, error) {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	logger.Info("FindByID", "orderID", id)
+	orders, err := r.loadOrders(ctx)
+	if err != nil {
+		logger.Error("FindByID: loadOrders failed", "err", err)
 		return nil, err
 	}
 	for _, o := range orders {
 		if o.ID == id {
-			slog.Info("FindByID: found", "orderID", id)
+			logger.Info("FindByID: found", "orderID", id)
 			return &o, nil
 		}
 	}
-	slog.Warn("FindByID: not found", "orderID", id)
-	return nil, fmt.Errorf("order %s not found", id)
+	logger.Warn("FindByID: not found", "orderID", id)
+	return nil, &NotFoundError{Entity: "order", ID: id}
 }
 
-func (r *jsonOrderRepo) Update(id string, updated models.Order) error {
+func (r *jsonOrderRepo) Update(ctx context.Context, id string, updated models.Order) error {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	slog.Info("Update order", "orderID", id)
-	orders, err := r.loadOrders()
+	logger.Info("Update order", "orderID", id)
+	orders, err := r.loadOrders(ctx)
 	if err != nil {
 		return err
 	}
 	for i, o := range orders {
 		if o.ID == id {
+			if updated.Version != 0 && updated.Version != o.Version {
+				logger.Warn("Update: version mismatch", "orderID", id, "expected", updated.Version, "actual", o.Version)
+				return models.ErrVersionMismatch
+			}
+			updated.Version = o.Version + 1
+			updated.UpdatedBy = auth.Actor(ctx)
 			orders[i] = updated
-			if err := r.saveOrders(orders); err != nil {
-				slog.Error("Update: saveOrders failed", "err", err)
+			if err := r.saveOrders(ctx, orders); err != nil {
+				logger.Error("Update: saveOrders failed", "err", err)
 				return err
 			}
-			slog.Info("Update: success", "orderID", id)
+			logger.Info("Update: success", "orderID", id)
 			return nil
 		}
 	}
-	slog.Warn("Update: not found", "orderID", id)
-	return fmt.Errorf("order %s not found", id)
+	logger.Warn("Update: not found", "orderID", id)
+	return &NotFoundError{Entity: "order", ID: id}
+}
+
+// UpdateMany replaces each order with the stored one of the same ID in a
+// single write. Either every order is updated or, when one is missing or its
+// version does not match, none is.
+func (r *jsonOrderRepo) UpdateMany(ctx context.Context, updated []models.Order) error {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	logger.Info("UpdateMany orders", "count", len(updated))
+	orders, err := r.loadOrders(ctx)
+	if err != nil {
+		return err
+	}
+	index := make(map[string]int, len(orders))
+	for i := len(orders) - 1; i >= 0; i-- {
+		index[orders[i].ID] = i
+	}
+	actor := auth.Actor(ctx)
+	for _, u := range updated {
+		i, ok := index[u.ID]
+		if !ok {
+			return &NotFoundError{Entity: "order", ID: u.ID}
+		}
+		if u.Version != 0 && u.Version != orders[i].Version {
+			logger.Warn("UpdateMany: version mismatch", "orderID", u.ID, "expected", u.Version, "actual", orders[i].Version)
+			return models.ErrVersionMismatch
+		}
+		u.Version = orders[i].Version + 1
+		u.UpdatedBy = actor
+		orders[i] = u
+	}
+	if err := r.saveOrders(ctx, orders); err != nil {
+		logger.Error("UpdateMany: saveOrders failed", "err", err)
+		return err
+	}
+	logger.Info("UpdateMany: success", "count", len(updated))
+	return nil
 }
 
-func (r *jsonOrderRepo) Delete(id string) error {
+func (r *jsonOrderRepo) Delete(ctx context.Context, id string, version int) error {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	slog.Info("Delete order", "orderID", id)
-	orders, err := r.loadOrders()
+	logger.Info("Delete order", "orderID", id)
+	orders, err := r.loadOrders(ctx)
 	if err != nil {
 		return err
 	}
@@ -148,40 +293,60 @@ func (r *jsonOrderRepo) Delete(id string) error {
 	for _, o := range orders {
 		if o.ID != id {
 			filtered = append(filtered, o)
+			continue
+		}
+		if version != 0 && version != o.Version {
+			logger.Warn("Delete: version mismatch", "orderID", id, "expected", version, "actual", o.Version)
+			return models.ErrVersionMismatch
 		}
 	}
 	if len(filtered) == len(orders) {
-		slog.Warn("Delete: not found", "orderID", id)
-		return fmt.Errorf("order %s not found", id)
+		logger.Warn("Delete: not found", "orderID", id)
+		return &NotFoundError{Entity: "order", ID: id}
 	}
-	if err := r.saveOrders(filtered); err != nil {
-		slog.Error("Delete: saveOrders failed", "err", err)
+	if err := r.saveOrders(ctx, filtered); err != nil {
+		logger.Error("Delete: saveOrders failed", "err", err)
 		return err
 	}
-	slog.Info("Delete: success", "orderID", id)
+	logger.Info("Delete: success", "orderID", id)
 	return nil
 }
 
-func (r *jsonOrderRepo) Close(id string) error {
+func (r *jsonOrderRepo) Close(ctx context.Context, id string) error {
+	logger := logging.FromContext(ctx)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	slog.Info("Close order", "orderID", id)
-	orders, err := r.loadOrders()
+	logger.Info("Close order", "orderID", id)
+	orders, err := r.loadOrders(ctx)
 	if err != nil {
 		return err
 	}
 	for i, o := range orders {
 		if o.ID == id {
+			if o.Status == "closed" {
+				logger.Warn("Close: already closed", "orderID", id)
+				return &StateConflictError{Entity: "order", ID: id, State: "closed"}
+			}
 			orders[i].Status = "closed"
-			if err := r.saveOrders(orders); err != nil {
-				slog.Error("Close: saveOrders failed", "err", err)
+			orders[i].Version++
+			orders[i].UpdatedBy = auth.Actor(ctx)
+			if err := r.saveOrders(ctx, orders); err != nil {
+				logger.Error("Close: saveOrders failed", "err", err)
 				return err
 			}
-			slog.Info("Close: success", "orderID", id)
+			logger.Info("Close: success", "orderID", id)
 			return nil
 		}
 	}
-	slog.Warn("Close: not found", "orderID", id)
-	return fmt.Errorf("order %s not found", id)
+	logger.Warn("Close: not found", "orderID", id)
+	return &NotFoundError{Entity: "order", ID: id}
+}
+
+// Flush waits for a write in progress to finish. Writes are synchronous, so
+// nothing is pending once the lock has been acquired.
+func (r *jsonOrderRepo) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return nil
 }
@@ -0,0 +1,490 @@
+package repository
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"hot-coffee/internal/auth"
+	"hot-coffee/internal/logging"
+	"hot-coffee/models"
+)
+
+// SnapshotRepository stores point-in-time archives of the orders, menu,
+// inventory and customer files in the snapshots directory under the data
+// directory.
+type SnapshotRepository interface {
+	// Create archives the data files while holding every repository lock,
+	// so the snapshot never contains half of a multi-step change.
+	Create(ctx context.Context, reason string) (models.Snapshot, error)
+	FindAll(ctx context.Context) ([]models.Snapshot, error)
+	// Restore replaces the data files with the snapshot's, under the same
+	// locks. The current files are archived first; that snapshot is
+	// returned so the restore can be undone.
+	Restore(ctx context.Context, id string) (models.Snapshot, error)
+	// Reset empties the named collections ("orders", "menu", "inventory",
+	// "customers"), or replaces them with their files from fixturesDir when
+	// it is set; a fixtures directory without customers.json empties the
+	// customers.
+	// Like Restore it holds every lock and archives the data first.
+	Reset(ctx context.Context, collections []string, fixturesDir string) (models.Snapshot, error)
+}
+
+// dataFile is implemented by the repositories whose file is part of a
+// snapshot and can be watched.
+type dataFile interface {
+	dataFile() (collection string, src *source, mu *sync.Mutex)
+}
+
+func (r *jsonOrderRepo) dataFile() (string, *source, *sync.Mutex) {
+	return "orders", r.src, &r.mu
+}
+
+func (r *jsonMenuRepo) dataFile() (string, *source, *sync.Mutex) {
+	return "menu", r.src, &r.mu
+}
+
+func (r *jsonInventoryRepo) dataFile() (string, *source, *sync.Mutex) {
+	return "inventory", r.src, &r.mu
+}
+
+func (r *jsonCustomerRepo) dataFile() (string, *source, *sync.Mutex) {
+	return "customers", r.src, &r.mu
+}
+
+// optionalFiles are data files older data directories and snapshots do
+// not have. A snapshot skips them while missing, and restoring a snapshot
+// without one keeps the current file.
+var optionalFiles = map[string]bool{"customers.json": true}
+
+const manifestName = "snapshot.json"
+
+// Upgrader brings data files from a snapshot or a fixtures directory to the
+// schema of the running data. files maps file names to their content and
+// holds SchemaFile when the data has one; the upgraded content, and the new
+// SchemaFile, replace it in place.
+type Upgrader func(files map[string][]byte) error
+
+type jsonSnapshotRepo struct {
+	dataDir string
+	dir     string
+	files   []dataFile
+	upgrade Upgrader
+	// mu serialises snapshot operations; the data file locks are always
+	// taken after it and in the order of files.
+	mu sync.Mutex
+}
+
+// NewJSONSnapshotRepo returns a SnapshotRepository over the data files of
+// the given repositories. Restored snapshots and fixtures are passed through
+// upgrade; a nil upgrade uses them as stored.
+func NewJSONSnapshotRepo(dataDir string, orders OrderRepository, menu MenuRepository, inventory InventoryRepository, customers CustomerRepository, upgrade Upgrader) SnapshotRepository {
+	r := &jsonSnapshotRepo{dataDir: dataDir, dir: filepath.Join(dataDir, "snapshots"), upgrade: upgrade}
+	for _, repo := range []any{orders, menu, inventory, customers} {
+		f, ok := repo.(dataFile)
+		if !ok {
+			panic(fmt.Sprintf("repository: %T cannot be snapshotted", repo))
+		}
+		r.files = append(r.files, f)
+	}
+	return r
+}
+
+// lockAll takes every data file lock and returns the function releasing
+// them.
+func (r *jsonSnapshotRepo) lockAll() func() {
+	for _, f := range r.files {
+		_, _, mu := f.dataFile()
+		mu.Lock()
+	}
+	return func() {
+		for i := len(r.files) - 1; i >= 0; i-- {
+			_, _, mu := r.files[i].dataFile()
+			mu.Unlock()
+		}
+	}
+}
+
+func (r *jsonSnapshotRepo) Create(ctx context.Context, reason string) (models.Snapshot, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	unlock := r.lockAll()
+	defer unlock()
+	return r.create(ctx, reason)
+}
+
+// create writes the archive; the caller holds every lock.
+func (r *jsonSnapshotRepo) create(ctx context.Context, reason string) (models.Snapshot, error) {
+	logger := logging.FromContext(ctx)
+	if err := ctx.Err(); err != nil {
+		return models.Snapshot{}, err
+	}
+	id, err := newSnapshotID()
+	if err != nil {
+		return models.Snapshot{}, err
+	}
+	snap := models.Snapshot{
+		ID:        id,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Reason:    reason,
+		CreatedBy: auth.Actor(ctx),
+		Records:   map[string]int{},
+	}
+
+	contents := make(map[string][]byte, len(r.files))
+	entries := []string{manifestName}
+	for _, f := range r.files {
+		_, src, _ := f.dataFile()
+		path := src.path
+		raw, err := src.read()
+		if errors.Is(err, fs.ErrNotExist) && optionalFiles[filepath.Base(path)] {
+			continue
+		}
+		if err != nil {
+			logger.Error("Create snapshot: read failed", "path", path, "err", err)
+			return models.Snapshot{}, err
+		}
+		var records []json.RawMessage
+		if err := json.Unmarshal(raw, &records); err != nil {
+			return models.Snapshot{}, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		name := filepath.Base(path)
+		contents[name] = raw
+		snap.Records[name] = len(records)
+		entries = append(entries, name)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	manifest, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return models.Snapshot{}, err
+	}
+	// The manifest comes first so listing only reads the start of each
+	// archive.
+	contents[manifestName] = manifest
+	// The schema version travels with the data so a restore brings back
+	// data and version together.
+	schema, err := readFile(filepath.Join(r.dataDir, SchemaFile))
+	if err == nil {
+		entries = append(entries, SchemaFile)
+		contents[SchemaFile] = schema
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return models.Snapshot{}, err
+	}
+	for _, name := range entries {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(contents[name])), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return models.Snapshot{}, err
+		}
+		if _, err := tw.Write(contents[name]); err != nil {
+			return models.Snapshot{}, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return models.Snapshot{}, err
+	}
+	if err := gz.Close(); err != nil {
+		return models.Snapshot{}, err
+	}
+
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return models.Snapshot{}, err
+	}
+	// Snapshots include the customers' contact details.
+	if err := writeFileAtomic(r.archivePath(id), buf.Bytes(), 0o600); err != nil {
+		logger.Error("Create snapshot: write failed", "id", id, "err", err)
+		return models.Snapshot{}, err
+	}
+	snap.Size = int64(buf.Len())
+	logger.Info("Create snapshot: success", "id", id, "reason", reason, "size", snap.Size)
+	return snap, nil
+}
+
+// newSnapshotID returns a time-ordered ID such as
+// "20261018T091500.123Z-1a2b3c".
+func newSnapshotID() (string, error) {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("20060102T150405.000Z") + "-" + hex.EncodeToString(b), nil
+}
+
+func (r *jsonSnapshotRepo) archivePath(id string) string {
+	return filepath.Join(r.dir, id+".tar.gz")
+}
+
+func (r *jsonSnapshotRepo) FindAll(ctx context.Context) ([]models.Snapshot, error) {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries, err := os.ReadDir(r.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snaps []models.Snapshot
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".tar.gz")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		snap, err := r.readManifest(id)
+		if err != nil {
+			logger.Warn("FindAll snapshots: unreadable archive", "file", entry.Name(), "err", err)
+			continue
+		}
+		snaps = append(snaps, snap)
+	}
+	// IDs start with the creation time.
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].ID > snaps[j].ID })
+	return snaps, nil
+}
+
+func (r *jsonSnapshotRepo) readManifest(id string) (models.Snapshot, error) {
+	var snap models.Snapshot
+	err := r.readArchive(id, func(name string, raw []byte) (bool, error) {
+		if name != manifestName {
+			return false, fmt.Errorf("archive does not start with %s", manifestName)
+		}
+		return false, json.Unmarshal(raw, &snap)
+	})
+	if err != nil {
+		return models.Snapshot{}, err
+	}
+	if info, err := os.Stat(r.archivePath(id)); err == nil {
+		snap.Size = info.Size()
+	}
+	return snap, nil
+}
+
+// readArchive calls fn with every entry of the snapshot until fn returns
+// false.
+func (r *jsonSnapshotRepo) readArchive(id string, fn func(name string, raw []byte) (bool, error)) error {
+	// IDs are file names; anything that could leave the directory is
+	// simply not found.
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return &NotFoundError{Entity: "snapshot", ID: id}
+	}
+	f, err := os.Open(r.archivePath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &NotFoundError{Entity: "snapshot", ID: id}
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		raw, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		more, err := fn(hdr.Name, raw)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+func (r *jsonSnapshotRepo) Restore(ctx context.Context, id string) (models.Snapshot, error) {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	contents := map[string][]byte{}
+	err := r.readArchive(id, func(name string, raw []byte) (bool, error) {
+		contents[name] = raw
+		return true, nil
+	})
+	if err != nil {
+		return models.Snapshot{}, err
+	}
+	// A snapshot taken before a migration is brought to the current schema,
+	// which is then written along with it.
+	if r.upgrade != nil {
+		if err := r.upgrade(contents); err != nil {
+			logger.Warn("Restore snapshot: upgrade failed", "id", id, "err", err)
+			return models.Snapshot{}, fmt.Errorf("snapshot %s: %w", id, err)
+		}
+	}
+	replacements := map[dataFile][]byte{}
+	for _, f := range r.files {
+		_, src, _ := f.dataFile()
+		path := src.path
+		raw, ok := contents[filepath.Base(path)]
+		if !ok && optionalFiles[filepath.Base(path)] {
+			continue
+		}
+		if !ok {
+			return models.Snapshot{}, fmt.Errorf("snapshot %s has no %s", id, filepath.Base(path))
+		}
+		replacements[f] = raw
+	}
+	before, err := r.replace(ctx, "pre-restore "+id, replacements)
+	if err != nil {
+		return before, err
+	}
+	// Without an upgrader, a snapshot without a schema file predates schema
+	// versioning; removing the current one lets the next start migrate the
+	// restored data.
+	schemaPath := filepath.Join(r.dataDir, SchemaFile)
+	if schema, ok := contents[SchemaFile]; ok {
+		err = writeFileAtomic(schemaPath, schema, 0o644)
+	} else if err = os.Remove(schemaPath); errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		logger.Error("Restore snapshot: schema file failed", "err", err)
+		return before, err
+	}
+	logger.Info("Restore snapshot: success", "id", id, "previous", before.ID)
+	return before, nil
+}
+
+func (r *jsonSnapshotRepo) Reset(ctx context.Context, collections []string, fixturesDir string) (models.Snapshot, error) {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fixtures, err := r.readFixtures(fixturesDir)
+	if err != nil {
+		logger.Error("Reset: reading fixtures failed", "dir", fixturesDir, "err", err)
+		return models.Snapshot{}, err
+	}
+	replacements := map[dataFile][]byte{}
+	for _, name := range collections {
+		f := r.file(name)
+		if f == nil {
+			return models.Snapshot{}, &NotFoundError{Entity: "collection", ID: name}
+		}
+		raw := []byte("[]")
+		if fixturesDir != "" {
+			_, src, _ := f.dataFile()
+			base := filepath.Base(src.path)
+			var ok bool
+			raw, ok = fixtures[base]
+			if !ok && optionalFiles[base] {
+				raw, ok = []byte("[]"), true
+			}
+			if !ok {
+				return models.Snapshot{}, &NotFoundError{Entity: "fixture", ID: base}
+			}
+		}
+		replacements[f] = raw
+	}
+	before, err := r.replace(ctx, "pre-reset", replacements)
+	if err != nil {
+		return before, err
+	}
+	logger.Info("Reset: success", "collections", collections, "fixtures", fixturesDir, "snapshot", before.ID)
+	return before, nil
+}
+
+// readFixtures reads every data file and the schema file present in dir,
+// upgraded like a restored snapshot. An empty dir has no fixtures.
+func (r *jsonSnapshotRepo) readFixtures(dir string) (map[string][]byte, error) {
+	fixtures := map[string][]byte{}
+	if dir == "" {
+		return fixtures, nil
+	}
+	names := []string{SchemaFile}
+	for _, f := range r.files {
+		_, src, _ := f.dataFile()
+		names = append(names, filepath.Base(src.path))
+	}
+	for _, name := range names {
+		raw, err := readFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		fixtures[name] = raw
+	}
+	if r.upgrade != nil {
+		if err := r.upgrade(fixtures); err != nil {
+			return nil, fmt.Errorf("fixtures: %w", err)
+		}
+	}
+	return fixtures, nil
+}
+
+func (r *jsonSnapshotRepo) file(collection string) dataFile {
+	for _, f := range r.files {
+		if name, _, _ := f.dataFile(); name == collection {
+			return f
+		}
+	}
+	return nil
+}
+
+// replace archives the current data under reason and then writes the new
+// content of each file in replacements, holding every lock throughout.
+// Every replacement is checked before any file is touched, so bad input
+// leaves the data as it was. The caller holds r.mu.
+func (r *jsonSnapshotRepo) replace(ctx context.Context, reason string, replacements map[dataFile][]byte) (models.Snapshot, error) {
+	logger := logging.FromContext(ctx)
+	for f, raw := range replacements {
+		_, src, _ := f.dataFile()
+		if err := src.check(raw); err != nil {
+			return models.Snapshot{}, fmt.Errorf("%s: %w", filepath.Base(src.path), err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return models.Snapshot{}, err
+	}
+
+	unlock := r.lockAll()
+	defer unlock()
+	// Once the files start changing the replacement is not cancelled
+	// halfway.
+	ctx = context.WithoutCancel(ctx)
+	before, err := r.create(ctx, reason)
+	if err != nil {
+		return models.Snapshot{}, err
+	}
+	for _, f := range r.files {
+		raw, ok := replacements[f]
+		if !ok {
+			continue
+		}
+		_, src, _ := f.dataFile()
+		if err := src.write(raw); err != nil {
+			logger.Error("replace: write failed", "path", src.path, "err", err)
+			return before, err
+		}
+	}
+	return before, nil
+}
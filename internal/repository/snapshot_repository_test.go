@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeData(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readData(t *testing.T, dir, name string) string {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+func TestSnapshotCreateAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	const orders = `[{"order_id":"o1"},{"order_id":"o2"}]`
+	writeData(t, dir, "orders.json", orders)
+	writeData(t, dir, "menu_items.json", `[{"product_id":"latte"}]`)
+	writeData(t, dir, "inventory.json", `[]`)
+	writeData(t, dir, "customers.json", `[{"customer_id":"c1"}]`)
+	repo := NewJSONSnapshotRepo(dir, NewJSONOrderRepo(dir), NewJSONMenuRepo(dir), NewJSONInventoryRepo(dir), NewJSONCustomerRepo(dir), nil)
+	ctx := context.Background()
+
+	snap, err := repo.Create(ctx, "manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"orders.json": 2, "menu_items.json": 1, "inventory.json": 0, "customers.json": 1}
+	for name, n := range want {
+		if snap.Records[name] != n {
+			t.Errorf("Records[%s] = %d, want %d", name, snap.Records[name], n)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dir, "snapshots", snap.ID+".tar.gz")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("archive mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	const changed = `[{"order_id":"o3"}]`
+	writeData(t, dir, "orders.json", changed)
+	before, err := repo.Restore(ctx, snap.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readData(t, dir, "orders.json"); got != orders {
+		t.Errorf("orders after Restore = %s, want %s", got, orders)
+	}
+	if before.Reason != "pre-restore "+snap.ID || before.Records["orders.json"] != 1 {
+		t.Errorf("pre-restore snapshot = %+v", before)
+	}
+
+	// The returned snapshot undoes the restore.
+	if _, err := repo.Restore(ctx, before.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := readData(t, dir, "orders.json"); got != changed {
+		t.Errorf("orders after undoing the restore = %s, want %s", got, changed)
+	}
+
+	list, err := repo.FindAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, s := range list {
+		found[s.ID] = true
+	}
+	if len(list) != 3 || !found[snap.ID] || !found[before.ID] {
+		t.Errorf("FindAll() = %+v, want the manual and both pre-restore snapshots", list)
+	}
+	for i := 1; i < len(list); i++ {
+		if list[i-1].ID < list[i].ID {
+			t.Errorf("FindAll() is not newest first: %s before %s", list[i-1].ID, list[i].ID)
+		}
+	}
+}
+
+func TestSnapshotRestoreUnknown(t *testing.T) {
+	dir := t.TempDir()
+	writeData(t, dir, "orders.json", `[]`)
+	repo := NewJSONSnapshotRepo(dir, NewJSONOrderRepo(dir), NewJSONMenuRepo(dir), NewJSONInventoryRepo(dir), NewJSONCustomerRepo(dir), nil)
+	// A file next to the snapshots directory must not be reachable.
+	writeData(t, dir, "outside.tar.gz", "")
+	for _, id := range []string{"", "missing", "../outside", ".hidden"} {
+		if _, err := repo.Restore(context.Background(), id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Restore(%q) error = %v, want not found", id, err)
+		}
+	}
+	if got := readData(t, dir, "orders.json"); got != `[]` {
+		t.Errorf("orders changed by a failed restore: %s", got)
+	}
+}
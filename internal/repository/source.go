@@ -0,0 +1,202 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"hot-coffee/internal/logging"
+	"hot-coffee/internal/metrics"
+)
+
+// source is the data file behind a repository. By default every read goes
+// to the disk. Once watched, the source keeps the last valid content in
+// memory and serves reads from it; only a reload that passes check replaces
+// it, so a broken hand edit never reaches the repository. Callers hold the
+// repository lock.
+type source struct {
+	path  string
+	perm  os.FileMode
+	check func(raw []byte) error
+	// validate, set by Watch, also vets a reload against the content it
+	// would replace.
+	validate func(previous, raw []byte) error
+
+	watched bool
+	raw     []byte
+	// stamp is the hash of raw; rejected the hash of the last content that
+	// failed check, so it is reported only once, as is a file that cannot
+	// be read at all.
+	stamp      fileStamp
+	rejected   fileStamp
+	unreadable bool
+}
+
+// fileStamp identifies content by its SHA-256. Modification time and size
+// miss a rewrite of the same length within the file system's timestamp
+// granularity, or by a tool that keeps the old modification time.
+type fileStamp [sha256.Size]byte
+
+func newSource(path string, check func(raw []byte) error) *source {
+	return &source{path: path, perm: 0o644, check: check}
+}
+
+func stampOf(raw []byte) fileStamp {
+	return sha256.Sum256(raw)
+}
+
+// checkJSON accepts content that decodes as a JSON array of T, which is
+// what the repositories need to work; record-level problems are left to
+// hot-coffee check.
+func checkJSON[T any](raw []byte) error {
+	var records []T
+	return json.Unmarshal(raw, &records)
+}
+
+// readOptional decodes the JSON array read returns into records of T, treating
+// a missing file as empty.
+func readOptional[T any](s *source) ([]T, error) {
+	raw, err := s.read()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []T
+	if err := json.Unmarshal(raw, &records); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(s.path), err)
+	}
+	return records, nil
+}
+
+func (s *source) read() ([]byte, error) {
+	if s.watched {
+		return s.raw, nil
+	}
+	return readFile(s.path)
+}
+
+// open returns a reader over the content read would return.
+func (s *source) open() (io.ReadCloser, error) {
+	if s.watched {
+		return io.NopCloser(bytes.NewReader(s.raw)), nil
+	}
+	return os.Open(s.path)
+}
+
+func (s *source) write(raw []byte) error {
+	if err := writeFileAtomic(s.path, raw, s.perm); err != nil {
+		return err
+	}
+	if s.watched {
+		s.raw, s.stamp, s.rejected = raw, stampOf(raw), fileStamp{}
+	}
+	return nil
+}
+
+// watch loads the file, which has to pass check, and from then on serves
+// reads from memory.
+func (s *source) watch() error {
+	raw, err := readFile(s.path)
+	if err != nil {
+		return err
+	}
+	if err := s.check(raw); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(s.path), err)
+	}
+	s.watched, s.raw, s.stamp = true, raw, stampOf(raw)
+	return nil
+}
+
+// reload picks up an external edit of the file if its content changed and
+// the new content passes check. An invalid edit is logged and the last
+// valid content stays in use; the next write through the repository
+// replaces the file. Polls read the whole file but bypass readFile, so they
+// stay out of the repository I/O metrics.
+func (s *source) reload(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	name := filepath.Base(s.path)
+	raw, err := os.ReadFile(s.path)
+	if err != nil {
+		if s.unreadable {
+			return
+		}
+		s.unreadable = true
+		logger.Error("Data file unreadable; keeping the last valid content", "file", name, "err", err)
+		metrics.DataReloads.Inc(name, "rejected")
+		return
+	}
+	s.unreadable = false
+	stamp := stampOf(raw)
+	if stamp == s.stamp || stamp == s.rejected {
+		return
+	}
+	err = s.check(raw)
+	if err == nil && s.validate != nil {
+		err = s.validate(s.raw, raw)
+	}
+	if err != nil {
+		s.rejected = stamp
+		logger.Error("Rejected edit of data file; keeping the last valid content", "file", name, "err", err)
+		metrics.DataReloads.Inc(name, "rejected")
+		return
+	}
+	s.raw, s.stamp, s.rejected = raw, stamp, fileStamp{}
+	logger.Info("Reloaded edited data file", "file", name, "bytes", len(raw))
+	metrics.DataReloads.Inc(name, "reloaded")
+}
+
+// Watch makes the order, menu, inventory and customer repositories serve
+// their data from memory and polls the files every interval until ctx is
+// done, reloading external edits that are valid. It fails if a file is
+// invalid to begin with. validate, if not nil, is given the file name, the
+// content in use and an edit that decodes; an error rejects the edit.
+func Watch(ctx context.Context, interval time.Duration, validate func(file string, previous, raw []byte) error, repos ...any) error {
+	var files []dataFile
+	for _, repo := range repos {
+		f, ok := repo.(dataFile)
+		if !ok {
+			return fmt.Errorf("repository: %T cannot be watched", repo)
+		}
+		_, src, mu := f.dataFile()
+		mu.Lock()
+		err := src.watch()
+		if validate != nil {
+			name := filepath.Base(src.path)
+			src.validate = func(previous, raw []byte) error { return validate(name, previous, raw) }
+		}
+		mu.Unlock()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, f := range files {
+				_, src, mu := f.dataFile()
+				mu.Lock()
+				src.reload(ctx)
+				mu.Unlock()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSourceReload(t *testing.T) {
+	const initial = `[{"id":"a","rank":1}]`
+	tests := []struct {
+		name string
+		edit string
+		want string
+	}{
+		{name: "rewrite of the same length", edit: `[{"id":"b","rank":2}]`, want: `[{"id":"b","rank":2}]`},
+		{name: "same content", edit: initial, want: initial},
+		{name: "invalid rewrite of the same length", edit: `{"id":"b","rank":2}]`, want: initial},
+		{name: "longer rewrite", edit: `[{"id":"a","rank":1},{"id":"b","rank":2}]`, want: `[{"id":"a","rank":1},{"id":"b","rank":2}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scanned.json")
+			if err := os.WriteFile(path, []byte(initial), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			s := newSource(path, checkJSON[scanned])
+			if err := s.watch(); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Edit in place and put the old modification time back, as a
+			// tool that preserves it, or a coarse file system clock, would.
+			if err := os.WriteFile(path, []byte(tt.edit), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, time.Time{}, info.ModTime()); err != nil {
+				t.Fatal(err)
+			}
+			s.reload(context.Background())
+
+			raw, err := s.read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(raw) != tt.want {
+				t.Errorf("read() after reload = %s, want %s", raw, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceWriteIsNotReloaded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scanned.json")
+	if err := os.WriteFile(path, []byte(`[]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := newSource(path, checkJSON[scanned])
+	if err := s.watch(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.write([]byte(`[{"id":"a","rank":1}]`)); err != nil {
+		t.Fatal(err)
+	}
+	// The poll finds the content just written and has nothing to reload.
+	before := s.stamp
+	s.reload(context.Background())
+	if s.stamp != before || s.rejected != (fileStamp{}) {
+		t.Errorf("reload after write changed the stamps")
+	}
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sync"
+
+	"hot-coffee/internal/logging"
+	"hot-coffee/models"
+)
+
+type UserRepository interface {
+	Add(ctx context.Context, user models.User) error
+	FindAll(ctx context.Context) ([]models.User, error)
+	Flush() error
+}
+
+type jsonUserRepo struct {
+	dataDir string
+	mu      sync.Mutex
+}
+
+func NewJSONUserRepo(dir string) UserRepository {
+	return &jsonUserRepo{dataDir: dir}
+}
+
+// loadUsers reads users.json. The file is optional: a missing file means no
+// users have been created yet.
+func (r *jsonUserRepo) loadUsers(ctx context.Context) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	logger := logging.FromContext(ctx)
+	path := filepath.Join(r.dataDir, "users.json")
+	raw, err := readFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Error("loadUsers: ReadFile failed", "path", path, "err", err)
+		return nil, err
+	}
+	var users []models.User
+	if err := json.Unmarshal(raw, &users); err != nil {
+		logger.Error("loadUsers: Unmarshal failed", "err", err)
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *jsonUserRepo) saveUsers(ctx context.Context, users []models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Key hashes are credentials; keep the file private to the server user.
+	return writeFileAtomic(filepath.Join(r.dataDir, "users.json"), raw, 0o600)
+}
+
+func (r *jsonUserRepo) Add(ctx context.Context, user models.User) error {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users, err := r.loadUsers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if u.Name == user.Name {
+			logger.Warn("Add: duplicate user", "name", user.Name)
+			return &ConflictError{Entity: "user", Field: "name", Value: user.Name}
+		}
+	}
+	if err := r.saveUsers(ctx, append(users, user)); err != nil {
+		logger.Error("Add: saveUsers failed", "err", err)
+		return err
+	}
+	logger.Info("user added", "name", user.Name, "role", user.Role)
+	return nil
+}
+
+func (r *jsonUserRepo) FindAll(ctx context.Context) ([]models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadUsers(ctx)
+}
+
+// Flush waits for a write in progress to finish. Writes are synchronous, so
+// nothing is pending once the lock has been acquired.
+func (r *jsonUserRepo) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"sync"
+
+	"hot-coffee/internal/logging"
+	"hot-coffee/models"
+)
+
+// maxFinishedDeliveries bounds the delivery log: beyond it the oldest
+// delivered or failed deliveries are dropped. Pending ones are always kept.
+const maxFinishedDeliveries = 1000
+
+type WebhookRepository interface {
+	Add(ctx context.Context, hook models.Webhook) error
+	FindAll(ctx context.Context) ([]models.Webhook, error)
+	FindByID(ctx context.Context, id string) (*models.Webhook, error)
+	Delete(ctx context.Context, id string) error
+	AddDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error
+	UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+	FindDeliveries(ctx context.Context) ([]models.WebhookDelivery, error)
+	Flush() error
+}
+
+// jsonWebhookRepo keeps subscriptions in webhooks.json and the delivery
+// queue and log in webhook_deliveries.json. Both files are optional.
+type jsonWebhookRepo struct {
+	dataDir    string
+	mu         sync.Mutex
+	hooks      *source
+	deliveries *source
+}
+
+func NewJSONWebhookRepo(dir string) WebhookRepository {
+	hooks := newSource(filepath.Join(dir, "webhooks.json"), checkJSON[models.Webhook])
+	// Secrets sign the payloads; keep the file private to the server user.
+	hooks.perm = 0o600
+	return &jsonWebhookRepo{
+		dataDir:    dir,
+		hooks:      hooks,
+		deliveries: newSource(filepath.Join(dir, "webhook_deliveries.json"), checkJSON[models.WebhookDelivery]),
+	}
+}
+
+func (r *jsonWebhookRepo) loadHooks(ctx context.Context) ([]models.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	hooks, err := readOptional[models.Webhook](r.hooks)
+	if err != nil {
+		logging.FromContext(ctx).Error("loadHooks failed", "path", r.hooks.path, "err", err)
+	}
+	return hooks, err
+}
+
+func (r *jsonWebhookRepo) saveHooks(ctx context.Context, hooks []models.Webhook) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if hooks == nil {
+		hooks = []models.Webhook{}
+	}
+	raw, err := json.MarshalIndent(hooks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return r.hooks.write(raw)
+}
+
+func (r *jsonWebhookRepo) loadDeliveries(ctx context.Context) ([]models.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deliveries, err := readOptional[models.WebhookDelivery](r.deliveries)
+	if err != nil {
+		logging.FromContext(ctx).Error("loadDeliveries failed", "path", r.deliveries.path, "err", err)
+	}
+	return deliveries, err
+}
+
+// saveDeliveries trims the log to the pending deliveries plus the newest
+// maxFinishedDeliveries finished ones and writes it.
+func (r *jsonWebhookRepo) saveDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deliveries = pruneDeliveries(deliveries)
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+	raw, err := json.MarshalIndent(deliveries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return r.deliveries.write(raw)
+}
+
+// pruneDeliveries drops the oldest delivered or failed deliveries beyond
+// maxFinishedDeliveries, keeping the order of the rest.
+func pruneDeliveries(deliveries []models.WebhookDelivery) []models.WebhookDelivery {
+	finished := 0
+	for _, d := range deliveries {
+		if d.Status != models.DeliveryPending {
+			finished++
+		}
+	}
+	drop := finished - maxFinishedDeliveries
+	if drop <= 0 {
+		return deliveries
+	}
+	kept := make([]models.WebhookDelivery, 0, len(deliveries)-drop)
+	for _, d := range deliveries {
+		if drop > 0 && d.Status != models.DeliveryPending {
+			drop--
+			continue
+		}
+		kept = append(kept, d)
+	}
+	return kept
+}
+
+func (r *jsonWebhookRepo) Add(ctx context.Context, hook models.Webhook) error {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hooks, err := r.loadHooks(ctx)
+	if err != nil {
+		return err
+	}
+	for _, h := range hooks {
+		if h.ID == hook.ID {
+			logger.Warn("Add: duplicate webhook", "id", hook.ID)
+			return &ConflictError{Entity: "webhook", Field: "id", Value: hook.ID}
+		}
+	}
+	return r.saveHooks(ctx, append(hooks, hook))
+}
+
+func (r *jsonWebhookRepo) FindAll(ctx context.Context) ([]models.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadHooks(ctx)
+}
+
+func (r *jsonWebhookRepo) FindByID(ctx context.Context, id string) (*models.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	hooks, err := r.loadHooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hooks {
+		if h.ID == id {
+			return &h, nil
+		}
+	}
+	return nil, &NotFoundError{Entity: "webhook", ID: id}
+}
+
+func (r *jsonWebhookRepo) Delete(ctx context.Context, id string) error {
+	logger := logging.FromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hooks, err := r.loadHooks(ctx)
+	if err != nil {
+		return err
+	}
+	for i, h := range hooks {
+		if h.ID == id {
+			logger.Info("Delete webhook", "id", id)
+			return r.saveHooks(ctx, append(hooks[:i], hooks[i+1:]...))
+		}
+	}
+	return &NotFoundError{Entity: "webhook", ID: id}
+}
+
+// AddDeliveries appends deliveries to the log.
+func (r *jsonWebhookRepo) AddDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.loadDeliveries(ctx)
+	if err != nil {
+		return err
+	}
+	return r.saveDeliveries(ctx, append(stored, deliveries...))
+}
+
+func (r *jsonWebhookRepo) UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.loadDeliveries(ctx)
+	if err != nil {
+		return err
+	}
+	for i, d := range stored {
+		if d.ID == delivery.ID {
+			stored[i] = delivery
+			return r.saveDeliveries(ctx, stored)
+		}
+	}
+	return &NotFoundError{Entity: "webhook delivery", ID: delivery.ID}
+}
+
+// FindDeliveries returns the delivery log in the order deliveries were
+// queued, oldest first.
+func (r *jsonWebhookRepo) FindDeliveries(ctx context.Context) ([]models.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadDeliveries(ctx)
+}
+
+// Flush waits for a write in progress to finish.
+func (r *jsonWebhookRepo) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,292 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"hot-coffee/internal/auth"
+	"hot-coffee/internal/logging"
+	"hot-coffee/internal/repository"
+	"hot-coffee/models"
+)
+
+// favouriteItems is how many of a customer's most ordered items their
+// history lists.
+const favouriteItems = 3
+
+type CustomerService interface {
+	CreateCustomer(ctx context.Context, customer models.Customer) (models.Customer, error)
+	ListCustomers(ctx context.Context, search string) ([]models.Customer, error)
+	GetCustomer(ctx context.Context, id string) (models.Customer, error)
+	UpdateCustomer(ctx context.Context, id string, customer models.Customer) error
+	DeleteCustomer(ctx context.Context, id string, version int, mode DeleteMode) error
+	GetCustomerHistory(ctx context.Context, id string) (models.CustomerHistory, error)
+}
+
+type customerServ struct {
+	customerRepo repository.CustomerRepository
+	orderRepo    repository.OrderRepository
+	menuRepo     repository.MenuRepository
+}
+
+func NewCustomerService(cr repository.CustomerRepository, or repository.OrderRepository, mr repository.MenuRepository) CustomerService {
+	return &customerServ{customerRepo: cr, orderRepo: or, menuRepo: mr}
+}
+
+// CreateCustomer validates and stores customer. Without a customer_id one is
+// generated.
+func (s *customerServ) CreateCustomer(ctx context.Context, customer models.Customer) (models.Customer, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("CreateCustomer", slog.String("customer_id", customer.ID))
+	if err := checkCustomer(&customer).OrNil(); err != nil {
+		return models.Customer{}, err
+	}
+	if customer.ID == "" {
+		id, err := randomHex(6)
+		if err != nil {
+			return models.Customer{}, err
+		}
+		customer.ID = "cus_" + id
+	}
+	customer.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := s.customerRepo.Add(ctx, customer); err != nil {
+		logger.Warn("Add customer", slog.Any("error", err))
+		return models.Customer{}, err
+	}
+	logger.Info("Customer created", slog.String("customer_id", customer.ID))
+	return s.GetCustomer(ctx, customer.ID)
+}
+
+// ListCustomers returns the customers sorted by name. A non-empty search
+// keeps those whose name, email or phone contains it, ignoring case.
+func (s *customerServ) ListCustomers(ctx context.Context, search string) ([]models.Customer, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("ListCustomers", slog.String("search", search))
+	customers, err := s.customerRepo.FindAll(ctx)
+	if err != nil {
+		logger.Error("FindAll customers", slog.Any("error", err))
+		return nil, err
+	}
+	search = strings.ToLower(search)
+	found := make([]models.Customer, 0, len(customers))
+	for _, c := range customers {
+		if search == "" ||
+			strings.Contains(strings.ToLower(c.Name), search) ||
+			strings.Contains(strings.ToLower(c.Email), search) ||
+			strings.Contains(c.Phone, search) {
+			found = append(found, c)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return strings.ToLower(found[i].Name) < strings.ToLower(found[j].Name)
+	})
+	logger.Info("ListCustomers result", slog.Int("count", len(found)))
+	return found, nil
+}
+
+func (s *customerServ) GetCustomer(ctx context.Context, id string) (models.Customer, error) {
+	customer, err := s.customerRepo.FindByID(ctx, id)
+	if err != nil {
+		logging.FromContext(ctx).Warn("FindByID customer", slog.String("customer_id", id), slog.Any("error", err))
+		return models.Customer{}, err
+	}
+	return *customer, nil
+}
+
+// UpdateCustomer replaces the customer's details. The customer_id and
+// created_at of a customer never change.
+func (s *customerServ) UpdateCustomer(ctx context.Context, id string, customer models.Customer) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("UpdateCustomer", slog.String("customer_id", id))
+	current, err := s.customerRepo.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	verr := checkCustomer(&customer)
+	if customer.ID != "" && customer.ID != id {
+		verr.Add("customer_id", "customer_id cannot be changed")
+	}
+	if err := verr.OrNil(); err != nil {
+		return err
+	}
+	customer.ID = id
+	customer.CreatedAt = current.CreatedAt
+	if err := s.customerRepo.Update(ctx, id, customer); err != nil {
+		logger.Warn("Update customer", slog.String("customer_id", id), slog.Any("error", err))
+		return err
+	}
+	logger.Info("Customer updated", slog.String("customer_id", id))
+	return nil
+}
+
+// DeleteCustomer removes the customer. Linked orders block the delete unless
+// mode is DeleteCascade, which unlinks them.
+// Customers cannot be archived.
+func (s *customerServ) DeleteCustomer(ctx context.Context, id string, version int, mode DeleteMode) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("DeleteCustomer", slog.String("customer_id", id), slog.Int("version", version), slog.String("mode", string(mode)))
+	if mode == DeleteArchive {
+		return NewValidationError("dependents", "customers cannot be archived; use block or cascade")
+	}
+	customer, err := s.customerRepo.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if version != 0 && version != customer.Version {
+		return models.ErrVersionMismatch
+	}
+
+	orders, err := s.orderRepo.FindAll(ctx)
+	if err != nil {
+		logger.Error("FindAll orders", slog.Any("error", err))
+		return err
+	}
+	dependents := ordersFor(orders, id)
+	if len(dependents) != 0 && mode != DeleteCascade {
+		derr := &DependentsError{Entity: "customer", ID: id}
+		for _, order := range dependents {
+			derr.Dependents = append(derr.Dependents, Dependent{Entity: "order", ID: order.ID})
+		}
+		logger.Warn("DeleteCustomer: still linked to orders", slog.String("customer_id", id), slog.Int("count", len(dependents)))
+		return derr
+	}
+	if len(dependents) != 0 {
+		for i := range dependents {
+			dependents[i].CustomerID = ""
+			dependents[i].Version = 0
+		}
+		if err := s.orderRepo.UpdateMany(ctx, dependents); err != nil {
+			logger.Error("Unlink orders", slog.String("customer_id", id), slog.Any("error", err))
+			return err
+		}
+		logger.Info("Unlinked orders from customer", slog.String("customer_id", id), slog.Int("count", len(dependents)))
+	}
+
+	if err := s.customerRepo.Delete(ctx, id, version); err != nil {
+		logger.Warn("Delete customer", slog.String("customer_id", id), slog.Any("error", err))
+		return err
+	}
+	logger.Info("Customer deleted", slog.String("customer_id", id), slog.String("actor", auth.Actor(ctx)))
+	return nil
+}
+
+// GetCustomerHistory lists the customer's orders, newest first. Lifetime
+// spend and favourite items count closed orders only, at the unit prices the
+// lines were ordered at.
+func (s *customerServ) GetCustomerHistory(ctx context.Context, id string) (models.CustomerHistory, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("GetCustomerHistory", slog.String("customer_id", id))
+	customer, err := s.customerRepo.FindByID(ctx, id)
+	if err != nil {
+		return models.CustomerHistory{}, err
+	}
+	orders, err := s.orderRepo.FindAll(ctx)
+	if err != nil {
+		logger.Error("FindAll orders", slog.Any("error", err))
+		return models.CustomerHistory{}, err
+	}
+	menu, err := s.menuRepo.FindAll(ctx)
+	if err != nil {
+		logger.Error("FindAll menu", slog.Any("error", err))
+		return models.CustomerHistory{}, err
+	}
+	products := make(map[string]models.MenuItem, len(menu))
+	for _, item := range menu {
+		products[item.ID] = item
+	}
+
+	history := models.CustomerHistory{
+		Customer:       *customer,
+		Orders:         ordersFor(orders, id),
+		FavouriteItems: []models.PopularItem{},
+	}
+	if history.Orders == nil {
+		history.Orders = []models.Order{}
+	}
+	sort.SliceStable(history.Orders, func(i, j int) bool {
+		return history.Orders[i].CreatedAt > history.Orders[j].CreatedAt
+	})
+	history.OrderCount = len(history.Orders)
+
+	favourites := make(map[string]*models.PopularItem)
+	for _, order := range history.Orders {
+		if order.Status != "closed" {
+			continue
+		}
+		for _, line := range order.Items {
+			item, ok := favourites[line.ProductID]
+			if !ok {
+				item = &models.PopularItem{ProductID: line.ProductID, Name: products[line.ProductID].Name}
+				favourites[line.ProductID] = item
+			}
+			revenue := linePrice(ctx, order.ID, line, products) * float64(line.Quantity)
+			item.Quantity += line.Quantity
+			item.Revenue += revenue
+			history.LifetimeSpend += revenue
+		}
+	}
+	for _, item := range favourites {
+		if history.LifetimeSpend > 0 {
+			item.ShareOfSales = math.Round(item.Revenue/history.LifetimeSpend*10000) / 100
+		}
+		history.FavouriteItems = append(history.FavouriteItems, *item)
+	}
+	sort.Slice(history.FavouriteItems, func(i, j int) bool {
+		a, b := history.FavouriteItems[i], history.FavouriteItems[j]
+		if a.Quantity != b.Quantity {
+			return a.Quantity > b.Quantity
+		}
+		return a.ProductID < b.ProductID
+	})
+	if len(history.FavouriteItems) > favouriteItems {
+		history.FavouriteItems = history.FavouriteItems[:favouriteItems]
+	}
+	history.LifetimeSpend = math.Round(history.LifetimeSpend*100) / 100
+	logger.Info("CustomerHistory",
+		slog.String("customer_id", id),
+		slog.Int("orders", history.OrderCount),
+		slog.Float64("lifetime_spend", history.LifetimeSpend),
+	)
+	return history, nil
+}
+
+// checkCustomer trims the customer's fields and validates them.
+func checkCustomer(c *models.Customer) *ValidationError {
+	verr := &ValidationError{}
+	c.ID = strings.TrimSpace(c.ID)
+	c.Name = strings.TrimSpace(c.Name)
+	c.Email = strings.TrimSpace(c.Email)
+	c.Phone = strings.TrimSpace(c.Phone)
+	if c.Name == "" {
+		verr.Add("name", "name is required")
+	}
+	if c.Email != "" {
+		if addr, err := mail.ParseAddress(c.Email); err != nil || addr.Address != c.Email {
+			verr.Add("email", "must be a plain email address, e.g. ana@example.com")
+		}
+	}
+	if c.Phone != "" && !validPhone(c.Phone) {
+		verr.Add("phone", "must be 7 to 15 digits, optionally with +, spaces, dashes, dots or parentheses")
+	}
+	return verr
+}
+
+func validPhone(phone string) bool {
+	digits := 0
+	for i, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r == '+' && i == 0:
+		case strings.ContainsRune(" -.()", r):
+		default:
+			return false
+		}
+	}
+	return digits >= 7 && digits <= 15
+}
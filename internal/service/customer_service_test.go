@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"hot-coffee/models"
+)
+
+func TestCustomerHistoryUsesOrderedPrices(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	customers := NewCustomerService(st.customers, st.orders, st.menu)
+	orders := st.orderService(nil)
+	if _, err := customers.CreateCustomer(ctx, models.Customer{ID: "c1", Name: "Ana"}); err != nil {
+		t.Fatal(err)
+	}
+	placed := order("o1", line("latte", 2))
+	placed.CustomerID = "c1"
+	placed.Items[0].UnitPrice = 0.01
+	if err := orders.CreateOrder(ctx, placed); err != nil {
+		t.Fatal(err)
+	}
+	if err := orders.CloseOrder(ctx, "o1"); err != nil {
+		t.Fatal(err)
+	}
+	latte, err := st.menu.FindByID(ctx, "latte")
+	if err != nil {
+		t.Fatal(err)
+	}
+	latte.Price = 5
+	if err := st.menu.Update(ctx, "latte", *latte); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := customers.GetCustomerHistory(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.LifetimeSpend != 7 {
+		t.Errorf("LifetimeSpend = %v, want 7 (2 lattes at the ordered 3.5)", history.LifetimeSpend)
+	}
+	if got := history.Orders[0].Items[0].UnitPrice; got != 3.5 {
+		t.Errorf("UnitPrice = %v, want 3.5", got)
+	}
+
+	// The sales reports agree with the customer's spend, even once the
+	// product is gone from the menu.
+	if err := st.menu.Delete(ctx, "latte", 0); err != nil {
+		t.Fatal(err)
+	}
+	total, err := orders.GetTotalSales(ctx)
+	if err != nil {
+		t.Fatalf("GetTotalSales() error = %v", err)
+	}
+	if total.TotalSales != history.LifetimeSpend {
+		t.Errorf("TotalSales = %v, want the lifetime spend %v", total.TotalSales, history.LifetimeSpend)
+	}
+	popular, err := orders.GetPopularMenuItems(ctx, PopularItemsQuery{})
+	if err != nil {
+		t.Fatalf("GetPopularMenuItems() error = %v", err)
+	}
+	if len(popular) != 1 || popular[0].Revenue != history.LifetimeSpend {
+		t.Errorf("popular items = %+v, want latte with revenue %v", popular, history.LifetimeSpend)
+	}
+}
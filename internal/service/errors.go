@@ -0,0 +1,137 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"hot-coffee/internal/repository"
+	"hot-coffee/models"
+)
+
+var (
+	ErrNotFound          = repository.ErrNotFound
+	ErrConflict          = repository.ErrConflict
+	ErrVersionMismatch   = models.ErrVersionMismatch
+	ErrValidation        = errors.New("validation failed")
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrUnauthenticated   = errors.New("missing or invalid API key")
+	ErrConfirmation      = errors.New("confirmation required")
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every field of a request that failed a business
+// rule. It matches ErrValidation.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func NewValidationError(field, message string) *ValidationError {
+	return &ValidationError{Fields: []FieldError{{Field: field, Message: message}}}
+}
+
+func (e *ValidationError) Add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// OrNil returns e if it holds any field errors and nil otherwise, so
+// callers can collect errors and return the result unconditionally.
+func (e *ValidationError) OrNil() error {
+	if e == nil || len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// Shortage is an ingredient an order needs more of than the inventory
+// holds.
+type Shortage = repository.Shortage
+
+// InsufficientStockError lists the ingredients an order needs more of than
+// the inventory holds. It matches ErrInsufficientStock.
+type InsufficientStockError struct {
+	Shortages []Shortage
+}
+
+func (e *InsufficientStockError) Error() string {
+	msgs := make([]string, 0, len(e.Shortages))
+	for _, s := range e.Shortages {
+		msgs = append(msgs, fmt.Sprintf("Insufficient inventory for ingredient '%s'. Required: %s%s, Available: %s%s",
+			s.IngredientID, formatQuantity(s.Required), s.Unit, formatQuantity(s.Available), s.Unit))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *InsufficientStockError) Is(target error) bool {
+	return target == ErrInsufficientStock
+}
+
+// Dependent identifies a record that references another one.
+type Dependent struct {
+	Entity string `json:"entity"`
+	ID     string `json:"id"`
+}
+
+// DependentsError refuses to delete a record that others still reference.
+// It matches ErrConflict.
+type DependentsError struct {
+	Entity     string
+	ID         string
+	Dependents []Dependent
+	// Reason explains why even a cascade cannot remove the dependents.
+	Reason string
+}
+
+func (e *DependentsError) Error() string {
+	refs := make([]string, 0, len(e.Dependents))
+	for _, d := range e.Dependents {
+		refs = append(refs, d.Entity+" "+d.ID)
+	}
+	msg := fmt.Sprintf("%s %s is still referenced by %s", e.Entity, e.ID, strings.Join(refs, ", "))
+	if e.Reason != "" {
+		msg += "; " + e.Reason
+	}
+	return msg
+}
+
+func (e *DependentsError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// ConfirmationRequiredError holds back a destructive request until it is
+// repeated with Token before ExpiresAt. It matches ErrConfirmation.
+type ConfirmationRequiredError struct {
+	Action    string
+	Token     string
+	ExpiresAt time.Time
+}
+
+func (e *ConfirmationRequiredError) Error() string {
+	return e.Action + " requires confirmation: repeat the request with confirm=" + e.Token
+}
+
+func (e *ConfirmationRequiredError) Is(target error) bool {
+	return target == ErrConfirmation
+}
+
+func formatQuantity(q float64) string {
+	return strconv.FormatFloat(q, 'f', -1, 64)
+}
@@ -1,93 +1,336 @@
 package service
 
 import (
-	"fmt"
-	"log/slog"
+	"context"
 
+	"hot-coffee/internal/auth"
+	"hot-coffee/internal/logging"
 	"hot-coffee/internal/repository"
 	"hot-coffee/models"
 )
 
 type InventoryService interface {
-	AddInventoryItem(item models.InventoryItem) error
-	GetAllInventoryItem() ([]models.InventoryItem, error)
-	GetInventoryItemByID(id string) (models.InventoryItem, error)
-	UpdateInventoryItem(id string, updatedItem models.InventoryItem) error
-	DeleteInventoryItem(id string) error
+	AddInventoryItem(ctx context.Context, item models.InventoryItem) error
+	GetAllInventoryItem(ctx context.Context) ([]models.InventoryItem, error)
+	ListInventoryItems(ctx context.Context, query models.InventoryQuery) ([]models.InventoryItem, int, error)
+	GetInventoryItemByID(ctx context.Context, id string) (models.InventoryItem, error)
+	UpdateInventoryItem(ctx context.Context, id string, updatedItem models.InventoryItem) error
+	DeleteInventoryItem(ctx context.Context, id string, version int, mode DeleteMode) error
+	ArchiveInventoryItem(ctx context.Context, id string, version int) error
+	RestoreInventoryItem(ctx context.Context, id string, version int) error
+	ImportInventoryItems(ctx context.Context, items []models.InventoryItem, dryRun bool) (models.ImportResult, error)
 }
 
 type inventoryServ struct {
-	repo repository.InventoryRepository
+	repo     repository.InventoryRepository
+	menuRepo repository.MenuRepository
+	alerts   StockAlerter
 }
 
-func NewInventoryService(r repository.InventoryRepository) InventoryService {
-	return &inventoryServ{repo: r}
+func NewInventoryService(r repository.InventoryRepository, mr repository.MenuRepository, alerts StockAlerter) InventoryService {
+	return &inventoryServ{repo: r, menuRepo: mr, alerts: alerts}
 }
 
-func (s *inventoryServ) AddInventoryItem(item models.InventoryItem) error {
-	slog.Info("AddInventoryItem called", "id", item.IngredientID, "qty", item.Quantity)
+// StockAlerter is told when an update takes an inventory item below its
+// reorder level.
+type StockAlerter interface {
+	LowStock(ctx context.Context, item models.InventoryItem)
+}
+
+// alertLowStock tells alerts about an item whose stock fell from at or above
+// its reorder level to below it, so each shortage is reported once.
+func alertLowStock(ctx context.Context, alerts StockAlerter, before, after models.InventoryItem) {
+	level := after.ReorderLevel
+	if level > 0 && after.Quantity < level && before.Quantity >= level {
+		logging.FromContext(ctx).Warn("Low stock", "id", after.IngredientID, "qty", after.Quantity, "reorder_level", level)
+		alerts.LowStock(ctx, after)
+	}
+}
+
+func (s *inventoryServ) AddInventoryItem(ctx context.Context, item models.InventoryItem) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("AddInventoryItem called", "id", item.IngredientID, "qty", item.Quantity)
 	if item.Quantity < 0 {
-		slog.Warn("AddInventoryItem: negative quantity", "id", item.IngredientID, "qty", item.Quantity)
-		return fmt.Errorf("quantity must be non-negative")
+		logger.Warn("AddInventoryItem: negative quantity", "id", item.IngredientID, "qty", item.Quantity)
+		return NewValidationError("quantity", "quantity must be non-negative")
+	}
+	if item.ReorderLevel < 0 {
+		logger.Warn("AddInventoryItem: negative reorder level", "id", item.IngredientID, "reorder_level", item.ReorderLevel)
+		return NewValidationError("reorder_level", "reorder level must be non-negative")
 	}
 
-	slog.Info("AddInventoryItem: passing to repo", "id", item.IngredientID)
-	err := s.repo.Add(item)
+	logger.Info("AddInventoryItem: passing to repo", "id", item.IngredientID)
+	err := s.repo.Add(ctx, item)
 	if err != nil {
-		slog.Error("AddInventoryItem: repo.Add failed", "err", err)
+		logger.Error("AddInventoryItem: repo.Add failed", "err", err)
 		return err
 	}
-	slog.Info("AddInventoryItem: success", "id", item.IngredientID)
+	logger.Info("AddInventoryItem: success", "id", item.IngredientID)
 	return nil
 }
 
-func (s *inventoryServ) GetAllInventoryItem() ([]models.InventoryItem, error) {
-	slog.Info("GetAllInventoryItem called")
-	items, err := s.repo.FindAll()
+func (s *inventoryServ) GetAllInventoryItem(ctx context.Context) ([]models.InventoryItem, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("GetAllInventoryItem called")
+	items, err := s.repo.FindAll(ctx)
 	if err != nil {
-		slog.Error("GetAllInventoryItem: repo.FindAll failed", "err", err)
+		logger.Error("GetAllInventoryItem: repo.FindAll failed", "err", err)
 		return nil, err
 	}
-	slog.Info("GetAllInventoryItem: returning items", "count", len(items))
+	logger.Info("GetAllInventoryItem: returning items", "count", len(items))
 	return items, nil
 }
 
-func (s *inventoryServ) GetInventoryItemByID(id string) (models.InventoryItem, error) {
-	slog.Info("GetInventoryItemByID called", "id", id)
-	item, err := s.repo.FindByID(id)
+func (s *inventoryServ) ListInventoryItems(ctx context.Context, query models.InventoryQuery) ([]models.InventoryItem, int, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("ListInventoryItems called", "name", query.Name, "unit", query.Unit, "sort", query.Sort)
+	items, total, err := s.repo.FindPage(ctx, query)
 	if err != nil {
-		slog.Warn("GetInventoryItemByID: not found or repo error", "id", id, "err", err)
+		logger.Error("ListInventoryItems: repo.FindPage failed", "err", err)
+		return nil, 0, err
+	}
+	logger.Info("ListInventoryItems: returning items", "count", len(items), "total", total)
+	return items, total, nil
+}
+
+func (s *inventoryServ) GetInventoryItemByID(ctx context.Context, id string) (models.InventoryItem, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("GetInventoryItemByID called", "id", id)
+	item, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		logger.Warn("GetInventoryItemByID: not found or repo error", "id", id, "err", err)
 		return models.InventoryItem{}, err
 	}
-	slog.Info("GetInventoryItemByID: found", "id", id)
+	logger.Info("GetInventoryItemByID: found", "id", id)
 	return *item, nil
 }
 
-func (s *inventoryServ) UpdateInventoryItem(id string, updatedItem models.InventoryItem) error {
-	slog.Info("UpdateInventoryItem called", "id", id, "newQty", updatedItem.Quantity)
+func (s *inventoryServ) UpdateInventoryItem(ctx context.Context, id string, updatedItem models.InventoryItem) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("UpdateInventoryItem called", "id", id, "newQty", updatedItem.Quantity)
 
 	if updatedItem.Quantity < 0 {
-		slog.Warn("UpdateInventoryItem: negative quantity", "id", id, "qty", updatedItem.Quantity)
-		return fmt.Errorf("quantity must be non-negative")
+		logger.Warn("UpdateInventoryItem: negative quantity", "id", id, "qty", updatedItem.Quantity)
+		return NewValidationError("quantity", "quantity must be non-negative")
 	}
 
-	slog.Info("UpdateInventoryItem: passing to repo", "id", id)
-	err := s.repo.Update(id, updatedItem)
+	if updatedItem.ReorderLevel < 0 {
+		logger.Warn("UpdateInventoryItem: negative reorder level", "id", id, "reorder_level", updatedItem.ReorderLevel)
+		return NewValidationError("reorder_level", "reorder level must be non-negative")
+	}
+	before, err := s.repo.FindByID(ctx, id)
 	if err != nil {
-		slog.Error("UpdateInventoryItem: repo.Update failed", "id", id, "err", err)
+		logger.Warn("UpdateInventoryItem: not found or repo error", "id", id, "err", err)
+		return err
+	}
+
+	logger.Info("UpdateInventoryItem: passing to repo", "id", id)
+	if err := s.repo.Update(ctx, id, updatedItem); err != nil {
+		logger.Error("UpdateInventoryItem: repo.Update failed", "id", id, "err", err)
 		return err
 	}
-	slog.Info("UpdateInventoryItem: success", "id", id)
+	if updatedItem.IngredientID != id {
+		if err := s.renameInRecipes(ctx, id, updatedItem.IngredientID); err != nil {
+			logger.Error("UpdateInventoryItem: renaming in recipes failed; restoring the old ID", "id", id, "err", err)
+			restore := *before
+			restore.Version = 0
+			if rerr := s.repo.Update(ctx, updatedItem.IngredientID, restore); rerr != nil {
+				logger.Error("UpdateInventoryItem: restoring the old ID failed", "id", id, "new_id", updatedItem.IngredientID, "err", rerr)
+			}
+			return err
+		}
+	}
+	alertLowStock(ctx, s.alerts, *before, updatedItem)
+	logger.Info("UpdateInventoryItem: success", "id", id)
 	return nil
 }
 
-func (s *inventoryServ) DeleteInventoryItem(id string) error {
-	slog.Info("DeleteInventoryItem called", "id", id)
-	err := s.repo.Delete(id)
+// renameInRecipes points every recipe using oldID at newID. The recipes are
+// written together, all or none.
+func (s *inventoryServ) renameInRecipes(ctx context.Context, oldID, newID string) error {
+	menuItems, err := s.menuRepo.FindAll(ctx)
 	if err != nil {
-		slog.Warn("DeleteInventoryItem: repo.Delete failed or not found", "id", id, "err", err)
 		return err
 	}
-	slog.Info("DeleteInventoryItem: success", "id", id)
+	recipes := recipesUsing(menuItems, oldID)
+	if len(recipes) == 0 {
+		return nil
+	}
+	for i := range recipes {
+		for j := range recipes[i].Ingredients {
+			if recipes[i].Ingredients[j].IngredientID == oldID {
+				recipes[i].Ingredients[j].IngredientID = newID
+			}
+		}
+		recipes[i].Version = 0
+	}
+	if err := s.menuRepo.UpdateMany(ctx, recipes); err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Info("renamed ingredient in recipes", "from", oldID, "to", newID, "count", len(recipes))
 	return nil
 }
+
+func (s *inventoryServ) DeleteInventoryItem(ctx context.Context, id string, version int, mode DeleteMode) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("DeleteInventoryItem called", "id", id, "version", version, "mode", mode)
+	if mode == DeleteArchive {
+		logger.Info("DeleteInventoryItem: archiving instead of deleting", "id", id)
+		return s.setArchived(ctx, id, version, true)
+	}
+	item, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if version != 0 && version != item.Version {
+		return models.ErrVersionMismatch
+	}
+
+	menuItems, err := s.menuRepo.FindAll(ctx)
+	if err != nil {
+		logger.Error("DeleteInventoryItem: menuRepo.FindAll failed", "err", err)
+		return err
+	}
+	recipes := recipesUsing(menuItems, id)
+	if len(recipes) != 0 && mode != DeleteCascade {
+		derr := &DependentsError{Entity: "inventory item", ID: id}
+		for _, recipe := range recipes {
+			derr.Dependents = append(derr.Dependents, Dependent{Entity: "menu item", ID: recipe.ID})
+		}
+		logger.Warn("DeleteInventoryItem: still used by recipes", "id", id, "count", len(recipes))
+		return derr
+	}
+
+	// Work out every recipe change before writing any, and refuse to leave
+	// a recipe empty: a menu item without ingredients fails validation.
+	originals := make([]models.MenuItem, 0, len(recipes))
+	emptied := &DependentsError{Entity: "inventory item", ID: id, Reason: "their recipes would be left empty; archive the ingredient with dependents=archive"}
+	for i, recipe := range recipes {
+		original := recipe
+		original.Version = 0
+		originals = append(originals, original)
+		var kept []models.MenuItemIngredient
+		for _, ingredient := range recipe.Ingredients {
+			if ingredient.IngredientID != id {
+				kept = append(kept, ingredient)
+			}
+		}
+		if len(kept) == 0 {
+			emptied.Dependents = append(emptied.Dependents, Dependent{Entity: "menu item", ID: recipe.ID})
+		}
+		recipes[i].Ingredients = kept
+		recipes[i].Version = 0
+	}
+	if len(emptied.Dependents) != 0 {
+		logger.Warn("DeleteInventoryItem: would empty recipes", "id", id, "count", len(emptied.Dependents))
+		return emptied
+	}
+	if len(recipes) != 0 {
+		if err := s.menuRepo.UpdateMany(ctx, recipes); err != nil {
+			logger.Error("DeleteInventoryItem: removing from recipes failed", "id", id, "err", err)
+			return err
+		}
+		logger.Info("DeleteInventoryItem: removed from recipes", "id", id, "count", len(recipes))
+	}
+
+	err = s.repo.Delete(ctx, id, version)
+	if err != nil {
+		logger.Warn("DeleteInventoryItem: repo.Delete failed or not found", "id", id, "err", err)
+		if len(originals) != 0 {
+			if rerr := s.menuRepo.UpdateMany(ctx, originals); rerr != nil {
+				logger.Error("DeleteInventoryItem: restoring recipes failed", "id", id, "err", rerr)
+			}
+		}
+		return err
+	}
+	logger.Info("DeleteInventoryItem: success", "id", id, "actor", auth.Actor(ctx))
+	return nil
+}
+
+// ArchiveInventoryItem retires an ingredient without deleting it: products
+// whose recipe uses it can no longer be ordered and it leaves default
+// listings, but recipes and reports still resolve it.
+func (s *inventoryServ) ArchiveInventoryItem(ctx context.Context, id string, version int) error {
+	return s.setArchived(ctx, id, version, true)
+}
+
+// RestoreInventoryItem returns an archived ingredient to use.
+func (s *inventoryServ) RestoreInventoryItem(ctx context.Context, id string, version int) error {
+	return s.setArchived(ctx, id, version, false)
+}
+
+func (s *inventoryServ) setArchived(ctx context.Context, id string, version int, archived bool) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("setArchived called", "id", id, "version", version, "archived", archived)
+	item, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if version != 0 && version != item.Version {
+		return models.ErrVersionMismatch
+	}
+	if item.Archived == archived {
+		logger.Info("setArchived: already in requested state", "id", id)
+		return nil
+	}
+	item.Archived = archived
+	if err := s.repo.Update(ctx, id, *item); err != nil {
+		logger.Error("setArchived: repo.Update failed", "id", id, "err", err)
+		return err
+	}
+	logger.Info("setArchived: success", "id", id, "archived", archived)
+	return nil
+}
+
+func (s *inventoryServ) ImportInventoryItems(ctx context.Context, items []models.InventoryItem, dryRun bool) (models.ImportResult, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("ImportInventoryItems called", "count", len(items), "dryRun", dryRun)
+	result := models.ImportResult{DryRun: dryRun, Total: len(items), Errors: []models.ImportRowError{}}
+
+	inventory, err := s.repo.FindAll(ctx)
+	if err != nil {
+		logger.Error("ImportInventoryItems: repo.FindAll failed", "err", err)
+		return result, err
+	}
+	ids := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, exist := range inventory {
+		ids[exist.IngredientID] = true
+		names[exist.Name] = true
+	}
+
+	for i, item := range items {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		rowErr := func(err error) {
+			result.Errors = append(result.Errors, models.ImportRowError{Row: i + 1, ID: item.IngredientID, Error: err.Error()})
+		}
+		if item.Quantity < 0 {
+			rowErr(NewValidationError("quantity", "quantity must be non-negative"))
+		}
+		if item.ReorderLevel < 0 {
+			rowErr(NewValidationError("reorder_level", "reorder level must be non-negative"))
+		}
+		if ids[item.IngredientID] {
+			rowErr(&repository.ConflictError{Entity: "inventory item", Field: "ingredient_id", Value: item.IngredientID})
+		}
+		if names[item.Name] {
+			rowErr(&repository.ConflictError{Entity: "inventory item", Field: "name", Value: item.Name})
+		}
+		ids[item.IngredientID] = true
+		names[item.Name] = true
+	}
+
+	if len(result.Errors) != 0 || dryRun {
+		logger.Info("ImportInventoryItems: nothing applied", "errors", len(result.Errors), "dryRun", dryRun)
+		return result, nil
+	}
+	if err := s.repo.AddMany(ctx, items); err != nil {
+		logger.Error("ImportInventoryItems: repo.AddMany failed", "err", err)
+		return result, err
+	}
+	result.Imported = len(items)
+	logger.Info("ImportInventoryItems: success", "count", len(items))
+	return result, nil
+}
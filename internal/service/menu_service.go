@@ -1,150 +1,348 @@
 package service
 
 import (
+	"context"
 	"fmt"
-	"log/slog"
 
+	"hot-coffee/internal/auth"
+	"hot-coffee/internal/logging"
 	"hot-coffee/internal/repository"
 	"hot-coffee/models"
 )
 
 type MenuService interface {
-	AddMenuItem(item models.MenuItem) error
-	GetAllMenuItems() ([]models.MenuItem, error)
-	GetMenuItemByID(id string) (models.MenuItem, error)
-	UpdateMenuItem(id string, updatedItem models.MenuItem) error
-	DeleteMenuItem(id string) error
+	AddMenuItem(ctx context.Context, item models.MenuItem) error
+	GetAllMenuItems(ctx context.Context) ([]models.MenuItem, error)
+	ListMenuItems(ctx context.Context, query models.MenuQuery) ([]models.MenuItem, int, error)
+	GetMenuItemByID(ctx context.Context, id string) (models.MenuItem, error)
+	UpdateMenuItem(ctx context.Context, id string, updatedItem models.MenuItem) error
+	DeleteMenuItem(ctx context.Context, id string, version int, mode DeleteMode) error
+	ArchiveMenuItem(ctx context.Context, id string, version int) error
+	RestoreMenuItem(ctx context.Context, id string, version int) error
+	ImportMenuItems(ctx context.Context, items []models.MenuItem, dryRun bool) (models.ImportResult, error)
 }
 
 type menuServ struct {
-	menuRepo repository.MenuRepository
-	invRepo  repository.InventoryRepository
+	menuRepo  repository.MenuRepository
+	invRepo   repository.InventoryRepository
+	orderRepo repository.OrderRepository
+	orders    OrderCanceller
 }
 
-func NewMenuService(mr repository.MenuRepository, ir repository.InventoryRepository) MenuService {
-	return &menuServ{menuRepo: mr, invRepo: ir}
+func NewMenuService(mr repository.MenuRepository, ir repository.InventoryRepository, or repository.OrderRepository, orders OrderCanceller) MenuService {
+	return &menuServ{menuRepo: mr, invRepo: ir, orderRepo: or, orders: orders}
 }
 
-func (s *menuServ) AddMenuItem(item models.MenuItem) error {
-	slog.Info("AddMenuItem called", "id", item.ID, "name", item.Name, "price", item.Price)
+func (s *menuServ) AddMenuItem(ctx context.Context, item models.MenuItem) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("AddMenuItem called", "id", item.ID, "name", item.Name, "price", item.Price)
 
-	if item.Price <= 0 {
-		slog.Warn("AddMenuItem: non-positive price", "price", item.Price)
-		return fmt.Errorf("price must be non-negative")
+	logger.Info("AddMenuItem: checking inventory for ingredients")
+	ingredients, err := s.ingredientIDs(ctx)
+	if err != nil {
+		logger.Error("AddMenuItem: invRepo.FindAll failed", "err", err)
+		return err
 	}
-
-	for _, ingredient := range item.Ingredients {
-		if ingredient.Quantity < 0 {
-			slog.Warn("AddMenuItem: ingredient negative quantity", "ingredient", ingredient.IngredientID, "qty", ingredient.Quantity)
-			return fmt.Errorf("quantity must be non-negative value")
-		}
+	if err := checkMenuItem(item, ingredients).OrNil(); err != nil {
+		logger.Warn("AddMenuItem: validation failed", "id", item.ID, "err", err)
+		return err
 	}
 
-	slog.Info("AddMenuItem: loading existing menu items")
-	menuItems, _ := s.menuRepo.FindAll()
+	logger.Info("AddMenuItem: saving new menu item to repo")
+	if err := s.menuRepo.Add(ctx, item); err != nil {
+		logger.Error("AddMenuItem: repo.Add failed", "err", err)
+		return err
+	}
+	logger.Info("AddMenuItem: success", "id", item.ID)
+	return nil
+}
 
-	for _, exist := range menuItems {
-		if exist.ID == item.ID {
-			slog.Warn("AddMenuItem: duplicate ID", "id", item.ID)
-			return fmt.Errorf("Menu item ID already exists")
-		}
-		if exist.Name == item.Name {
-			slog.Warn("AddMenuItem: duplicate name", "name", item.Name)
-			return fmt.Errorf("Menu item name already exists")
-		}
+func (s *menuServ) ingredientIDs(ctx context.Context) (map[string]bool, error) {
+	inventoryItems, err := s.invRepo.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(inventoryItems))
+	for _, invItem := range inventoryItems {
+		ids[invItem.IngredientID] = true
+	}
+	return ids, nil
+}
 
-		slog.Info("AddMenuItem: checking inventory for ingredients")
-		inventoryItems, _ := s.invRepo.FindAll()
-		var matchedIngredients []string
-		for _, invItem := range inventoryItems {
-			for _, ingredient := range item.Ingredients {
-				if invItem.IngredientID == ingredient.IngredientID {
-					matchedIngredients = append(matchedIngredients, ingredient.IngredientID)
-				}
-			}
+// checkMenuItem applies the business rules every stored menu item must
+// satisfy: a positive price and a recipe made of known ingredients.
+func checkMenuItem(item models.MenuItem, ingredients map[string]bool) *ValidationError {
+	verr := &ValidationError{}
+	if item.Price <= 0 {
+		verr.Add("price", "price must be positive")
+	}
+	for i, ingredient := range item.Ingredients {
+		if ingredient.Quantity < 0 {
+			verr.Add(fmt.Sprintf("ingredients[%d].quantity", i), "quantity must be non-negative")
 		}
-		var missingIngredients []string
-		if len(matchedIngredients) != len(item.Ingredients) {
-			for _, ingredient := range item.Ingredients {
-				found := false
-				for _, mIngredient := range matchedIngredients {
-					if ingredient.IngredientID == mIngredient {
-						found = true
-					}
-				}
-				if !found {
-					missingIngredients = append(missingIngredients, ingredient.IngredientID)
-				}
-			}
-			if len(missingIngredients) != 0 {
-				var list string
-				for i, ingredient := range missingIngredients {
-					list += ingredient
-					if i != len(missingIngredients)-1 {
-						list += ", "
-					}
-				}
-				slog.Warn("AddMenuItem: missing ingredients", "missing", missingIngredients)
-				return fmt.Errorf("following ingredients missing: %s", list)
-			}
+		if !ingredients[ingredient.IngredientID] {
+			verr.Add(fmt.Sprintf("ingredients[%d].ingredient_id", i), "ingredient "+ingredient.IngredientID+" not found in inventory")
 		}
 	}
+	return verr
+}
 
-	slog.Info("AddMenuItem: saving new menu item to repo")
-	err := s.menuRepo.Add(item)
+func (s *menuServ) GetAllMenuItems(ctx context.Context) ([]models.MenuItem, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("GetAllMenuItems called")
+	items, err := s.menuRepo.FindAll(ctx)
 	if err != nil {
-		slog.Error("AddMenuItem: repo.Add failed", "err", err)
-		return err
+		logger.Error("GetAllMenuItems: repo.FindAll failed", "err", err)
+		return nil, err
 	}
-	slog.Info("AddMenuItem: success", "id", item.ID)
-	return nil
+	logger.Info("GetAllMenuItems: returning items", "count", len(items))
+	return items, nil
 }
 
-func (s *menuServ) GetAllMenuItems() ([]models.MenuItem, error) {
-	slog.Info("GetAllMenuItems called")
-	items, err := s.menuRepo.FindAll()
+func (s *menuServ) ListMenuItems(ctx context.Context, query models.MenuQuery) ([]models.MenuItem, int, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("ListMenuItems called", "name", query.Name, "sort", query.Sort)
+	items, total, err := s.menuRepo.FindPage(ctx, query)
 	if err != nil {
-		slog.Error("GetAllMenuItems: repo.FindAll failed", "err", err)
-		return nil, err
+		logger.Error("ListMenuItems: repo.FindPage failed", "err", err)
+		return nil, 0, err
 	}
-	slog.Info("GetAllMenuItems: returning items", "count", len(items))
-	return items, nil
+	logger.Info("ListMenuItems: returning items", "count", len(items), "total", total)
+	return items, total, nil
 }
 
-func (s *menuServ) GetMenuItemByID(id string) (models.MenuItem, error) {
-	slog.Info("GetMenuItemByID called", "id", id)
-	ptr, err := s.menuRepo.FindByID(id)
+func (s *menuServ) GetMenuItemByID(ctx context.Context, id string) (models.MenuItem, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("GetMenuItemByID called", "id", id)
+	ptr, err := s.menuRepo.FindByID(ctx, id)
 	if err != nil {
-		slog.Warn("GetMenuItemByID: not found", "id", id, "err", err)
+		logger.Warn("GetMenuItemByID: not found", "id", id, "err", err)
 		return models.MenuItem{}, err
 	}
-	slog.Info("GetMenuItemByID: found", "id", id)
+	logger.Info("GetMenuItemByID: found", "id", id)
 	return *ptr, nil
 }
 
-func (s *menuServ) UpdateMenuItem(id string, updatedItem models.MenuItem) error {
-	slog.Info("UpdateMenuItem called", "id", id)
-	if updatedItem.Price <= 0 {
-		slog.Warn("UpdateMenuItem: non-positive price", "price", updatedItem.Price)
-		return fmt.Errorf("price must be non-negative")
+func (s *menuServ) UpdateMenuItem(ctx context.Context, id string, updatedItem models.MenuItem) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("UpdateMenuItem called", "id", id)
+	ingredients, err := s.ingredientIDs(ctx)
+	if err != nil {
+		logger.Error("UpdateMenuItem: invRepo.FindAll failed", "err", err)
+		return err
 	}
-	slog.Info("UpdateMenuItem: passing update to repo", "id", id)
-	err := s.menuRepo.Update(id, updatedItem)
+	if err := checkMenuItem(updatedItem, ingredients).OrNil(); err != nil {
+		logger.Warn("UpdateMenuItem: validation failed", "id", id, "err", err)
+		return err
+	}
+	before, err := s.menuRepo.FindByID(ctx, id)
 	if err != nil {
-		slog.Error("UpdateMenuItem: repo.Update failed", "id", id, "err", err)
 		return err
 	}
-	slog.Info("UpdateMenuItem: success", "id", id)
+	logger.Info("UpdateMenuItem: passing update to repo", "id", id)
+	err = s.menuRepo.Update(ctx, id, updatedItem)
+	if err != nil {
+		logger.Error("UpdateMenuItem: repo.Update failed", "id", id, "err", err)
+		return err
+	}
+	if updatedItem.ID != id {
+		if err := s.renameInOrders(ctx, id, updatedItem.ID); err != nil {
+			logger.Error("UpdateMenuItem: renaming in orders failed; restoring the old ID", "id", id, "err", err)
+			before.Version = 0
+			if rerr := s.menuRepo.Update(ctx, updatedItem.ID, *before); rerr != nil {
+				logger.Error("UpdateMenuItem: restoring the old ID failed", "id", id, "new_id", updatedItem.ID, "err", rerr)
+			}
+			return err
+		}
+	}
+	logger.Info("UpdateMenuItem: success", "id", id)
 	return nil
 }
 
-func (s *menuServ) DeleteMenuItem(id string) error {
-	slog.Info("DeleteMenuItem called", "id", id)
-	err := s.menuRepo.Delete(id)
+// renameInOrders points every order line for oldID at newID, so reports keep
+// finding the product. The orders are written together, all or none.
+func (s *menuServ) renameInOrders(ctx context.Context, oldID, newID string) error {
+	orders, err := s.orderRepo.FindAll(ctx)
 	if err != nil {
-		slog.Warn("DeleteMenuItem: repo.Delete failed", "id", id, "err", err)
 		return err
 	}
-	slog.Info("DeleteMenuItem: success", "id", id)
+	renamed := ordersWith(orders, oldID)
+	for i := range renamed {
+		for j := range renamed[i].Items {
+			if renamed[i].Items[j].ProductID == oldID {
+				renamed[i].Items[j].ProductID = newID
+			}
+		}
+		renamed[i].Version = 0
+	}
+	if len(renamed) == 0 {
+		return nil
+	}
+	if err := s.orderRepo.UpdateMany(ctx, renamed); err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Info("renamed product in orders", "from", oldID, "to", newID, "count", len(renamed))
 	return nil
 }
+
+func (s *menuServ) DeleteMenuItem(ctx context.Context, id string, version int, mode DeleteMode) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("DeleteMenuItem called", "id", id, "version", version, "mode", mode)
+	if mode == DeleteArchive {
+		logger.Info("DeleteMenuItem: archiving instead of deleting", "id", id)
+		return s.setArchived(ctx, id, version, true)
+	}
+	item, err := s.menuRepo.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if version != 0 && version != item.Version {
+		return models.ErrVersionMismatch
+	}
+
+	orders, err := s.orderRepo.FindAll(ctx)
+	if err != nil {
+		logger.Error("DeleteMenuItem: orderRepo.FindAll failed", "err", err)
+		return err
+	}
+	dependents := ordersWith(orders, id)
+	if len(dependents) != 0 && mode != DeleteCascade {
+		derr := &DependentsError{Entity: "menu item", ID: id}
+		for _, order := range dependents {
+			derr.Dependents = append(derr.Dependents, Dependent{Entity: "order", ID: order.ID})
+		}
+		logger.Warn("DeleteMenuItem: still used by orders", "id", id, "count", len(dependents))
+		return derr
+	}
+	// Closed orders are sales history; a product sold before can only be
+	// archived.
+	closed := &DependentsError{Entity: "menu item", ID: id, Reason: "closed orders are kept; archive the product with dependents=archive"}
+	for _, order := range dependents {
+		if order.Status == "closed" {
+			closed.Dependents = append(closed.Dependents, Dependent{Entity: "order", ID: order.ID})
+		}
+	}
+	if len(closed.Dependents) != 0 {
+		logger.Warn("DeleteMenuItem: sold in closed orders", "id", id, "count", len(closed.Dependents))
+		return closed
+	}
+	// The orders are cancelled while the product still resolves, so their
+	// stock can be returned. Every step checks the version read above; if
+	// one fails, the orders cancelled so far are restored.
+	var cancelled []models.Order
+	restore := func() {
+		for _, order := range cancelled {
+			if err := s.orders.RestoreOrder(ctx, order); err != nil {
+				logger.Error("DeleteMenuItem: restoring order failed", "order_id", order.ID, "err", err)
+			}
+		}
+	}
+	for _, order := range dependents {
+		if err := s.orders.DeleteOrder(ctx, order.ID, order.Version); err != nil {
+			logger.Error("DeleteMenuItem: cancelling order failed", "order_id", order.ID, "err", err)
+			restore()
+			return err
+		}
+		cancelled = append(cancelled, order)
+		logger.Info("DeleteMenuItem: cancelled order", "id", id, "order_id", order.ID)
+	}
+
+	err = s.menuRepo.Delete(ctx, id, item.Version)
+	if err != nil {
+		logger.Warn("DeleteMenuItem: repo.Delete failed", "id", id, "err", err)
+		restore()
+		return err
+	}
+	logger.Info("DeleteMenuItem: success", "id", id, "actor", auth.Actor(ctx))
+	return nil
+}
+
+// ArchiveMenuItem takes a product off the menu without deleting it: it can
+// no longer be ordered and leaves default listings, but existing orders and
+// reports still resolve it.
+func (s *menuServ) ArchiveMenuItem(ctx context.Context, id string, version int) error {
+	return s.setArchived(ctx, id, version, true)
+}
+
+// RestoreMenuItem puts an archived product back on the menu.
+func (s *menuServ) RestoreMenuItem(ctx context.Context, id string, version int) error {
+	return s.setArchived(ctx, id, version, false)
+}
+
+func (s *menuServ) setArchived(ctx context.Context, id string, version int, archived bool) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("setArchived called", "id", id, "version", version, "archived", archived)
+	item, err := s.menuRepo.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if version != 0 && version != item.Version {
+		return models.ErrVersionMismatch
+	}
+	if item.Archived == archived {
+		logger.Info("setArchived: already in requested state", "id", id)
+		return nil
+	}
+	item.Archived = archived
+	if err := s.menuRepo.Update(ctx, id, *item); err != nil {
+		logger.Error("setArchived: repo.Update failed", "id", id, "err", err)
+		return err
+	}
+	logger.Info("setArchived: success", "id", id, "archived", archived)
+	return nil
+}
+
+func (s *menuServ) ImportMenuItems(ctx context.Context, items []models.MenuItem, dryRun bool) (models.ImportResult, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("ImportMenuItems called", "count", len(items), "dryRun", dryRun)
+	result := models.ImportResult{DryRun: dryRun, Total: len(items), Errors: []models.ImportRowError{}}
+
+	menuItems, err := s.menuRepo.FindAll(ctx)
+	if err != nil {
+		logger.Error("ImportMenuItems: menuRepo.FindAll failed", "err", err)
+		return result, err
+	}
+	ingredients, err := s.ingredientIDs(ctx)
+	if err != nil {
+		logger.Error("ImportMenuItems: invRepo.FindAll failed", "err", err)
+		return result, err
+	}
+	ids := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, exist := range menuItems {
+		ids[exist.ID] = true
+		names[exist.Name] = true
+	}
+
+	for i, item := range items {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		rowErr := func(err error) {
+			result.Errors = append(result.Errors, models.ImportRowError{Row: i + 1, ID: item.ID, Error: err.Error()})
+		}
+		for _, f := range checkMenuItem(item, ingredients).Fields {
+			rowErr(NewValidationError(f.Field, f.Message))
+		}
+		if ids[item.ID] {
+			rowErr(&repository.ConflictError{Entity: "menu item", Field: "product_id", Value: item.ID})
+		}
+		if names[item.Name] {
+			rowErr(&repository.ConflictError{Entity: "menu item", Field: "name", Value: item.Name})
+		}
+		ids[item.ID] = true
+		names[item.Name] = true
+	}
+
+	if len(result.Errors) != 0 || dryRun {
+		logger.Info("ImportMenuItems: nothing applied", "errors", len(result.Errors), "dryRun", dryRun)
+		return result, nil
+	}
+	if err := s.menuRepo.AddMany(ctx, items); err != nil {
+		logger.Error("ImportMenuItems: repo.AddMany failed", "err", err)
+		return result, err
+	}
+	result.Imported = len(items)
+	logger.Info("ImportMenuItems: success", "count", len(items))
+	return result, nil
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hot-coffee/models"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before it
+// is disconnected; it can resume from the event log with its last event ID.
+const subscriberBuffer = 64
+
+// OrderEvents keeps the latest order events in a bounded in-memory log and
+// fans new ones out to subscribers. Event IDs are "<epoch>-<seq>"; the epoch
+// changes with every process so IDs from before a restart are recognised as
+// unknown instead of being resumed from the wrong place.
+type OrderEvents struct {
+	mu     sync.Mutex
+	epoch  string
+	seq    uint64
+	size   int
+	log    []models.OrderEvent
+	subs   map[*OrderSubscription]struct{}
+	closed bool
+
+	listeners []func(ctx context.Context, event models.OrderEvent)
+}
+
+// NewOrderEvents creates an event log holding the last size events.
+func NewOrderEvents(size int) *OrderEvents {
+	return &OrderEvents{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		size:  max(size, 1),
+		subs:  map[*OrderSubscription]struct{}{},
+	}
+}
+
+// OrderSubscription delivers the order events after the point a subscriber
+// resumed from. Backlog holds the logged events it missed. Gap is set when
+// the requested event is no longer, or never was, in the log, so events may
+// have been lost and the subscriber should reload the orders. Events is
+// closed when the subscriber falls too far behind or the log is closed.
+type OrderSubscription struct {
+	Backlog []models.OrderEvent
+	Gap     bool
+	Events  <-chan models.OrderEvent
+
+	events chan models.OrderEvent
+	owner  *OrderEvents
+}
+
+// Listen registers fn to be called with every event published from then on.
+// It runs in the publishing goroutine, after subscribers have been sent the
+// event, with a context that is not canceled.
+func (e *OrderEvents) Listen(fn func(ctx context.Context, event models.OrderEvent)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.listeners = append(e.listeners, fn)
+}
+
+func (e *OrderEvents) publish(ctx context.Context, typ string, order models.Order, previousStatus string) {
+	event, listeners, ok := e.append(typ, order, previousStatus)
+	if !ok {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	for _, fn := range listeners {
+		fn(ctx, event)
+	}
+}
+
+// append logs a new event and sends it to the subscribers. It reports false
+// once the log is closed.
+func (e *OrderEvents) append(typ string, order models.Order, previousStatus string) (models.OrderEvent, []func(context.Context, models.OrderEvent), bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return models.OrderEvent{}, nil, false
+	}
+	e.seq++
+	event := models.OrderEvent{
+		ID:             e.epoch + "-" + strconv.FormatUint(e.seq, 10),
+		Type:           typ,
+		Time:           time.Now().UTC().Format(time.RFC3339Nano),
+		Order:          order,
+		PreviousStatus: previousStatus,
+	}
+	if len(e.log) == e.size {
+		e.log = append(e.log[:0], e.log[1:]...)
+	}
+	e.log = append(e.log, event)
+	for sub := range e.subs {
+		select {
+		case sub.events <- event:
+		default:
+			delete(e.subs, sub)
+			close(sub.events)
+		}
+	}
+	return event, e.listeners, true
+}
+
+// Subscribe starts a subscription after lastEventID; an empty ID starts with
+// the next event.
+func (e *OrderEvents) Subscribe(lastEventID string) *OrderSubscription {
+	events := make(chan models.OrderEvent, subscriberBuffer)
+	sub := &OrderSubscription{Events: events, events: events, owner: e}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if lastEventID != "" {
+		sub.Backlog, sub.Gap = e.after(lastEventID)
+	}
+	if e.closed {
+		close(events)
+		return sub
+	}
+	e.subs[sub] = struct{}{}
+	return sub
+}
+
+// after returns the logged events following id and whether id could not be
+// placed in the log.
+func (e *OrderEvents) after(id string) ([]models.OrderEvent, bool) {
+	epoch, n, ok := strings.Cut(id, "-")
+	if !ok || epoch != e.epoch {
+		return nil, true
+	}
+	seq, err := strconv.ParseUint(n, 10, 64)
+	if err != nil || seq > e.seq {
+		return nil, true
+	}
+	missed := int(e.seq - seq)
+	if missed > len(e.log) {
+		return nil, true
+	}
+	return append([]models.OrderEvent(nil), e.log[len(e.log)-missed:]...), false
+}
+
+// Cancel stops delivery to the subscription.
+func (sub *OrderSubscription) Cancel() {
+	e := sub.owner
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.subs[sub]; ok {
+		delete(e.subs, sub)
+		close(sub.events)
+	}
+}
+
+// Close ends every subscription, letting open streams finish on shutdown.
+// Events published afterwards are dropped.
+func (e *OrderEvents) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	for sub := range e.subs {
+		delete(e.subs, sub)
+		close(sub.events)
+	}
+}
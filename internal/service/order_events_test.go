@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"hot-coffee/models"
+)
+
+func TestOrderEventsResume(t *testing.T) {
+	tests := []struct {
+		name        string
+		lastEventID func(ids []string) string
+		wantBacklog []string
+		wantGap     bool
+	}{
+		{
+			name:        "no ID starts with the next event",
+			lastEventID: func([]string) string { return "" },
+		},
+		{
+			name:        "replays the events after the last one seen",
+			lastEventID: func(ids []string) string { return ids[2] },
+			wantBacklog: []string{"o4", "o5"},
+		},
+		{
+			name:        "up to date",
+			lastEventID: func(ids []string) string { return ids[4] },
+		},
+		{
+			name:        "evicted from the log",
+			lastEventID: func(ids []string) string { return ids[0] },
+			wantGap:     true,
+		},
+		{
+			name:        "from before a restart",
+			lastEventID: func([]string) string { return "previous-1" },
+			wantGap:     true,
+		},
+		{
+			name:        "not yet issued",
+			lastEventID: func(ids []string) string { return ids[4] + "0" },
+			wantGap:     true,
+		},
+		{
+			name:        "malformed",
+			lastEventID: func([]string) string { return "garbage" },
+			wantGap:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := NewOrderEvents(3)
+			var ids []string
+			events.Listen(func(_ context.Context, event models.OrderEvent) { ids = append(ids, event.ID) })
+			for _, id := range []string{"o1", "o2", "o3", "o4", "o5"} {
+				events.publish(context.Background(), models.OrderCreated, models.Order{ID: id}, "")
+			}
+
+			sub := events.Subscribe(tt.lastEventID(ids))
+			defer sub.Cancel()
+			if sub.Gap != tt.wantGap {
+				t.Errorf("Gap = %v, want %v", sub.Gap, tt.wantGap)
+			}
+			var got []string
+			for _, event := range sub.Backlog {
+				got = append(got, event.Order.ID)
+			}
+			if len(got) != len(tt.wantBacklog) {
+				t.Fatalf("Backlog = %v, want %v", got, tt.wantBacklog)
+			}
+			for i := range got {
+				if got[i] != tt.wantBacklog[i] {
+					t.Fatalf("Backlog = %v, want %v", got, tt.wantBacklog)
+				}
+			}
+
+			// Live events follow the backlog.
+			events.publish(context.Background(), models.OrderCreated, models.Order{ID: "o6"}, "")
+			if event := <-sub.Events; event.Order.ID != "o6" {
+				t.Errorf("live event for %s, want o6", event.Order.ID)
+			}
+		})
+	}
+}
@@ -1,161 +1,240 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"sort"
-	"strconv"
 	"time"
 
+	"hot-coffee/internal/auth"
+	"hot-coffee/internal/logging"
+	"hot-coffee/internal/metrics"
 	"hot-coffee/internal/repository"
 	"hot-coffee/models"
 )
 
 type OrderService interface {
-	CreateOrder(order models.Order) ([]string, error)
-	GetOrders() ([]models.Order, error)
-	GetOrderById(id string) (models.Order, error)
-	UpdateOrder(id string, order models.Order) ([]string, error)
-	DeleteOrder(id string) error
-	CloseOrder(id string) error
-	GetTotalSales() (models.Total, error)
-	GetPopularMenuItems() ([]string, error)
+	CreateOrder(ctx context.Context, order models.Order) error
+	GetOrders(ctx context.Context) ([]models.Order, error)
+	ListOrders(ctx context.Context, query models.OrderQuery) ([]models.Order, int, error)
+	GetOrderById(ctx context.Context, id string) (models.Order, error)
+	UpdateOrder(ctx context.Context, id string, order models.Order) error
+	DeleteOrder(ctx context.Context, id string, version int) error
+	CloseOrder(ctx context.Context, id string) error
+	GetTotalSales(ctx context.Context) (models.Total, error)
+	GetPopularMenuItems(ctx context.Context, query PopularItemsQuery) ([]models.PopularItem, error)
+	GetIngredientConsumption(ctx context.Context, from, to time.Time) ([]models.IngredientConsumption, error)
+	GetInventoryForecast(ctx context.Context, days int) ([]models.InventoryForecast, error)
+	GetStations(ctx context.Context) ([]models.Station, error)
+	GetStationQueue(ctx context.Context, station string) ([]models.StationLine, error)
+	BumpOrderLine(ctx context.Context, id string, line int, version int) (models.Order, error)
+	SubscribeOrderEvents(ctx context.Context, lastEventID string) *OrderSubscription
+}
+
+const (
+	SortByQuantity = "quantity"
+	SortByRevenue  = "revenue"
+)
+
+// PopularItemsQuery narrows the popular-items report. Zero From/To leave the
+// period open on that side; To is exclusive. A zero Limit returns every item.
+type PopularItemsQuery struct {
+	Limit  int
+	SortBy string
+	From   time.Time
+	To     time.Time
 }
 
 type OrderServ struct {
-	orderRepo repository.OrderRepository
-	menuRepo  repository.MenuRepository
-	invRepo   repository.InventoryRepository
+	orderRepo    repository.OrderRepository
+	menuRepo     repository.MenuRepository
+	invRepo      repository.InventoryRepository
+	customerRepo repository.CustomerRepository
+	events       *OrderEvents
+	alerts       StockAlerter
 }
 
-func NewOrderService(or repository.OrderRepository, mr repository.MenuRepository, ir repository.InventoryRepository) *OrderServ {
-	return &OrderServ{orderRepo: or, menuRepo: mr, invRepo: ir}
+func NewOrderService(or repository.OrderRepository, mr repository.MenuRepository, ir repository.InventoryRepository, cr repository.CustomerRepository, events *OrderEvents, alerts StockAlerter) *OrderServ {
+	return &OrderServ{orderRepo: or, menuRepo: mr, invRepo: ir, customerRepo: cr, events: events, alerts: alerts}
 }
 
-func (s *OrderServ) CreateOrder(order models.Order) ([]string, error) {
-	slog.Info("CreateOrder", slog.String("order_id", order.ID), slog.String("customer", order.CustomerName))
-	validateConflicts, err := validateOrder(s, order)
-	if err != nil {
-		slog.Error("validateOrder", slog.Any("error", err))
-		return validateConflicts, err
-	}
-	if len(validateConflicts) != 0 {
-		slog.Warn("validation conflicts", slog.String("order_id", order.ID), slog.Any("conflicts", validateConflicts))
-		return validateConflicts, nil
+func (s *OrderServ) CreateOrder(ctx context.Context, order models.Order) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("CreateOrder", slog.String("order_id", order.ID), slog.String("customer", order.CustomerName), slog.String("customer_id", order.CustomerID))
+	if err := linkCustomer(ctx, s, &order); err != nil {
+		logger.Warn("linkCustomer", slog.String("order_id", order.ID), slog.Any("error", err))
+		recordRejection(err)
+		return err
 	}
-	for _, product := range order.Items {
-		if product.Quantity <= 0 {
-			slog.Error("invalid quantity", slog.String("product", product.ProductID), slog.Int("quantity", product.Quantity))
-			return nil, fmt.Errorf("invalid quantity %d for product: %s", product.Quantity, product.ProductID)
-		}
+	if err := validateOrder(ctx, s, order); err != nil {
+		logger.Warn("validateOrder", slog.String("order_id", order.ID), slog.Any("error", err))
+		recordRejection(err)
+		return err
 	}
-	requiredIngredients, err := countRequired(s, order)
+	requiredIngredients, err := countRequired(ctx, s, order)
 	if err != nil {
-		slog.Error("countRequired", slog.Any("error", err))
-		return nil, err
+		logger.Warn("countRequired", slog.Any("error", err))
+		recordRejection(err)
+		return err
 	}
-	conflicts, err := compareIngredients(s, order, requiredIngredients)
-	if err != nil {
-		slog.Error("compareIngredients", slog.Any("error", err))
-		return conflicts, err
+	if err := checkOrderable(ctx, s, order.Items, nil); err != nil {
+		logger.Warn("checkOrderable", slog.String("order_id", order.ID), slog.Any("error", err))
+		recordRejection(err)
+		return err
 	}
-	if len(conflicts) != 0 {
-		slog.Warn("ingredient conflicts", slog.String("order_id", order.ID), slog.Any("conflicts", conflicts))
-		return conflicts, nil
+	if err := priceLines(ctx, s, order.Items, nil); err != nil {
+		logger.Error("priceLines", slog.String("order_id", order.ID), slog.Any("error", err))
+		return err
 	}
-	if err := orderResult(s, requiredIngredients); err != nil {
-		slog.Error("orderResult", slog.Any("error", err))
-		return nil, err
+	if err := compareIngredients(ctx, s, requiredIngredients, nil); err != nil {
+		logger.Warn("compareIngredients", slog.String("order_id", order.ID), slog.Any("error", err))
+		recordRejection(err)
+		return err
+	}
+	// Once stock is reserved the order has to be written as well, so the
+	// remaining steps no longer observe cancellation.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ctx = context.WithoutCancel(ctx)
+	reservation := stockDelta(nil, requiredIngredients)
+	if err := s.adjustStock(ctx, reservation); err != nil {
+		logger.Warn("adjustStock", slog.String("order_id", order.ID), slog.Any("error", err))
+		recordRejection(err)
+		return err
 	}
 	if order.Status == "" {
 		order.Status = "open"
 	}
+	settleStatus(&order)
 	if order.CreatedAt == "" {
 		order.CreatedAt = time.Now().UTC().Format(time.RFC3339)
 	}
-	if err := s.orderRepo.Add(order); err != nil {
-		slog.Error("Add order", slog.Any("error", err))
-		return nil, err
+	if err := s.orderRepo.Add(ctx, order); err != nil {
+		logger.Error("Add order", slog.Any("error", err))
+		s.undoStock(ctx, reservation)
+		return err
 	}
-	slog.Info("Order created", slog.String("order_id", order.ID))
-	return nil, nil
+	metrics.OrdersCreated.Inc()
+	logger.Info("Order created", slog.String("order_id", order.ID))
+	s.publishChange(ctx, "", order.ID)
+	return nil
 }
 
-func (s *OrderServ) GetOrders() ([]models.Order, error) {
-	slog.Info("GetOrders")
-	orders, err := s.orderRepo.FindAll()
+func (s *OrderServ) GetOrders(ctx context.Context) ([]models.Order, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("GetOrders")
+	orders, err := s.orderRepo.FindAll(ctx)
 	if err != nil {
-		slog.Error("FindAll", slog.Any("error", err))
+		logger.Error("FindAll", slog.Any("error", err))
 		return nil, err
 	}
-	slog.Info("GetOrders result", slog.Int("count", len(orders)))
+	logger.Info("GetOrders result", slog.Int("count", len(orders)))
 	return orders, nil
 }
 
-func (s *OrderServ) GetOrderById(id string) (models.Order, error) {
-	slog.Info("GetOrderById", slog.String("order_id", id))
-	order, err := s.orderRepo.FindByID(id)
+func (s *OrderServ) ListOrders(ctx context.Context, query models.OrderQuery) ([]models.Order, int, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("ListOrders",
+		slog.String("status", query.Status),
+		slog.String("sort", query.Sort),
+		slog.Int("offset", query.Offset),
+		slog.Int("limit", query.Limit),
+	)
+	orders, total, err := s.orderRepo.FindPage(ctx, query)
 	if err != nil {
-		slog.Error("FindByID", slog.String("order_id", id), slog.Any("error", err))
+		logger.Error("FindPage", slog.Any("error", err))
+		return nil, 0, err
+	}
+	logger.Info("ListOrders result", slog.Int("count", len(orders)), slog.Int("total", total))
+	return orders, total, nil
+}
+
+func (s *OrderServ) GetOrderById(ctx context.Context, id string) (models.Order, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("GetOrderById", slog.String("order_id", id))
+	order, err := s.orderRepo.FindByID(ctx, id)
+	if err != nil {
+		logger.Error("FindByID", slog.String("order_id", id), slog.Any("error", err))
 		return models.Order{}, err
 	}
 	return *order, nil
 }
 
-func (s *OrderServ) UpdateOrder(id string, updatedOrder models.Order) ([]string, error) {
-	order, err := s.orderRepo.FindByID(id)
+func (s *OrderServ) UpdateOrder(ctx context.Context, id string, updatedOrder models.Order) error {
+	logger := logging.FromContext(ctx)
+	order, err := s.orderRepo.FindByID(ctx, id)
 	if err != nil {
-		slog.Error("FindByID", slog.String("order_id", id), slog.Any("error", err))
-		return nil, err
+		logger.Error("FindByID", slog.String("order_id", id), slog.Any("error", err))
+		return err
+	}
+	if updatedOrder.Version != 0 && updatedOrder.Version != order.Version {
+		logger.Warn("version mismatch", slog.String("order_id", id), slog.Int("expected", updatedOrder.Version), slog.Int("actual", order.Version))
+		return models.ErrVersionMismatch
+	}
+	if updatedOrder.ID == "" {
+		updatedOrder.ID = id
 	}
-
 	if order.ID != updatedOrder.ID {
-		_, err = s.orderRepo.FindByID(updatedOrder.ID)
-		if err == nil {
-			return nil, fmt.Errorf("order with this id already exists")
+		if _, err := s.orderRepo.FindByID(ctx, updatedOrder.ID); err == nil {
+			return &repository.ConflictError{Entity: "order", Field: "order_id", Value: updatedOrder.ID}
 		}
 	}
 
-	slog.Info("UpdateOrder", slog.String("order_id", id))
-	for _, product := range updatedOrder.Items {
-		if product.Quantity <= 0 {
-			slog.Error("invalid quantity", slog.String("product", product.ProductID), slog.Int("quantity", product.Quantity))
-			return nil, fmt.Errorf("invalid quantity %d for product: %s", product.Quantity, product.ProductID)
-		}
+	logger.Info("UpdateOrder", slog.String("order_id", id))
+	if err := linkCustomer(ctx, s, &updatedOrder); err != nil {
+		logger.Warn("linkCustomer", slog.String("order_id", id), slog.Any("error", err))
+		recordRejection(err)
+		return err
+	}
+	verr := &ValidationError{}
+	validateItems(verr, updatedOrder.Items)
+	if err := verr.OrNil(); err != nil {
+		logger.Warn("invalid items", slog.String("order_id", id), slog.Any("error", err))
+		recordRejection(err)
+		return err
 	}
 
-	requiredIngredientsPrev, err := countRequired(s, *order)
+	requiredIngredientsNew, err := countRequired(ctx, s, updatedOrder)
 	if err != nil {
-		slog.Error("countRequired prev", slog.Any("error", err))
-		return nil, err
+		logger.Warn("countRequired new", slog.Any("error", err))
+		recordRejection(err)
+		return err
 	}
-	if err := returnItems(s, requiredIngredientsPrev); err != nil {
-		slog.Error("returnItems", slog.Any("error", err))
-		return nil, err
+	if err := checkOrderable(ctx, s, updatedOrder.Items, order.Items); err != nil {
+		logger.Warn("checkOrderable", slog.String("order_id", id), slog.Any("error", err))
+		recordRejection(err)
+		return err
 	}
-	requiredIngredientsNew, err := countRequired(s, updatedOrder)
-	if err != nil {
-		slog.Error("countRequired new", slog.Any("error", err))
-		return nil, err
+	if err := priceLines(ctx, s, updatedOrder.Items, order.Items); err != nil {
+		logger.Error("priceLines", slog.String("order_id", id), slog.Any("error", err))
+		return err
 	}
-	conflicts, err := compareIngredients(s, updatedOrder, requiredIngredientsNew)
+	requiredIngredientsPrev, err := countRequired(ctx, s, *order)
 	if err != nil {
-		slog.Error("compareIngredients", slog.Any("error", err))
-		_ = orderResult(s, requiredIngredientsPrev)
-		return conflicts, err
-	}
-	if len(conflicts) != 0 {
-		slog.Warn("update conflicts", slog.String("order_id", id), slog.Any("conflicts", conflicts))
-		_ = orderResult(s, requiredIngredientsPrev)
-		return conflicts, nil
-	} else {
-		if err := orderResult(s, requiredIngredientsNew); err != nil {
-			slog.Error("orderResult", slog.Any("error", err))
-			return nil, err
-		}
+		logger.Error("countRequired prev", slog.Any("error", err))
+		return err
 	}
-	if updatedOrder.ID == "" {
-		updatedOrder.ID = id
+	if err := compareIngredients(ctx, s, requiredIngredientsNew, requiredIngredientsPrev); err != nil {
+		logger.Warn("compareIngredients", slog.String("order_id", id), slog.Any("error", err))
+		recordRejection(err)
+		return err
+	}
+	// The previous reservation is swapped for the new one in a single
+	// inventory write, which the order write has to follow; past this
+	// point cancellation would leave stock inconsistent.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ctx = context.WithoutCancel(ctx)
+	deltas := stockDelta(requiredIngredientsPrev, requiredIngredientsNew)
+	if err := s.adjustStock(ctx, deltas); err != nil {
+		logger.Warn("adjustStock", slog.String("order_id", id), slog.Any("error", err))
+		recordRejection(err)
+		return err
 	}
 	if updatedOrder.CustomerName == "" {
 		updatedOrder.CustomerName = order.CustomerName
@@ -166,201 +245,706 @@ func (s *OrderServ) UpdateOrder(id string, updatedOrder models.Order) ([]string,
 	if updatedOrder.Status == "" {
 		updatedOrder.Status = order.Status
 	}
-	if len(updatedOrder.Items) == 0 {
-		slog.Error("empty items", slog.String("order_id", id))
-		return nil, fmt.Errorf("items cannot be empty")
+	settleStatus(&updatedOrder)
+	if err := s.orderRepo.Update(ctx, id, updatedOrder); err != nil {
+		logger.Error("Update order", slog.Any("error", err))
+		s.undoStock(ctx, deltas)
+		return err
 	}
-	if err := s.orderRepo.Update(id, updatedOrder); err != nil {
-		slog.Error("Update order", slog.Any("error", err))
-		return nil, err
+	logger.Info("Order updated", slog.String("order_id", id))
+	s.publishChange(ctx, order.Status, updatedOrder.ID)
+	return nil
+}
+
+// recordRejection counts an order refused by validation or stock checks,
+// attributing stock refusals to each short ingredient.
+func recordRejection(err error) {
+	var serr *InsufficientStockError
+	switch {
+	case errors.As(err, &serr):
+		metrics.OrdersRejected.Inc("insufficient_stock")
+		for _, shortage := range serr.Shortages {
+			metrics.StockConflicts.Inc(shortage.IngredientID)
+		}
+	case errors.Is(err, ErrValidation):
+		metrics.OrdersRejected.Inc("validation")
+	case errors.Is(err, ErrConflict):
+		metrics.OrdersRejected.Inc("conflict")
 	}
-	slog.Info("Order updated", slog.String("order_id", id))
-	return nil, nil
 }
 
-func returnItems(s *OrderServ, requiredIngredients map[string]float64) error {
-	invItems, err := s.invRepo.FindAll()
+// linkCustomer checks that the customer an order references exists and
+// fills in the order's customer name from it when the order has none. Orders
+// without a customer_id are walk-ins and pass unchanged.
+func linkCustomer(ctx context.Context, s *OrderServ, order *models.Order) error {
+	if order.CustomerID == "" {
+		return nil
+	}
+	customer, err := s.customerRepo.FindByID(ctx, order.CustomerID)
+	if errors.Is(err, ErrNotFound) {
+		return NewValidationError("customer_id", err.Error())
+	}
 	if err != nil {
-		slog.Error("FindAll inventory", slog.Any("error", err))
 		return err
 	}
-	for key, val := range requiredIngredients {
-		for i := range invItems {
-			if invItems[i].IngredientID == key {
-				invItems[i].Quantity += val
-				if err := s.invRepo.Update(key, invItems[i]); err != nil {
-					slog.Error("Update inventory", slog.String("ingredient_id", key), slog.Any("error", err))
-					return nil
-				}
-			}
-		}
+	if order.CustomerName == "" {
+		order.CustomerName = customer.Name
 	}
 	return nil
 }
 
-func validateOrder(s *OrderServ, order models.Order) ([]string, error) {
-	var validateConflicts []string
-	_, err := s.orderRepo.FindByID(order.ID)
-	if err == nil {
-		validateConflicts = append(validateConflicts, models.ErrAlreadyExists.Error())
-	}
+func validateOrder(ctx context.Context, s *OrderServ, order models.Order) error {
+	verr := &ValidationError{}
 	if order.ID == "" {
-		validateConflicts = append(validateConflicts, "order id is empty")
+		verr.Add("order_id", "order id is empty")
 	}
 	if order.CustomerName == "" {
-		validateConflicts = append(validateConflicts, "customer name is empty")
+		verr.Add("customer_name", "customer name is empty; give a customer_name or a customer_id")
+	}
+	validateItems(verr, order.Items)
+	if err := verr.OrNil(); err != nil {
+		return err
+	}
+	if _, err := s.orderRepo.FindByID(ctx, order.ID); err == nil {
+		return &repository.ConflictError{Entity: "order", Field: "order_id", Value: order.ID}
 	}
-	if len(order.Items) == 0 {
-		validateConflicts = append(validateConflicts, "order items is empty")
+	return nil
+}
+
+// validateItems checks the order lines and marks those without a status
+// pending.
+func validateItems(verr *ValidationError, items []models.OrderItem) {
+	prepareLines(verr, items)
+	if len(items) == 0 {
+		verr.Add("items", "order items is empty")
+	}
+	for i, product := range items {
+		if product.Quantity <= 0 {
+			verr.Add(fmt.Sprintf("items[%d].quantity", i),
+				fmt.Sprintf("invalid quantity %d for product: %s", product.Quantity, product.ProductID))
+		}
 	}
-	return validateConflicts, nil
 }
 
-func countRequired(s *OrderServ, order models.Order) (map[string]float64, error) {
+// countRequired totals the ingredients the order's recipes consume. Items
+// naming unknown products or ingredients are reported as a ValidationError.
+func countRequired(ctx context.Context, s *OrderServ, order models.Order) (map[string]float64, error) {
 	requiredIngredients := make(map[string]float64)
-	for _, menuItem := range order.Items {
-		if menuItem.Quantity < 0 {
-			return nil, fmt.Errorf("menu item %s has negative value", menuItem.ProductID)
+	verr := &ValidationError{}
+	for i, menuItem := range order.Items {
+		item, err := s.menuRepo.FindByID(ctx, menuItem.ProductID)
+		if errors.Is(err, ErrNotFound) {
+			verr.Add(fmt.Sprintf("items[%d].product_id", i), err.Error())
+			continue
 		}
-		item, err := s.menuRepo.FindByID(menuItem.ProductID)
 		if err != nil {
 			return nil, err
 		}
-		for _, ingredient := range item.Ingredients {
-			if ingredient.Quantity < 0 {
-				requiredIngredients[ingredient.IngredientID] = -1
-			}
-			if _, err := s.invRepo.FindByID(ingredient.IngredientID); err == nil {
-				requiredIngredients[ingredient.IngredientID] += ingredient.Quantity * float64(menuItem.Quantity)
-			} else {
-				requiredIngredients[ingredient.IngredientID] = -2
-			}
+		if err := addRecipe(requiredIngredients, *item, menuItem.Quantity); err != nil {
+			verr.Add(fmt.Sprintf("items[%d].product_id", i), err.Error())
 		}
 	}
+	if err := verr.OrNil(); err != nil {
+		return nil, err
+	}
 	return requiredIngredients, nil
 }
 
-func compareIngredients(s *OrderServ, order models.Order, requiredIngredients map[string]float64) ([]string, error) {
-	var conflicts []string
-	for key, val := range requiredIngredients {
-		if val == -1 {
-			conflicts = append(conflicts, "ingredient "+key+" has negative value")
+// addRecipe adds the ingredients of quantity portions of item to required.
+// A recipe with a negative quantity adds nothing and is an error.
+func addRecipe(required map[string]float64, item models.MenuItem, quantity int) error {
+	for _, ingredient := range item.Ingredients {
+		if ingredient.Quantity < 0 {
+			return errors.New("ingredient " + ingredient.IngredientID + " has negative value")
+		}
+	}
+	for _, ingredient := range item.Ingredients {
+		required[ingredient.IngredientID] += ingredient.Quantity * float64(quantity)
+	}
+	return nil
+}
+
+// checkOrderable rejects lines for archived products. It runs only on the
+// lines being placed, those that order more of a product than previous did:
+// an existing order keeps resolving archived products and may still drop or
+// shrink their lines.
+func checkOrderable(ctx context.Context, s *OrderServ, items, previous []models.OrderItem) error {
+	ordered := make(map[string]int, len(previous))
+	for _, line := range previous {
+		ordered[line.ProductID] += line.Quantity
+	}
+	verr := &ValidationError{}
+	for i, line := range items {
+		ordered[line.ProductID] -= line.Quantity
+		if ordered[line.ProductID] >= 0 {
+			continue
+		}
+		item, err := s.menuRepo.FindByID(ctx, line.ProductID)
+		if err != nil {
+			return err
+		}
+		if item.Archived {
+			verr.Add(fmt.Sprintf("items[%d].product_id", i), "product "+line.ProductID+" is archived")
+		}
+	}
+	return verr.OrNil()
+}
+
+// priceLines sets the unit price of every line to its product's menu price,
+// ignoring any price sent by the client. Products already on the order in
+// previous keep the price they were first ordered at.
+func priceLines(ctx context.Context, s *OrderServ, items, previous []models.OrderItem) error {
+	ordered := make(map[string]float64, len(previous))
+	for _, line := range previous {
+		if _, ok := ordered[line.ProductID]; !ok {
+			ordered[line.ProductID] = line.UnitPrice
+		}
+	}
+	for i := range items {
+		if price, ok := ordered[items[i].ProductID]; ok {
+			items[i].UnitPrice = price
 			continue
 		}
-		if val == -2 {
-			conflicts = append(conflicts, "ingredient "+key+" not found")
+		item, err := s.menuRepo.FindByID(ctx, items[i].ProductID)
+		if err != nil {
+			return err
+		}
+		items[i].UnitPrice = item.Price
+	}
+	return nil
+}
+
+// compareIngredients checks that the inventory, plus the released reservation
+// being swapped out, covers requiredIngredients. Archived ingredients are
+// refused only where more is required than is released. It gives the early,
+// detailed error; adjustStock enforces the same under the inventory lock.
+func compareIngredients(ctx context.Context, s *OrderServ, requiredIngredients, released map[string]float64) error {
+	var shortages []Shortage
+	verr := &ValidationError{}
+	for key, val := range requiredIngredients {
+		invItem, err := s.invRepo.FindByID(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			verr.Add("items", "ingredient "+key+" not found")
 			continue
 		}
-		invItem, err := s.invRepo.FindByID(key)
 		if err != nil {
-			return conflicts, err
+			return err
 		}
-		if val > invItem.Quantity {
-			conflicts = append(conflicts,
-				"Insufficient inventory for ingredient '"+key+"'. Required: "+
-					strconv.Itoa(int(val))+invItem.Unit+", Available: "+
-					strconv.Itoa(int(invItem.Quantity))+invItem.Unit)
+		if invItem.Archived && val > released[key] {
+			verr.Add("items", "ingredient "+key+" is archived")
+			continue
+		}
+		if available := invItem.Quantity + released[key]; val > available {
+			shortages = append(shortages, Shortage{
+				IngredientID: key,
+				Required:     val,
+				Available:    available,
+				Unit:         invItem.Unit,
+			})
+		}
+	}
+	if err := verr.OrNil(); err != nil {
+		return err
+	}
+	if len(shortages) != 0 {
+		sort.Slice(shortages, func(i, j int) bool {
+			return shortages[i].IngredientID < shortages[j].IngredientID
+		})
+		return &InsufficientStockError{Shortages: shortages}
+	}
+	return nil
+}
+
+// stockDelta is the inventory change that swaps the released reservation
+// for the reserved one: positive deltas return stock, negative ones take it.
+func stockDelta(released, reserved map[string]float64) map[string]float64 {
+	deltas := make(map[string]float64, len(released)+len(reserved))
+	for id, quantity := range released {
+		deltas[id] += quantity
+	}
+	for id, quantity := range reserved {
+		deltas[id] -= quantity
+	}
+	for id, delta := range deltas {
+		if delta == 0 {
+			delete(deltas, id)
 		}
 	}
-	return conflicts, nil
+	return deltas
 }
 
-func orderResult(s *OrderServ, requiredIngredients map[string]float64) error {
-	invItems, err := s.invRepo.FindAll()
+// adjustStock applies deltas to the inventory in one write, all or nothing,
+// and reports the items it took below their reorder level.
+func (s *OrderServ) adjustStock(ctx context.Context, deltas map[string]float64) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+	before, err := s.invRepo.Adjust(ctx, deltas)
+	var serr *repository.ShortageError
+	if errors.As(err, &serr) {
+		return &InsufficientStockError{Shortages: serr.Shortages}
+	}
 	if err != nil {
-		slog.Error("FindAll inventory", slog.Any("error", err))
 		return err
 	}
-	for key, val := range requiredIngredients {
-		for i := range invItems {
-			if invItems[i].IngredientID == key {
-				invItems[i].Quantity -= val
-				if err := s.invRepo.Update(key, invItems[i]); err != nil {
-					slog.Error("Update inventory", slog.String("ingredient_id", key), slog.Any("error", err))
-					return nil
-				}
-			}
-		}
+	for _, item := range before {
+		after := item
+		after.Quantity += deltas[item.IngredientID]
+		alertLowStock(ctx, s.alerts, item, after)
 	}
 	return nil
 }
 
-func (s *OrderServ) DeleteOrder(id string) error {
-	slog.Info("DeleteOrder", slog.String("order_id", id))
-	err := s.orderRepo.Delete(id)
+// undoStock reverts deltas after the order write they were made for failed.
+// If the revert fails too the inventory is off by deltas, so they are logged
+// for a manual correction.
+func (s *OrderServ) undoStock(ctx context.Context, deltas map[string]float64) {
+	undo := make(map[string]float64, len(deltas))
+	for id, delta := range deltas {
+		undo[id] = -delta
+	}
+	if err := s.adjustStock(ctx, undo); err != nil {
+		logging.FromContext(ctx).Error("Reverting stock adjustment failed", slog.Any("deltas", deltas), slog.Any("error", err))
+	}
+}
+
+func (s *OrderServ) DeleteOrder(ctx context.Context, id string, version int) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("DeleteOrder", slog.String("order_id", id), slog.Int("version", version))
+	order, err := s.orderRepo.FindByID(ctx, id)
 	if err != nil {
-		slog.Error("DeleteOrder failed", slog.String("order_id", id), slog.Any("error", err))
+		logger.Error("DeleteOrder failed", slog.String("order_id", id), slog.Any("error", err))
 		return err
 	}
+	if err := s.orderRepo.Delete(ctx, id, version); err != nil {
+		logger.Error("DeleteOrder failed", slog.String("order_id", id), slog.Any("error", err))
+		return err
+	}
+	if order.Status != "closed" {
+		ctx = context.WithoutCancel(ctx)
+		s.releaseStock(ctx, *order)
+		metrics.OrdersCancelled.Inc()
+		s.events.publish(ctx, models.OrderCancelled, *order, "")
+	}
+	logger.Info("Order deleted", slog.String("order_id", id), slog.String("status", order.Status), slog.String("actor", auth.Actor(ctx)))
+	return nil
+}
+
+// RestoreOrder puts back an order DeleteOrder cancelled, reserving the stock
+// of its pending lines again. It undoes a cascade that could not finish.
+func (s *OrderServ) RestoreOrder(ctx context.Context, order models.Order) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("RestoreOrder", slog.String("order_id", order.ID))
+	ctx = context.WithoutCancel(ctx)
+	var pending []models.OrderItem
+	for _, line := range order.Items {
+		if line.Status != models.LineDone {
+			pending = append(pending, line)
+		}
+	}
+	required, err := countRequired(ctx, s, models.Order{Items: pending})
+	if err != nil {
+		logger.Error("countRequired", slog.String("order_id", order.ID), slog.Any("error", err))
+		return err
+	}
+	reservation := stockDelta(nil, required)
+	if err := s.adjustStock(ctx, reservation); err != nil {
+		logger.Error("adjustStock", slog.String("order_id", order.ID), slog.Any("error", err))
+		return err
+	}
+	if err := s.orderRepo.Add(ctx, order); err != nil {
+		logger.Error("Add order", slog.String("order_id", order.ID), slog.Any("error", err))
+		s.undoStock(ctx, reservation)
+		return err
+	}
+	logger.Info("Order restored", slog.String("order_id", order.ID))
+	s.publishChange(ctx, "", order.ID)
 	return nil
 }
 
-func (s *OrderServ) CloseOrder(id string) error {
-	slog.Info("CloseOrder", slog.String("order_id", id))
-	err := s.orderRepo.Close(id)
+// releaseStock returns the stock reserved by the pending lines of a cancelled
+// order; lines already done were prepared and stay consumed. The order is
+// gone by then, so a failure is logged rather than returned.
+func (s *OrderServ) releaseStock(ctx context.Context, order models.Order) {
+	var pending []models.OrderItem
+	for _, line := range order.Items {
+		if line.Status != models.LineDone {
+			pending = append(pending, line)
+		}
+	}
+	required, err := countRequired(ctx, s, models.Order{Items: pending})
+	if err == nil {
+		err = s.adjustStock(ctx, stockDelta(required, nil))
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("Returning stock of cancelled order failed", slog.String("order_id", order.ID), slog.Any("error", err))
+	}
+}
+
+func (s *OrderServ) CloseOrder(ctx context.Context, id string) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("CloseOrder", slog.String("order_id", id))
+	order, err := s.orderRepo.FindByID(ctx, id)
 	if err != nil {
-		slog.Error("CloseOrder failed", slog.String("order_id", id), slog.Any("error", err))
+		logger.Error("CloseOrder failed", slog.String("order_id", id), slog.Any("error", err))
+		return err
+	}
+	// The repository refuses an order that is already closed, so the
+	// order is counted and announced once.
+	if err := s.orderRepo.Close(ctx, id); err != nil {
+		logger.Error("CloseOrder failed", slog.String("order_id", id), slog.Any("error", err))
 		return err
 	}
+	metrics.OrdersClosed.Inc()
+	s.publishChange(ctx, order.Status, id)
 	return nil
 }
 
-func (s *OrderServ) GetTotalSales() (models.Total, error) {
-	slog.Info("GetTotalSales")
-	orders, err := s.orderRepo.FindAll()
+// SubscribeOrderEvents subscribes to order events after lastEventID.
+func (s *OrderServ) SubscribeOrderEvents(ctx context.Context, lastEventID string) *OrderSubscription {
+	sub := s.events.Subscribe(lastEventID)
+	logging.FromContext(ctx).Info("SubscribeOrderEvents",
+		slog.String("last_event_id", lastEventID),
+		slog.Int("backlog", len(sub.Backlog)),
+		slog.Bool("gap", sub.Gap),
+	)
+	return sub
+}
+
+// publishChange reads back the order written under id and publishes the
+// event for the change from previousStatus; an empty previousStatus means
+// the order was created. The change is already stored, so a failed read is
+// only logged.
+func (s *OrderServ) publishChange(ctx context.Context, previousStatus, id string) {
+	order, err := s.orderRepo.FindByID(ctx, id)
+	if err != nil {
+		logging.FromContext(ctx).Error("Read order for event", slog.String("order_id", id), slog.Any("error", err))
+		return
+	}
+	switch {
+	case previousStatus == "":
+		s.events.publish(ctx, models.OrderCreated, *order, "")
+	case order.Status == previousStatus:
+		s.events.publish(ctx, models.OrderUpdated, *order, "")
+	case order.Status == "closed":
+		s.events.publish(ctx, models.OrderClosed, *order, previousStatus)
+	default:
+		s.events.publish(ctx, models.OrderStatusChanged, *order, previousStatus)
+	}
+}
+
+func (s *OrderServ) GetTotalSales(ctx context.Context) (models.Total, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("GetTotalSales")
+	orders, err := s.orderRepo.FindAll(ctx)
+	if err != nil {
+		logger.Error("FindAll orders", slog.Any("error", err))
+		return models.Total{}, err
+	}
+	products, err := s.menuByID(ctx)
 	if err != nil {
-		slog.Error("FindAll orders", slog.Any("error", err))
 		return models.Total{}, err
 	}
 	totalSales := 0.0
 	for _, order := range orders {
-		for _, menuItem := range order.Items {
-			if order.Status == "closed" {
-				item, err := s.menuRepo.FindByID(menuItem.ProductID)
-				if err != nil {
-					slog.Error("FindByID menu", slog.String("product_id", menuItem.ProductID), slog.Any("error", err))
-					return models.Total{}, err
-				}
-				totalSales += item.Price * float64(menuItem.Quantity)
-			}
+		if err := ctx.Err(); err != nil {
+			return models.Total{}, err
+		}
+		if order.Status != "closed" {
+			continue
+		}
+		for _, line := range order.Items {
+			totalSales += linePrice(ctx, order.ID, line, products) * float64(line.Quantity)
 		}
 	}
-	slog.Info("TotalSales", slog.Float64("total", totalSales))
+	logger.Info("TotalSales", slog.Float64("total", totalSales))
 	return models.Total{TotalSales: totalSales}, nil
 }
 
-func (s *OrderServ) GetPopularMenuItems() ([]string, error) {
-	slog.Info("GetPopularMenuItems")
-	orders, err := s.orderRepo.FindAll()
+// menuByID loads the menu, archived items included, keyed by product ID.
+func (s *OrderServ) menuByID(ctx context.Context) (map[string]models.MenuItem, error) {
+	menu, err := s.menuRepo.FindAll(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error("FindAll menu", slog.Any("error", err))
+		return nil, err
+	}
+	products := make(map[string]models.MenuItem, len(menu))
+	for _, item := range menu {
+		products[item.ID] = item
+	}
+	return products, nil
+}
+
+// linePrice is the price line was placed at. Lines stored without one fall
+// back to the current menu price, and count as 0 once the product is gone.
+func linePrice(ctx context.Context, orderID string, line models.OrderItem, products map[string]models.MenuItem) float64 {
+	if line.UnitPrice > 0 {
+		return line.UnitPrice
+	}
+	item, ok := products[line.ProductID]
+	if !ok {
+		logging.FromContext(ctx).Warn("line without price for a missing product",
+			slog.String("order_id", orderID), slog.String("product_id", line.ProductID))
+		return 0
+	}
+	return item.Price
+}
+
+func (s *OrderServ) GetPopularMenuItems(ctx context.Context, query PopularItemsQuery) ([]models.PopularItem, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("GetPopularMenuItems",
+		slog.Int("limit", query.Limit),
+		slog.String("sort_by", query.SortBy),
+		slog.Time("from", query.From),
+		slog.Time("to", query.To),
+	)
+	if query.SortBy == "" {
+		query.SortBy = SortByQuantity
+	}
+	if query.SortBy != SortByQuantity && query.SortBy != SortByRevenue {
+		return nil, NewValidationError("sort_by", fmt.Sprintf("must be %s or %s", SortByQuantity, SortByRevenue))
+	}
+	if query.Limit < 0 {
+		return nil, NewValidationError("limit", "must be non-negative")
+	}
+	orders, err := s.orderRepo.FindAll(ctx)
+	if err != nil {
+		logger.Error("FindAll orders", slog.Any("error", err))
+		return nil, err
+	}
+
+	products, err := s.menuByID(ctx)
 	if err != nil {
-		slog.Error("FindAll orders", slog.Any("error", err))
 		return nil, err
 	}
-	menuItems := make(map[string]int)
+
+	popular := make(map[string]*models.PopularItem)
 	for _, order := range orders {
-		if order.Status == "closed" {
-			for _, menuItem := range order.Items {
-				menuItems[menuItem.ProductID] += menuItem.Quantity
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if order.Status != "closed" || !inPeriod(order.CreatedAt, query.From, query.To) {
+			continue
+		}
+		for _, line := range order.Items {
+			item, ok := popular[line.ProductID]
+			if !ok {
+				item = &models.PopularItem{ProductID: line.ProductID, Name: products[line.ProductID].Name}
+				popular[line.ProductID] = item
 			}
+			item.Quantity += line.Quantity
+			item.Revenue += linePrice(ctx, order.ID, line, products) * float64(line.Quantity)
 		}
 	}
-	keys := make([]string, 0, len(menuItems))
-	for k := range menuItems {
-		keys = append(keys, k)
+
+	popularMenuItems := make([]models.PopularItem, 0, len(popular))
+	totalRevenue := 0.0
+	for _, item := range popular {
+		totalRevenue += item.Revenue
+		popularMenuItems = append(popularMenuItems, *item)
+	}
+	for i := range popularMenuItems {
+		if totalRevenue > 0 {
+			popularMenuItems[i].ShareOfSales = math.Round(popularMenuItems[i].Revenue/totalRevenue*10000) / 100
+		}
 	}
-	sort.Slice(keys, func(i, j int) bool {
-		return menuItems[keys[i]] > menuItems[keys[j]]
+
+	sort.Slice(popularMenuItems, func(i, j int) bool {
+		a, b := popularMenuItems[i], popularMenuItems[j]
+		if query.SortBy == SortByRevenue && a.Revenue != b.Revenue {
+			return a.Revenue > b.Revenue
+		}
+		if a.Quantity != b.Quantity {
+			return a.Quantity > b.Quantity
+		}
+		return a.ProductID < b.ProductID
 	})
-	if len(keys) < 3 {
-		err := fmt.Errorf("not enough menu items to achieve result")
-		slog.Warn("not enough popular items", slog.Any("error", err))
+	if query.Limit > 0 && len(popularMenuItems) > query.Limit {
+		popularMenuItems = popularMenuItems[:query.Limit]
+	}
+	logger.Info("PopularMenuItems", slog.Int("count", len(popularMenuItems)))
+	return popularMenuItems, nil
+}
+
+func inPeriod(createdAt string, from, to time.Time) bool {
+	if from.IsZero() && to.IsZero() {
+		return true
+	}
+	created, err := time.Parse(time.RFC3339, createdAt)
+	if err != nil {
+		slog.Warn("invalid created_at", slog.String("created_at", createdAt), slog.Any("error", err))
+		return false
+	}
+	if !from.IsZero() && created.Before(from) {
+		return false
+	}
+	if !to.IsZero() && !created.Before(to) {
+		return false
+	}
+	return true
+}
+
+func (s *OrderServ) GetIngredientConsumption(ctx context.Context, from, to time.Time) ([]models.IngredientConsumption, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("GetIngredientConsumption", slog.Time("from", from), slog.Time("to", to))
+	daily, err := s.dailyConsumption(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	invItems, err := s.invRepo.FindAll(ctx)
+	if err != nil {
+		logger.Error("FindAll inventory", slog.Any("error", err))
 		return nil, err
 	}
-	var popularMenuItems []string
-	for i := 0; i < 3; i++ {
-		row := keys[i] + ": " + strconv.Itoa(menuItems[keys[i]])
-		popularMenuItems = append(popularMenuItems, row)
+	inventory := make(map[string]models.InventoryItem, len(invItems))
+	for _, item := range invItems {
+		inventory[item.IngredientID] = item
 	}
-	slog.Info("PopularMenuItems", slog.Any("items", popularMenuItems))
-	return popularMenuItems, nil
+
+	var dates []string
+	for date := range daily {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	if len(dates) == 0 {
+		return []models.IngredientConsumption{}, nil
+	}
+	if from.IsZero() {
+		from, _ = time.Parse(time.DateOnly, dates[0])
+	}
+	if to.IsZero() {
+		last, _ := time.Parse(time.DateOnly, dates[len(dates)-1])
+		to = last.AddDate(0, 0, 1)
+	}
+	days := math.Ceil(to.Sub(from).Hours() / 24)
+	if days < 1 {
+		days = 1
+	}
+
+	totals := make(map[string]*models.IngredientConsumption)
+	for _, date := range dates {
+		for ingredientID, quantity := range daily[date] {
+			consumption, ok := totals[ingredientID]
+			if !ok {
+				inv := inventory[ingredientID]
+				consumption = &models.IngredientConsumption{IngredientID: ingredientID, Name: inv.Name, Unit: inv.Unit}
+				totals[ingredientID] = consumption
+			}
+			consumption.Quantity += quantity
+			consumption.Daily = append(consumption.Daily, models.DailyConsumption{Date: date, Quantity: quantity})
+		}
+	}
+
+	result := make([]models.IngredientConsumption, 0, len(totals))
+	for _, consumption := range totals {
+		consumption.DailyAverage = math.Round(consumption.Quantity/days*100) / 100
+		result = append(result, *consumption)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].IngredientID < result[j].IngredientID
+	})
+	logger.Info("IngredientConsumption", slog.Int("count", len(result)), slog.Float64("days", days))
+	return result, nil
+}
+
+func (s *OrderServ) GetInventoryForecast(ctx context.Context, days int) ([]models.InventoryForecast, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("GetInventoryForecast", slog.Int("days", days))
+	if days <= 0 {
+		return nil, NewValidationError("days", "must be positive")
+	}
+	now := time.Now().UTC()
+	daily, err := s.dailyConsumption(ctx, now.AddDate(0, 0, -days), now)
+	if err != nil {
+		return nil, err
+	}
+	consumed := make(map[string]float64)
+	for _, ingredients := range daily {
+		for ingredientID, quantity := range ingredients {
+			consumed[ingredientID] += quantity
+		}
+	}
+	invItems, err := s.invRepo.FindAll(ctx)
+	if err != nil {
+		logger.Error("FindAll inventory", slog.Any("error", err))
+		return nil, err
+	}
+
+	forecast := make([]models.InventoryForecast, 0, len(invItems))
+	for _, item := range invItems {
+		f := models.InventoryForecast{
+			IngredientID:     item.IngredientID,
+			Name:             item.Name,
+			Unit:             item.Unit,
+			Quantity:         item.Quantity,
+			DailyConsumption: math.Round(consumed[item.IngredientID]/float64(days)*100) / 100,
+		}
+		if rate := consumed[item.IngredientID] / float64(days); rate > 0 {
+			remaining := math.Max(item.Quantity/rate, 0)
+			remaining = math.Round(remaining*10) / 10
+			f.DaysRemaining = &remaining
+			f.DepletionDate = now.Add(time.Duration(remaining * 24 * float64(time.Hour))).Format(time.DateOnly)
+		}
+		forecast = append(forecast, f)
+	}
+	sort.SliceStable(forecast, func(i, j int) bool {
+		a, b := forecast[i].DaysRemaining, forecast[j].DaysRemaining
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return *a < *b
+	})
+	logger.Info("InventoryForecast", slog.Int("count", len(forecast)))
+	return forecast, nil
+}
+
+// dailyConsumption applies menu recipes to the closed orders created within
+// [from, to) and returns the ingredient usage grouped by UTC day.
+func (s *OrderServ) dailyConsumption(ctx context.Context, from, to time.Time) (map[string]map[string]float64, error) {
+	logger := logging.FromContext(ctx)
+	orders, err := s.orderRepo.FindAll(ctx)
+	if err != nil {
+		logger.Error("FindAll orders", slog.Any("error", err))
+		return nil, err
+	}
+
+	products, err := s.menuByID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	daily := make(map[string]map[string]float64)
+	for _, order := range orders {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if order.Status != "closed" || !inPeriod(order.CreatedAt, from, to) {
+			continue
+		}
+		created, err := time.Parse(time.RFC3339, order.CreatedAt)
+		if err != nil {
+			logger.Warn("invalid created_at", slog.String("order_id", order.ID), slog.Any("error", err))
+			continue
+		}
+		date := created.UTC().Format(time.DateOnly)
+		if daily[date] == nil {
+			daily[date] = make(map[string]float64)
+		}
+		// Lines are counted the way their stock was reserved. A line whose
+		// product is gone or has a broken recipe cannot be costed and is
+		// left out; the rest of the order still counts.
+		for i, line := range order.Items {
+			item, ok := products[line.ProductID]
+			if !ok {
+				logger.Warn("order line not counted", slog.String("order_id", order.ID), slog.Int("line", i),
+					slog.String("product_id", line.ProductID), slog.String("reason", "product not found"))
+				continue
+			}
+			if err := addRecipe(daily[date], item, line.Quantity); err != nil {
+				logger.Warn("order line not counted", slog.String("order_id", order.ID), slog.Int("line", i),
+					slog.String("product_id", line.ProductID), slog.String("reason", err.Error()))
+			}
+		}
+	}
+	return daily, nil
 }
@@ -0,0 +1,427 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"hot-coffee/internal/repository"
+	"hot-coffee/models"
+)
+
+// testStore is a data directory seeded with a small menu and inventory, and
+// the repositories over it.
+type testStore struct {
+	dir       string
+	orders    repository.OrderRepository
+	menu      repository.MenuRepository
+	inventory repository.InventoryRepository
+	customers repository.CustomerRepository
+}
+
+func newTestStore(t *testing.T) *testStore {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestFile(t, dir, "inventory.json", []models.InventoryItem{
+		{IngredientID: "espresso_shot", Name: "Espresso Shot", Quantity: 10, Unit: "shots"},
+		{IngredientID: "milk", Name: "Milk", Quantity: 1000, Unit: "ml"},
+		{IngredientID: "sugar", Name: "Sugar", Quantity: 100, Unit: "g"},
+	})
+	writeTestFile(t, dir, "menu_items.json", []models.MenuItem{
+		{ID: "espresso", Name: "Espresso", Description: "Single shot", Price: 2,
+			Ingredients: []models.MenuItemIngredient{{IngredientID: "espresso_shot", Quantity: 1}}, Station: "espresso_bar"},
+		{ID: "latte", Name: "Caffe Latte", Description: "Espresso with milk", Price: 3.5,
+			Ingredients: []models.MenuItemIngredient{{IngredientID: "espresso_shot", Quantity: 1}, {IngredientID: "milk", Quantity: 200}}, Station: "espresso_bar"},
+		{ID: "milkshake", Name: "Milkshake", Description: "Sweet milk", Price: 4,
+			Ingredients: []models.MenuItemIngredient{{IngredientID: "milk", Quantity: 300}, {IngredientID: "sugar", Quantity: 20}}, Station: "cold_drinks"},
+	})
+	writeTestFile(t, dir, "orders.json", []models.Order{})
+	return &testStore{
+		dir:       dir,
+		orders:    repository.NewJSONOrderRepo(dir),
+		menu:      repository.NewJSONMenuRepo(dir),
+		inventory: repository.NewJSONInventoryRepo(dir),
+		customers: repository.NewJSONCustomerRepo(dir),
+	}
+}
+
+func writeTestFile(t *testing.T, dir, name string, v any) {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (st *testStore) orderService(orders repository.OrderRepository) *OrderServ {
+	if orders == nil {
+		orders = st.orders
+	}
+	return NewOrderService(orders, st.menu, st.inventory, st.customers, NewOrderEvents(100), nopAlerter{})
+}
+
+// stock returns the quantity of every inventory item by ID.
+func (st *testStore) stock(t *testing.T) map[string]float64 {
+	t.Helper()
+	items, err := st.inventory.FindAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stock := make(map[string]float64, len(items))
+	for _, item := range items {
+		stock[item.IngredientID] = item.Quantity
+	}
+	return stock
+}
+
+func assertStock(t *testing.T, got, want map[string]float64) {
+	t.Helper()
+	for id, quantity := range want {
+		if got[id] != quantity {
+			t.Errorf("stock of %s = %v, want %v", id, got[id], quantity)
+		}
+	}
+}
+
+type nopAlerter struct{}
+
+func (nopAlerter) LowStock(context.Context, models.InventoryItem) {}
+
+// failingOrders fails order writes after the stock has been reserved.
+type failingOrders struct {
+	repository.OrderRepository
+}
+
+var errWriteFailed = errors.New("disk full")
+
+func (failingOrders) Add(context.Context, models.Order) error { return errWriteFailed }
+
+func (failingOrders) Update(context.Context, string, models.Order) error { return errWriteFailed }
+
+func order(id string, lines ...models.OrderItem) models.Order {
+	return models.Order{ID: id, CustomerName: "Ana", Items: lines}
+}
+
+func line(productID string, quantity int) models.OrderItem {
+	return models.OrderItem{ProductID: productID, Quantity: quantity}
+}
+
+func TestCreateOrderReservesStock(t *testing.T) {
+	tests := []struct {
+		name      string
+		order     models.Order
+		failWrite bool
+		wantErr   error
+		wantStock map[string]float64
+	}{
+		{
+			name:      "reserves every ingredient",
+			order:     order("o1", line("latte", 2), line("espresso", 1)),
+			wantStock: map[string]float64{"espresso_shot": 7, "milk": 600},
+		},
+		{
+			name:      "shortage reserves nothing",
+			order:     order("o1", line("espresso", 2), line("latte", 6)),
+			wantErr:   ErrInsufficientStock,
+			wantStock: map[string]float64{"espresso_shot": 10, "milk": 1000},
+		},
+		{
+			name:      "unknown product reserves nothing",
+			order:     order("o1", line("latte", 1), line("muffin", 1)),
+			wantErr:   ErrValidation,
+			wantStock: map[string]float64{"espresso_shot": 10, "milk": 1000},
+		},
+		{
+			name:      "failed order write returns the reservation",
+			order:     order("o1", line("latte", 2)),
+			failWrite: true,
+			wantErr:   errWriteFailed,
+			wantStock: map[string]float64{"espresso_shot": 10, "milk": 1000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestStore(t)
+			var orders repository.OrderRepository
+			if tt.failWrite {
+				orders = failingOrders{st.orders}
+			}
+			err := st.orderService(orders).CreateOrder(context.Background(), tt.order)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateOrder() error = %v, want %v", err, tt.wantErr)
+			}
+			assertStock(t, st.stock(t), tt.wantStock)
+		})
+	}
+}
+
+func TestUpdateOrderSwapsReservation(t *testing.T) {
+	tests := []struct {
+		name      string
+		items     []models.OrderItem
+		failWrite bool
+		wantErr   error
+		wantStock map[string]float64
+	}{
+		{
+			name:      "releases old lines and reserves new ones",
+			items:     []models.OrderItem{line("espresso", 3)},
+			wantStock: map[string]float64{"espresso_shot": 7, "milk": 1000},
+		},
+		{
+			name:      "released stock counts towards the new lines",
+			items:     []models.OrderItem{line("latte", 5)},
+			wantStock: map[string]float64{"espresso_shot": 5, "milk": 0},
+		},
+		{
+			name:      "shortage keeps the old reservation",
+			items:     []models.OrderItem{line("latte", 6)},
+			wantErr:   ErrInsufficientStock,
+			wantStock: map[string]float64{"espresso_shot": 9, "milk": 800},
+		},
+		{
+			name:      "failed order write keeps the old reservation",
+			items:     []models.OrderItem{line("espresso", 3)},
+			failWrite: true,
+			wantErr:   errWriteFailed,
+			wantStock: map[string]float64{"espresso_shot": 9, "milk": 800},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestStore(t)
+			ctx := context.Background()
+			if err := st.orderService(nil).CreateOrder(ctx, order("o1", line("latte", 1))); err != nil {
+				t.Fatal(err)
+			}
+			var orders repository.OrderRepository
+			if tt.failWrite {
+				orders = failingOrders{st.orders}
+			}
+			err := st.orderService(orders).UpdateOrder(ctx, "o1", order("o1", tt.items...))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateOrder() error = %v, want %v", err, tt.wantErr)
+			}
+			assertStock(t, st.stock(t), tt.wantStock)
+		})
+	}
+}
+
+func TestUpdateOrderWithArchivedItems(t *testing.T) {
+	tests := []struct {
+		name      string
+		archive   func(ctx context.Context, st *testStore) error
+		items     []models.OrderItem
+		wantErr   error
+		wantStock map[string]float64
+	}{
+		{
+			name:      "archived product line can be removed",
+			archive:   archiveProduct("latte"),
+			items:     []models.OrderItem{line("espresso", 1)},
+			wantStock: map[string]float64{"espresso_shot": 9, "milk": 1000},
+		},
+		{
+			name:      "archived product line can shrink",
+			archive:   archiveProduct("latte"),
+			items:     []models.OrderItem{line("latte", 1), line("espresso", 1)},
+			wantStock: map[string]float64{"espresso_shot": 8, "milk": 800},
+		},
+		{
+			name:      "archived product line cannot grow",
+			archive:   archiveProduct("latte"),
+			items:     []models.OrderItem{line("latte", 3), line("espresso", 1)},
+			wantErr:   ErrValidation,
+			wantStock: map[string]float64{"espresso_shot": 7, "milk": 600},
+		},
+		{
+			name:      "archived ingredient can be released",
+			archive:   archiveIngredient("milk"),
+			items:     []models.OrderItem{line("latte", 1), line("espresso", 2)},
+			wantStock: map[string]float64{"espresso_shot": 7, "milk": 800},
+		},
+		{
+			name:      "archived ingredient cannot be taken",
+			archive:   archiveIngredient("milk"),
+			items:     []models.OrderItem{line("latte", 2), line("milkshake", 1)},
+			wantErr:   ErrValidation,
+			wantStock: map[string]float64{"espresso_shot": 7, "milk": 600},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestStore(t)
+			ctx := context.Background()
+			svc := st.orderService(nil)
+			if err := svc.CreateOrder(ctx, order("o1", line("latte", 2), line("espresso", 1))); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.archive(ctx, st); err != nil {
+				t.Fatal(err)
+			}
+			err := svc.UpdateOrder(ctx, "o1", order("o1", tt.items...))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateOrder() error = %v, want %v", err, tt.wantErr)
+			}
+			assertStock(t, st.stock(t), tt.wantStock)
+		})
+	}
+}
+
+func archiveProduct(id string) func(context.Context, *testStore) error {
+	return func(ctx context.Context, st *testStore) error {
+		return NewMenuService(st.menu, st.inventory, st.orders, nil).ArchiveMenuItem(ctx, id, 0)
+	}
+}
+
+func archiveIngredient(id string) func(context.Context, *testStore) error {
+	return func(ctx context.Context, st *testStore) error {
+		return NewInventoryService(st.inventory, st.menu, nopAlerter{}).ArchiveInventoryItem(ctx, id, 0)
+	}
+}
+
+func TestConcurrentOrdersNeverOversell(t *testing.T) {
+	st := newTestStore(t)
+	svc := st.orderService(nil)
+	const attempts = 12
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		accepted int
+	)
+	for i := range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := svc.CreateOrder(context.Background(), order(fmt.Sprintf("o%d", i), line("espresso", 2)))
+			switch {
+			case err == nil:
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			case !errors.Is(err, ErrInsufficientStock):
+				t.Errorf("CreateOrder() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 5 {
+		t.Errorf("accepted %d orders, want 5", accepted)
+	}
+	assertStock(t, st.stock(t), map[string]float64{"espresso_shot": 0})
+}
+
+func TestCloseOrderOnlyOnce(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	svc := st.orderService(nil)
+	if err := svc.CreateOrder(ctx, order("o1", line("espresso", 1))); err != nil {
+		t.Fatal(err)
+	}
+	var closed []models.OrderEvent
+	svc.events.Listen(func(_ context.Context, event models.OrderEvent) {
+		if event.Type == models.OrderClosed {
+			closed = append(closed, event)
+		}
+	})
+
+	if err := svc.CloseOrder(ctx, "o1"); err != nil {
+		t.Fatalf("first CloseOrder() error = %v", err)
+	}
+	err := svc.CloseOrder(ctx, "o1")
+	var serr *repository.StateConflictError
+	if !errors.Is(err, ErrConflict) || !errors.As(err, &serr) {
+		t.Fatalf("second CloseOrder() error = %v, want a StateConflictError", err)
+	}
+	if len(closed) != 1 {
+		t.Errorf("published %d order.closed events, want 1", len(closed))
+	}
+	stored, err := st.orders.FindByID(ctx, "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Version != 2 {
+		t.Errorf("version = %d, want 2: a repeated close must not write", stored.Version)
+	}
+}
+
+func TestIngredientConsumptionCountsClosedOrders(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	svc := st.orderService(nil)
+	for _, o := range []models.Order{
+		order("o1", line("latte", 2), line("espresso", 1)),
+		order("o2", line("milkshake", 1)),
+		order("o3", line("espresso", 4)),
+	} {
+		if err := svc.CreateOrder(ctx, o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"o1", "o2"} {
+		if err := svc.CloseOrder(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := svc.GetIngredientConsumption(ctx, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, c := range report {
+		got[c.IngredientID] = c.Quantity
+	}
+	want := map[string]float64{"espresso_shot": 3, "milk": 700, "sugar": 20}
+	if len(got) != len(want) {
+		t.Errorf("consumption = %v, want %v", got, want)
+	}
+	for id, quantity := range want {
+		if got[id] != quantity {
+			t.Errorf("consumption of %s = %v, want %v", id, got[id], quantity)
+		}
+	}
+}
+
+func TestIngredientConsumptionSkipsOnlyUnknownLines(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	svc := st.orderService(nil)
+	if err := svc.CreateOrder(ctx, order("o1", line("latte", 1), line("milkshake", 1))); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.CloseOrder(ctx, "o1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.menu.Delete(ctx, "milkshake", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := svc.GetIngredientConsumption(ctx, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, c := range report {
+		got[c.IngredientID] = c.Quantity
+	}
+	// The latte still counts; the milkshake's sugar and milk do not.
+	want := map[string]float64{"espresso_shot": 1, "milk": 200}
+	for id, quantity := range want {
+		if got[id] != quantity {
+			t.Errorf("consumption of %s = %v, want %v", id, got[id], quantity)
+		}
+	}
+	if got["sugar"] != 0 {
+		t.Errorf("consumption of sugar = %v, want 0", got["sugar"])
+	}
+}
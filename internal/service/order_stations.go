@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"hot-coffee/internal/logging"
+	"hot-coffee/internal/repository"
+	"hot-coffee/models"
+)
+
+// GetStations lists every station named on the menu, plus
+// models.UnassignedStation while it has lines waiting, with the number of
+// pending lines at each.
+func (s *OrderServ) GetStations(ctx context.Context) ([]models.Station, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("GetStations")
+	queues, menu, err := s.stationQueues(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending := make(map[string]int, len(queues))
+	for station, lines := range queues {
+		pending[station] = len(lines)
+	}
+	for _, item := range menu {
+		if _, ok := pending[item.Station]; !ok && item.Station != "" {
+			pending[item.Station] = 0
+		}
+	}
+	stations := make([]models.Station, 0, len(pending))
+	for station, n := range pending {
+		stations = append(stations, models.Station{Station: station, Pending: n})
+	}
+	sort.Slice(stations, func(i, j int) bool {
+		return stations[i].Station < stations[j].Station
+	})
+	logger.Info("GetStations result", slog.Int("count", len(stations)))
+	return stations, nil
+}
+
+// GetStationQueue returns the pending lines routed to station, oldest order
+// first. A station that is neither on the menu nor has lines is not found.
+func (s *OrderServ) GetStationQueue(ctx context.Context, station string) ([]models.StationLine, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("GetStationQueue", slog.String("station", station))
+	queues, menu, err := s.stationQueues(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lines, ok := queues[station]
+	if !ok {
+		known := station == models.UnassignedStation
+		for _, item := range menu {
+			known = known || item.Station == station
+		}
+		if !known {
+			return nil, &repository.NotFoundError{Entity: "station", ID: station}
+		}
+		lines = []models.StationLine{}
+	}
+	logger.Info("GetStationQueue result", slog.String("station", station), slog.Int("count", len(lines)))
+	return lines, nil
+}
+
+// stationQueues routes the pending lines of open orders to the station of
+// their menu item. Each queue is ordered by the order's creation time, then
+// by order ID and line.
+func (s *OrderServ) stationQueues(ctx context.Context) (map[string][]models.StationLine, []models.MenuItem, error) {
+	logger := logging.FromContext(ctx)
+	orders, err := s.orderRepo.FindAll(ctx)
+	if err != nil {
+		logger.Error("FindAll orders", slog.Any("error", err))
+		return nil, nil, err
+	}
+	menu, err := s.menuRepo.FindAll(ctx)
+	if err != nil {
+		logger.Error("FindAll menu", slog.Any("error", err))
+		return nil, nil, err
+	}
+	products := make(map[string]models.MenuItem, len(menu))
+	for _, item := range menu {
+		products[item.ID] = item
+	}
+
+	queues := make(map[string][]models.StationLine)
+	for _, order := range orders {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		if order.Status != "open" {
+			continue
+		}
+		for i, line := range order.Items {
+			if line.Status == models.LineDone {
+				continue
+			}
+			item := products[line.ProductID]
+			station := item.Station
+			if station == "" {
+				station = models.UnassignedStation
+			}
+			queues[station] = append(queues[station], models.StationLine{
+				OrderID:      order.ID,
+				Line:         i,
+				ProductID:    line.ProductID,
+				Name:         item.Name,
+				Quantity:     line.Quantity,
+				CustomerName: order.CustomerName,
+				CreatedAt:    order.CreatedAt,
+				Status:       models.LinePending,
+			})
+		}
+	}
+	for _, lines := range queues {
+		sort.SliceStable(lines, func(i, j int) bool {
+			a, b := lines[i], lines[j]
+			if a.CreatedAt != b.CreatedAt {
+				return a.CreatedAt < b.CreatedAt
+			}
+			if a.OrderID != b.OrderID {
+				return a.OrderID < b.OrderID
+			}
+			return a.Line < b.Line
+		})
+	}
+	return queues, menu, nil
+}
+
+// BumpOrderLine marks line of the order done. Once every line is done the
+// open order moves to "ready". Bumping a done line again changes nothing.
+func (s *OrderServ) BumpOrderLine(ctx context.Context, id string, line int, version int) (models.Order, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("BumpOrderLine", slog.String("order_id", id), slog.Int("line", line), slog.Int("version", version))
+	order, err := s.orderRepo.FindByID(ctx, id)
+	if err != nil {
+		logger.Error("FindByID", slog.String("order_id", id), slog.Any("error", err))
+		return models.Order{}, err
+	}
+	if version != 0 && version != order.Version {
+		logger.Warn("version mismatch", slog.String("order_id", id), slog.Int("expected", version), slog.Int("actual", order.Version))
+		return models.Order{}, models.ErrVersionMismatch
+	}
+	if line < 0 || line >= len(order.Items) {
+		return models.Order{}, &repository.NotFoundError{Entity: "order line", ID: id + "/" + strconv.Itoa(line)}
+	}
+	if order.Status == "closed" {
+		return models.Order{}, NewValidationError("status", "order "+id+" is closed")
+	}
+	if order.Items[line].Status == models.LineDone {
+		return *order, nil
+	}
+
+	previousStatus := order.Status
+	order.Items[line].Status = models.LineDone
+	order.Items[line].DoneAt = time.Now().UTC().Format(time.RFC3339)
+	settleStatus(order)
+	if err := s.orderRepo.Update(ctx, id, *order); err != nil {
+		logger.Error("Update order", slog.String("order_id", id), slog.Any("error", err))
+		return models.Order{}, err
+	}
+	logger.Info("Order line bumped", slog.String("order_id", id), slog.Int("line", line), slog.String("status", order.Status))
+	s.publishChange(ctx, previousStatus, id)
+	return s.GetOrderById(ctx, id)
+}
+
+// prepareLines marks lines without a status pending and rejects unknown
+// statuses.
+func prepareLines(verr *ValidationError, items []models.OrderItem) {
+	for i := range items {
+		switch items[i].Status {
+		case "":
+			items[i].Status = models.LinePending
+		case models.LinePending, models.LineDone:
+		default:
+			verr.Add(fmt.Sprintf("items[%d].status", i),
+				fmt.Sprintf("must be %s or %s", models.LinePending, models.LineDone))
+		}
+		if items[i].Status == models.LinePending {
+			items[i].DoneAt = ""
+		}
+	}
+}
+
+// settleStatus moves an open order whose lines are all done to "ready", and
+// a ready order that has pending lines again back to "open".
+func settleStatus(order *models.Order) {
+	done := len(order.Items) > 0
+	for _, line := range order.Items {
+		done = done && line.Status == models.LineDone
+	}
+	switch {
+	case order.Status == "open" && done:
+		order.Status = "ready"
+	case order.Status == "ready" && !done:
+		order.Status = "open"
+	}
+}
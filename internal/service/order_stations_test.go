@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"hot-coffee/internal/repository"
+	"hot-coffee/models"
+)
+
+func TestBumpOrderLine(t *testing.T) {
+	tests := []struct {
+		name       string
+		bumps      []int
+		closed     bool
+		wantErr    error
+		wantStatus string
+		wantQueues map[string]int
+	}{
+		{
+			name:       "one of two lines keeps the order open",
+			bumps:      []int{0},
+			wantStatus: "open",
+			wantQueues: map[string]int{"espresso_bar": 0, "cold_drinks": 1},
+		},
+		{
+			name:       "last line makes the order ready",
+			bumps:      []int{0, 1},
+			wantStatus: "ready",
+			wantQueues: map[string]int{"espresso_bar": 0, "cold_drinks": 0},
+		},
+		{
+			name:       "bumping a done line again changes nothing",
+			bumps:      []int{1, 1},
+			wantStatus: "open",
+			wantQueues: map[string]int{"espresso_bar": 1, "cold_drinks": 0},
+		},
+		{
+			name:       "unknown line",
+			bumps:      []int{2},
+			wantErr:    repository.ErrNotFound,
+			wantStatus: "open",
+			wantQueues: map[string]int{"espresso_bar": 1, "cold_drinks": 1},
+		},
+		{
+			name:       "closed order",
+			bumps:      []int{0},
+			closed:     true,
+			wantErr:    ErrValidation,
+			wantStatus: "closed",
+			wantQueues: map[string]int{"espresso_bar": 0, "cold_drinks": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestStore(t)
+			ctx := context.Background()
+			svc := st.orderService(nil)
+			if err := svc.CreateOrder(ctx, order("o1", line("latte", 1), line("milkshake", 1))); err != nil {
+				t.Fatal(err)
+			}
+			if tt.closed {
+				if err := svc.CloseOrder(ctx, "o1"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var err error
+			for _, i := range tt.bumps {
+				if _, err = svc.BumpOrderLine(ctx, "o1", i, 0); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("BumpOrderLine() error = %v, want %v", err, tt.wantErr)
+			}
+			got, err := svc.GetOrderById(ctx, "o1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", got.Status, tt.wantStatus)
+			}
+			for station, want := range tt.wantQueues {
+				queue, err := svc.GetStationQueue(ctx, station)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(queue) != want {
+					t.Errorf("%s queue has %d lines, want %d", station, len(queue), want)
+				}
+			}
+		})
+	}
+}
+
+func TestBumpOrderLineChecksVersion(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	svc := st.orderService(nil)
+	if err := svc.CreateOrder(ctx, order("o1", line("latte", 1))); err != nil {
+		t.Fatal(err)
+	}
+	created, err := svc.GetOrderById(ctx, "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.BumpOrderLine(ctx, "o1", 0, created.Version+1); !errors.Is(err, models.ErrVersionMismatch) {
+		t.Fatalf("BumpOrderLine() with a stale version error = %v, want %v", err, models.ErrVersionMismatch)
+	}
+	bumped, err := svc.BumpOrderLine(ctx, "o1", 0, created.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bumped.Items[0].Status != models.LineDone || bumped.Items[0].DoneAt == "" {
+		t.Errorf("line = %+v, want done with done_at", bumped.Items[0])
+	}
+}
+
+func TestUpdateOrderSettlesStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		bumpFirst  bool
+		update     []models.OrderItem
+		wantStatus string
+		wantEvent  string
+	}{
+		{
+			name:       "pending line added to a ready order reopens it",
+			bumpFirst:  true,
+			update:     []models.OrderItem{{ProductID: "latte", Quantity: 1, Status: models.LineDone}, line("espresso", 1)},
+			wantStatus: "open",
+			wantEvent:  "ready",
+		},
+		{
+			name:       "done line sent back to pending reopens it",
+			bumpFirst:  true,
+			update:     []models.OrderItem{{ProductID: "latte", Quantity: 1, Status: models.LinePending}},
+			wantStatus: "open",
+			wantEvent:  "ready",
+		},
+		{
+			name:       "every line done makes an open order ready",
+			update:     []models.OrderItem{{ProductID: "latte", Quantity: 1, Status: models.LineDone}},
+			wantStatus: "ready",
+			wantEvent:  "open",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestStore(t)
+			ctx := context.Background()
+			svc := st.orderService(nil)
+			if err := svc.CreateOrder(ctx, order("o1", line("latte", 1))); err != nil {
+				t.Fatal(err)
+			}
+			if tt.bumpFirst {
+				if _, err := svc.BumpOrderLine(ctx, "o1", 0, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var previous string
+			svc.events.Listen(func(_ context.Context, event models.OrderEvent) { previous = event.PreviousStatus })
+
+			if err := svc.UpdateOrder(ctx, "o1", order("o1", tt.update...)); err != nil {
+				t.Fatalf("UpdateOrder() error = %v", err)
+			}
+			got, err := svc.GetOrderById(ctx, "o1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", got.Status, tt.wantStatus)
+			}
+			if previous != tt.wantEvent {
+				t.Errorf("event previous_status = %q, want %q", previous, tt.wantEvent)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+
+	"hot-coffee/models"
+)
+
+// DeleteMode decides what deleting a record does to the records that still
+// reference it: recipes using an ingredient, orders containing a product,
+// orders linked to a customer.
+type DeleteMode string
+
+const (
+	// DeleteBlock refuses the delete with a DependentsError.
+	DeleteBlock DeleteMode = "block"
+	// DeleteCascade removes the references first: the ingredient from every
+	// recipe, the product's open orders, which are cancelled, or the
+	// customer link from their orders, which keep the customer name. Closed
+	// orders are sales history and block a cascade.
+	DeleteCascade DeleteMode = "cascade"
+	// DeleteArchive archives the record instead of deleting it, which keeps
+	// every reference intact. It is the safe choice for a product that
+	// appears in closed orders.
+	DeleteArchive DeleteMode = "archive"
+)
+
+// ParseDeleteMode parses the dependents query parameter; empty means block.
+func ParseDeleteMode(v string) (DeleteMode, error) {
+	switch DeleteMode(v) {
+	case "", DeleteBlock:
+		return DeleteBlock, nil
+	case DeleteCascade, DeleteArchive:
+		return DeleteMode(v), nil
+	}
+	return "", NewValidationError("dependents", "must be block, cascade or archive")
+}
+
+// OrderCanceller cancels an order that is not closed yet, returning its
+// reserved stock and publishing the cancellation, and restores a cancelled
+// order when a cascade has to be undone. OrderServ implements it.
+type OrderCanceller interface {
+	DeleteOrder(ctx context.Context, id string, version int) error
+	RestoreOrder(ctx context.Context, order models.Order) error
+}
+
+// recipesUsing returns the menu items whose recipe includes ingredientID.
+func recipesUsing(menuItems []models.MenuItem, ingredientID string) []models.MenuItem {
+	var users []models.MenuItem
+	for _, item := range menuItems {
+		for _, ingredient := range item.Ingredients {
+			if ingredient.IngredientID == ingredientID {
+				users = append(users, item)
+				break
+			}
+		}
+	}
+	return users
+}
+
+// ordersWith returns the orders with a line for productID.
+func ordersWith(orders []models.Order, productID string) []models.Order {
+	var found []models.Order
+	for _, order := range orders {
+		for _, line := range order.Items {
+			if line.ProductID == productID {
+				found = append(found, order)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// ordersFor returns the orders linked to customerID.
+func ordersFor(orders []models.Order, customerID string) []models.Order {
+	var found []models.Order
+	for _, order := range orders {
+		if order.CustomerID == customerID {
+			found = append(found, order)
+		}
+	}
+	return found
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"hot-coffee/internal/repository"
+	"hot-coffee/models"
+)
+
+func TestDeleteMenuItemModes(t *testing.T) {
+	tests := []struct {
+		name         string
+		closeOrder   bool
+		failDelete   bool
+		version      int
+		mode         DeleteMode
+		wantErr      error
+		wantDeleted  bool
+		wantArchived bool
+		wantOrder    bool
+		wantStock    map[string]float64
+	}{
+		{
+			name:      "block keeps product and order",
+			mode:      DeleteBlock,
+			wantErr:   ErrConflict,
+			wantOrder: true,
+			wantStock: map[string]float64{"espresso_shot": 9, "milk": 800},
+		},
+		{
+			name:        "cascade cancels open orders and returns their stock",
+			mode:        DeleteCascade,
+			wantDeleted: true,
+			wantStock:   map[string]float64{"espresso_shot": 10, "milk": 1000},
+		},
+		{
+			name:      "stale version cancels nothing",
+			mode:      DeleteCascade,
+			version:   99,
+			wantErr:   models.ErrVersionMismatch,
+			wantOrder: true,
+			wantStock: map[string]float64{"espresso_shot": 9, "milk": 800},
+		},
+		{
+			name:       "failed cascade restores the cancelled orders",
+			mode:       DeleteCascade,
+			failDelete: true,
+			wantErr:    errWriteFailed,
+			wantOrder:  true,
+			wantStock:  map[string]float64{"espresso_shot": 9, "milk": 800},
+		},
+		{
+			name:       "cascade never deletes closed orders",
+			closeOrder: true,
+			mode:       DeleteCascade,
+			wantErr:    ErrConflict,
+			wantOrder:  true,
+			wantStock:  map[string]float64{"espresso_shot": 9, "milk": 800},
+		},
+		{
+			name:         "archive keeps product and order",
+			closeOrder:   true,
+			mode:         DeleteArchive,
+			wantArchived: true,
+			wantOrder:    true,
+			wantStock:    map[string]float64{"espresso_shot": 9, "milk": 800},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestStore(t)
+			ctx := context.Background()
+			orders := st.orderService(nil)
+			if err := orders.CreateOrder(ctx, order("o1", line("latte", 1))); err != nil {
+				t.Fatal(err)
+			}
+			if tt.closeOrder {
+				if err := orders.CloseOrder(ctx, "o1"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var menuRepo repository.MenuRepository = st.menu
+			if tt.failDelete {
+				menuRepo = failingMenu{st.menu}
+			}
+			menu := NewMenuService(menuRepo, st.inventory, st.orders, orders)
+
+			err := menu.DeleteMenuItem(ctx, "latte", tt.version, tt.mode)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DeleteMenuItem() error = %v, want %v", err, tt.wantErr)
+			}
+			item, err := st.menu.FindByID(ctx, "latte")
+			var nf *repository.NotFoundError
+			switch {
+			case tt.wantDeleted && !errors.As(err, &nf):
+				t.Errorf("latte still on the menu, FindByID() error = %v", err)
+			case !tt.wantDeleted && err != nil:
+				t.Errorf("latte gone, FindByID() error = %v", err)
+			case !tt.wantDeleted && item.Archived != tt.wantArchived:
+				t.Errorf("latte archived = %v, want %v", item.Archived, tt.wantArchived)
+			}
+			if _, err := st.orders.FindByID(ctx, "o1"); (err == nil) != tt.wantOrder {
+				t.Errorf("order o1 kept = %v, want %v", err == nil, tt.wantOrder)
+			}
+			assertStock(t, st.stock(t), tt.wantStock)
+		})
+	}
+}
+
+// failingMenu fails menu deletes.
+type failingMenu struct {
+	repository.MenuRepository
+}
+
+func (failingMenu) Delete(context.Context, string, int) error { return errWriteFailed }
+
+func TestDeleteInventoryItemModes(t *testing.T) {
+	tests := []struct {
+		name        string
+		id          string
+		mode        DeleteMode
+		wantErr     error
+		wantDeleted bool
+		wantRecipes map[string][]string
+	}{
+		{
+			name:        "block keeps recipes",
+			id:          "milk",
+			mode:        DeleteBlock,
+			wantErr:     ErrConflict,
+			wantRecipes: map[string][]string{"latte": {"espresso_shot", "milk"}, "milkshake": {"milk", "sugar"}},
+		},
+		{
+			name:        "cascade removes the ingredient from recipes",
+			id:          "milk",
+			mode:        DeleteCascade,
+			wantDeleted: true,
+			wantRecipes: map[string][]string{"latte": {"espresso_shot"}, "milkshake": {"sugar"}},
+		},
+		{
+			name:        "cascade never leaves a recipe empty",
+			id:          "espresso_shot",
+			mode:        DeleteCascade,
+			wantErr:     ErrConflict,
+			wantRecipes: map[string][]string{"espresso": {"espresso_shot"}, "latte": {"espresso_shot", "milk"}},
+		},
+		{
+			name:        "archive keeps recipes",
+			id:          "espresso_shot",
+			mode:        DeleteArchive,
+			wantRecipes: map[string][]string{"espresso": {"espresso_shot"}, "latte": {"espresso_shot", "milk"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestStore(t)
+			ctx := context.Background()
+			inventory := NewInventoryService(st.inventory, st.menu, nopAlerter{})
+
+			err := inventory.DeleteInventoryItem(ctx, tt.id, 0, tt.mode)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DeleteInventoryItem() error = %v, want %v", err, tt.wantErr)
+			}
+			item, err := st.inventory.FindByID(ctx, tt.id)
+			switch {
+			case tt.wantDeleted && err == nil:
+				t.Errorf("%s still in inventory", tt.id)
+			case !tt.wantDeleted && err != nil:
+				t.Errorf("%s gone, FindByID() error = %v", tt.id, err)
+			case !tt.wantDeleted && item.Archived != (tt.mode == DeleteArchive):
+				t.Errorf("%s archived = %v", tt.id, item.Archived)
+			}
+			for id, want := range tt.wantRecipes {
+				item, err := st.menu.FindByID(ctx, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, ingredient := range item.Ingredients {
+					got = append(got, ingredient.IngredientID)
+				}
+				if len(got) != len(want) {
+					t.Errorf("recipe of %s = %v, want %v", id, got, want)
+					continue
+				}
+				for i := range want {
+					if got[i] != want[i] {
+						t.Errorf("recipe of %s = %v, want %v", id, got, want)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteCustomerRejectsArchive(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	customers := NewCustomerService(st.customers, st.orders, st.menu)
+	if _, err := customers.CreateCustomer(ctx, models.Customer{ID: "c1", Name: "Ana"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := customers.DeleteCustomer(ctx, "c1", 0, DeleteArchive); !errors.Is(err, ErrValidation) {
+		t.Fatalf("DeleteCustomer() error = %v, want %v", err, ErrValidation)
+	}
+	if _, err := st.customers.FindByID(ctx, "c1"); err != nil {
+		t.Errorf("customer deleted: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hot-coffee/internal/auth"
+	"hot-coffee/internal/logging"
+	"hot-coffee/internal/repository"
+	"hot-coffee/models"
+)
+
+// resetTokenTTL is how long a reset confirmation token stays valid.
+const resetTokenTTL = 5 * time.Minute
+
+var resetCollections = []string{"orders", "menu", "inventory", "customers"}
+
+type ResetService interface {
+	// Reset replaces the collections selected by req. The first call
+	// returns a ConfirmationRequiredError carrying a token; repeating the
+	// same request by the same user with that token performs the reset.
+	Reset(ctx context.Context, req models.ResetRequest, token string) (models.ResetResult, error)
+}
+
+type pendingReset struct {
+	scope     string
+	expiresAt time.Time
+}
+
+type resetServ struct {
+	repo        repository.SnapshotRepository
+	fixturesDir string
+
+	mu      sync.Mutex
+	pending map[string]pendingReset
+}
+
+// NewResetService returns a ResetService; fixturesDir may be empty, which
+// disables reseeding.
+func NewResetService(r repository.SnapshotRepository, fixturesDir string) ResetService {
+	return &resetServ{repo: r, fixturesDir: fixturesDir, pending: map[string]pendingReset{}}
+}
+
+func (s *resetServ) Reset(ctx context.Context, req models.ResetRequest, token string) (models.ResetResult, error) {
+	logger := logging.FromContext(ctx)
+	verr := &ValidationError{}
+	if len(req.Collections) == 0 {
+		req.Collections = resetCollections
+	}
+	for _, name := range req.Collections {
+		if !slices.Contains(resetCollections, name) {
+			verr.Add("collections", "unknown collection "+name+"; must be orders, menu, inventory or customers")
+		}
+	}
+	if req.Fixtures && s.fixturesDir == "" {
+		verr.Add("fixtures", "no fixtures directory is configured")
+	}
+	if err := verr.OrNil(); err != nil {
+		return models.ResetResult{}, err
+	}
+	// Keep the configured order and drop repeats so equal requests get
+	// equal scopes.
+	var collections []string
+	for _, name := range resetCollections {
+		if slices.Contains(req.Collections, name) {
+			collections = append(collections, name)
+		}
+	}
+	scope := auth.Actor(ctx) + "|" + strings.Join(collections, ",") + "|" + strconv.FormatBool(req.Fixtures)
+
+	if token == "" {
+		cerr, err := s.issue(scope, collections, req.Fixtures)
+		if err != nil {
+			return models.ResetResult{}, err
+		}
+		logger.Info("Reset: confirmation issued", slog.Any("collections", collections), slog.Bool("fixtures", req.Fixtures))
+		return models.ResetResult{}, cerr
+	}
+	if !s.redeem(token, scope) {
+		logger.Warn("Reset: invalid confirmation", slog.Any("collections", collections))
+		return models.ResetResult{}, NewValidationError("confirm", "token is unknown, expired or was issued for a different reset")
+	}
+
+	fixturesDir := ""
+	if req.Fixtures {
+		fixturesDir = s.fixturesDir
+	}
+	snap, err := s.repo.Reset(ctx, collections, fixturesDir)
+	if err != nil {
+		logger.Error("Reset failed", slog.Any("collections", collections), slog.Any("error", err))
+		return models.ResetResult{}, err
+	}
+	logger.Warn("Reset: collections replaced", slog.Any("collections", collections), slog.Bool("fixtures", req.Fixtures), slog.String("snapshot", snap.ID))
+	return models.ResetResult{Collections: collections, Fixtures: req.Fixtures, SnapshotID: snap.ID}, nil
+}
+
+func (s *resetServ) issue(scope string, collections []string, fixtures bool) (*ConfirmationRequiredError, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(b)
+	expiresAt := time.Now().Add(resetTokenTTL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	s.pending[token] = pendingReset{scope: scope, expiresAt: expiresAt}
+
+	action := "resetting " + strings.Join(collections, ", ")
+	if fixtures {
+		action += " from fixtures"
+	}
+	return &ConfirmationRequiredError{Action: action, Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// redeem consumes token if it is live and was issued for scope.
+func (s *resetServ) redeem(token, scope string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	p, ok := s.pending[token]
+	if !ok || p.scope != scope {
+		return false
+	}
+	delete(s.pending, token)
+	return true
+}
+
+// prune drops expired tokens; the caller holds s.mu.
+func (s *resetServ) prune() {
+	now := time.Now()
+	for token, p := range s.pending {
+		if now.After(p.expiresAt) {
+			delete(s.pending, token)
+		}
+	}
+}
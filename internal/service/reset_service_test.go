@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"hot-coffee/internal/auth"
+	"hot-coffee/internal/repository"
+	"hot-coffee/models"
+)
+
+// resetRecorder counts the resets that reach the repository.
+type resetRecorder struct {
+	repository.SnapshotRepository
+	resets int
+}
+
+func (r *resetRecorder) Reset(context.Context, []string, string) (models.Snapshot, error) {
+	r.resets++
+	return models.Snapshot{ID: "snap"}, nil
+}
+
+func asUser(name string) context.Context {
+	return auth.WithUser(context.Background(), models.User{Name: name, Role: models.RoleAdmin})
+}
+
+func TestResetConfirmation(t *testing.T) {
+	orders := models.ResetRequest{Collections: []string{"orders"}}
+	tests := []struct {
+		name    string
+		confirm context.Context
+		req     models.ResetRequest
+		expire  bool
+		wantErr error
+	}{
+		{name: "same user and request", confirm: asUser("ana"), req: orders},
+		{
+			name:    "repeated collection",
+			confirm: asUser("ana"),
+			req:     models.ResetRequest{Collections: []string{"orders", "orders"}},
+		},
+		{name: "another user", confirm: asUser("bo"), req: orders, wantErr: ErrValidation},
+		{
+			name:    "other collections",
+			confirm: asUser("ana"),
+			req:     models.ResetRequest{Collections: []string{"orders", "menu"}},
+			wantErr: ErrValidation,
+		},
+		{
+			name:    "from fixtures",
+			confirm: asUser("ana"),
+			req:     models.ResetRequest{Collections: []string{"orders"}, Fixtures: true},
+			wantErr: ErrValidation,
+		},
+		{name: "expired", confirm: asUser("ana"), req: orders, expire: true, wantErr: ErrValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &resetRecorder{}
+			svc := NewResetService(repo, t.TempDir()).(*resetServ)
+			_, err := svc.Reset(asUser("ana"), orders, "")
+			var cerr *ConfirmationRequiredError
+			if !errors.As(err, &cerr) {
+				t.Fatalf("Reset() without a token error = %v, want a confirmation", err)
+			}
+			if tt.expire {
+				p := svc.pending[cerr.Token]
+				p.expiresAt = time.Now().Add(-time.Second)
+				svc.pending[cerr.Token] = p
+			}
+
+			_, err = svc.Reset(tt.confirm, tt.req, cerr.Token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Reset() with the token error = %v, want %v", err, tt.wantErr)
+			}
+			want := 0
+			if tt.wantErr == nil {
+				want = 1
+			}
+			if repo.resets != want {
+				t.Errorf("repository reset %d times, want %d", repo.resets, want)
+			}
+		})
+	}
+}
+
+func TestResetTokenIsUsedOnce(t *testing.T) {
+	repo := &resetRecorder{}
+	svc := NewResetService(repo, "")
+	ctx := asUser("ana")
+	_, err := svc.Reset(ctx, models.ResetRequest{}, "")
+	var cerr *ConfirmationRequiredError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Reset() error = %v, want a confirmation", err)
+	}
+	if cerr.ExpiresAt.After(time.Now().Add(resetTokenTTL)) {
+		t.Errorf("token expires at %v, later than the TTL allows", cerr.ExpiresAt)
+	}
+	result, err := svc.Reset(ctx, models.ResetRequest{}, cerr.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Collections) != len(resetCollections) || result.SnapshotID != "snap" {
+		t.Errorf("result = %+v, want every collection and the snapshot", result)
+	}
+	if _, err := svc.Reset(ctx, models.ResetRequest{}, cerr.Token); !errors.Is(err, ErrValidation) {
+		t.Errorf("second Reset() with the token error = %v, want %v", err, ErrValidation)
+	}
+	if repo.resets != 1 {
+		t.Errorf("repository reset %d times, want 1", repo.resets)
+	}
+}
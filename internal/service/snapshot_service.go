@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+
+	"hot-coffee/internal/logging"
+	"hot-coffee/internal/repository"
+	"hot-coffee/models"
+)
+
+type SnapshotService interface {
+	// CreateSnapshot archives the orders, menu, inventory and customer
+	// files; reason is recorded with it, e.g. "manual" or "pre-reset".
+	CreateSnapshot(ctx context.Context, reason string) (models.Snapshot, error)
+	ListSnapshots(ctx context.Context) ([]models.Snapshot, error)
+	// RestoreSnapshot brings back the data files of the snapshot and
+	// returns the snapshot taken of the replaced data.
+	RestoreSnapshot(ctx context.Context, id string) (models.Snapshot, error)
+}
+
+type snapshotServ struct {
+	repo repository.SnapshotRepository
+}
+
+func NewSnapshotService(r repository.SnapshotRepository) SnapshotService {
+	return &snapshotServ{repo: r}
+}
+
+func (s *snapshotServ) CreateSnapshot(ctx context.Context, reason string) (models.Snapshot, error) {
+	logger := logging.FromContext(ctx)
+	if reason == "" {
+		reason = "manual"
+	}
+	snap, err := s.repo.Create(ctx, reason)
+	if err != nil {
+		logger.Error("CreateSnapshot failed", slog.String("reason", reason), slog.Any("error", err))
+		return models.Snapshot{}, err
+	}
+	logger.Info("CreateSnapshot", slog.String("id", snap.ID), slog.String("reason", reason))
+	return snap, nil
+}
+
+func (s *snapshotServ) ListSnapshots(ctx context.Context) ([]models.Snapshot, error) {
+	snaps, err := s.repo.FindAll(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error("ListSnapshots failed", slog.Any("error", err))
+		return nil, err
+	}
+	if snaps == nil {
+		snaps = []models.Snapshot{}
+	}
+	return snaps, nil
+}
+
+func (s *snapshotServ) RestoreSnapshot(ctx context.Context, id string) (models.Snapshot, error) {
+	logger := logging.FromContext(ctx)
+	before, err := s.repo.Restore(ctx, id)
+	if err != nil {
+		logger.Error("RestoreSnapshot failed", slog.String("id", id), slog.Any("error", err))
+		return before, err
+	}
+	logger.Warn("RestoreSnapshot: data files replaced", slog.String("id", id), slog.String("previous", before.ID))
+	return before, nil
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"hot-coffee/internal/auth"
+	"hot-coffee/internal/logging"
+	"hot-coffee/internal/repository"
+	"hot-coffee/models"
+)
+
+type UserService interface {
+	// CreateUser stores a new user and returns its API key, which is not
+	// kept anywhere and cannot be shown again.
+	CreateUser(ctx context.Context, name string, role models.Role) (string, error)
+	ListUsers(ctx context.Context) ([]models.User, error)
+	// Authenticate returns the user owning key, or ErrUnauthenticated.
+	Authenticate(ctx context.Context, key string) (models.User, error)
+}
+
+type userServ struct {
+	repo repository.UserRepository
+}
+
+func NewUserService(r repository.UserRepository) UserService {
+	return &userServ{repo: r}
+}
+
+func (s *userServ) CreateUser(ctx context.Context, name string, role models.Role) (string, error) {
+	logger := logging.FromContext(ctx)
+	verr := &ValidationError{}
+	if name == "" {
+		verr.Add("name", "name is empty")
+	}
+	if !role.Valid() {
+		verr.Add("role", "role must be cashier, manager or admin")
+	}
+	if err := verr.OrNil(); err != nil {
+		return "", err
+	}
+
+	key, err := auth.NewKey()
+	if err != nil {
+		logger.Error("CreateUser: NewKey failed", slog.Any("error", err))
+		return "", err
+	}
+	user := models.User{
+		Name:      name,
+		Role:      role,
+		KeyHash:   auth.HashKey(key),
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := s.repo.Add(ctx, user); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (s *userServ) ListUsers(ctx context.Context) ([]models.User, error) {
+	return s.repo.FindAll(ctx)
+}
+
+func (s *userServ) Authenticate(ctx context.Context, key string) (models.User, error) {
+	if key == "" {
+		return models.User{}, ErrUnauthenticated
+	}
+	users, err := s.repo.FindAll(ctx)
+	if err != nil {
+		return models.User{}, err
+	}
+	user, ok := auth.Match(users, key)
+	if !ok {
+		logging.FromContext(ctx).Warn("Authenticate: unknown API key")
+		return models.User{}, ErrUnauthenticated
+	}
+	return user, nil
+}
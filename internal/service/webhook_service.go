@@ -0,0 +1,422 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"hot-coffee/internal/auth"
+	"hot-coffee/internal/logging"
+	"hot-coffee/internal/metrics"
+	"hot-coffee/internal/repository"
+	"hot-coffee/models"
+)
+
+// Headers sent with every webhook delivery. The signature is
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)).
+const (
+	WebhookEventHeader     = "X-Hotcoffee-Event"
+	WebhookDeliveryHeader  = "X-Hotcoffee-Delivery"
+	WebhookTimestampHeader = "X-Hotcoffee-Timestamp"
+	WebhookSignatureHeader = "X-Hotcoffee-Signature"
+)
+
+type WebhookService interface {
+	CreateWebhook(ctx context.Context, hook models.Webhook) (models.Webhook, error)
+	ListWebhooks(ctx context.Context) ([]models.Webhook, error)
+	GetWebhook(ctx context.Context, id string) (models.Webhook, error)
+	DeleteWebhook(ctx context.Context, id string) error
+	ListDeliveries(ctx context.Context, webhookID, status string) ([]models.WebhookDelivery, error)
+}
+
+// WebhookOptions tune delivery; zero fields take the defaults.
+type WebhookOptions struct {
+	// Client sends the deliveries; by default with a 10s timeout.
+	Client *http.Client
+	// MaxAttempts is how often a delivery is tried before it fails (8).
+	MaxAttempts int
+	// RetryDelay is the wait after the first failed attempt (30s); it
+	// doubles with every further attempt up to MaxRetryDelay (1h).
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+}
+
+// WebhookServ manages webhook subscriptions and delivers events to them.
+// Deliveries are queued in the repository before they are sent, so retries
+// survive a restart; Run sends them.
+type WebhookServ struct {
+	repo repository.WebhookRepository
+	opts WebhookOptions
+	wake chan struct{}
+}
+
+func NewWebhookService(r repository.WebhookRepository, opts WebhookOptions) *WebhookServ {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 8
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 30 * time.Second
+	}
+	if opts.MaxRetryDelay <= 0 {
+		opts.MaxRetryDelay = time.Hour
+	}
+	return &WebhookServ{repo: r, opts: opts, wake: make(chan struct{}, 1)}
+}
+
+// CreateWebhook validates and stores hook under a new ID. Without a secret
+// one is generated; the result is the only place it is returned.
+func (s *WebhookServ) CreateWebhook(ctx context.Context, hook models.Webhook) (models.Webhook, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("CreateWebhook", slog.String("url", hook.URL), slog.Any("events", hook.Events))
+	verr := &ValidationError{}
+	if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		verr.Add("url", "must be an absolute http or https URL")
+	}
+	if len(hook.Events) == 0 {
+		verr.Add("events", "at least one event type is required")
+	}
+	for i, event := range hook.Events {
+		if !slices.Contains(models.WebhookEvents, event) {
+			verr.Add(fmt.Sprintf("events[%d]", i), fmt.Sprintf("unknown event type %q; must be one of %v", event, models.WebhookEvents))
+		}
+	}
+	if err := verr.OrNil(); err != nil {
+		return models.Webhook{}, err
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	hook.ID = "wh_" + id
+	if hook.Secret == "" {
+		if hook.Secret, err = randomHex(24); err != nil {
+			return models.Webhook{}, err
+		}
+	}
+	slices.Sort(hook.Events)
+	hook.Events = slices.Compact(hook.Events)
+	hook.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	hook.CreatedBy = auth.Actor(ctx)
+	if err := s.repo.Add(ctx, hook); err != nil {
+		logger.Error("Add webhook", slog.Any("error", err))
+		return models.Webhook{}, err
+	}
+	logger.Info("Webhook created", slog.String("webhook_id", hook.ID))
+	return hook, nil
+}
+
+func (s *WebhookServ) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("ListWebhooks")
+	hooks, err := s.repo.FindAll(ctx)
+	if err != nil {
+		logger.Error("FindAll webhooks", slog.Any("error", err))
+		return nil, err
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	if hooks == nil {
+		hooks = []models.Webhook{}
+	}
+	return hooks, nil
+}
+
+func (s *WebhookServ) GetWebhook(ctx context.Context, id string) (models.Webhook, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("GetWebhook", slog.String("webhook_id", id))
+	hook, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		logger.Error("FindByID webhook", slog.String("webhook_id", id), slog.Any("error", err))
+		return models.Webhook{}, err
+	}
+	hook.Secret = ""
+	return *hook, nil
+}
+
+// DeleteWebhook removes the subscription; its pending deliveries fail when
+// they come up.
+func (s *WebhookServ) DeleteWebhook(ctx context.Context, id string) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("DeleteWebhook", slog.String("webhook_id", id))
+	if err := s.repo.Delete(ctx, id); err != nil {
+		logger.Error("Delete webhook", slog.String("webhook_id", id), slog.Any("error", err))
+		return err
+	}
+	logger.Info("Webhook deleted", slog.String("webhook_id", id), slog.String("actor", auth.Actor(ctx)))
+	return nil
+}
+
+// ListDeliveries returns the delivery log of a webhook, newest first,
+// optionally only deliveries with the given status.
+func (s *WebhookServ) ListDeliveries(ctx context.Context, webhookID, status string) ([]models.WebhookDelivery, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("ListDeliveries", slog.String("webhook_id", webhookID), slog.String("status", status))
+	switch status {
+	case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryFailed:
+	default:
+		return nil, NewValidationError("status", "must be pending, delivered or failed")
+	}
+	if _, err := s.repo.FindByID(ctx, webhookID); err != nil {
+		return nil, err
+	}
+	deliveries, err := s.repo.FindDeliveries(ctx)
+	if err != nil {
+		logger.Error("FindDeliveries", slog.Any("error", err))
+		return nil, err
+	}
+	result := []models.WebhookDelivery{}
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		d := deliveries[i]
+		if d.WebhookID == webhookID && (status == "" || d.Status == status) {
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+
+// OrderEvent queues the order events webhooks can subscribe to; register it
+// with OrderEvents.Listen.
+func (s *WebhookServ) OrderEvent(ctx context.Context, event models.OrderEvent) {
+	switch event.Type {
+	case models.OrderCreated, models.OrderClosed, models.OrderCancelled:
+		s.publish(ctx, event.Type, event.Order)
+	}
+}
+
+// LowStock queues an inventory.low_stock event for item.
+func (s *WebhookServ) LowStock(ctx context.Context, item models.InventoryItem) {
+	s.publish(ctx, models.InventoryLowStock, item)
+}
+
+// publish queues a delivery of the event to every webhook subscribed to it.
+// The change it reports is already stored, so failures are only logged.
+func (s *WebhookServ) publish(ctx context.Context, eventType string, data any) {
+	logger := logging.FromContext(ctx)
+	hooks, err := s.repo.FindAll(ctx)
+	if err != nil {
+		logger.Error("Queue webhook event: FindAll webhooks", slog.String("event", eventType), slog.Any("error", err))
+		return
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		logger.Error("Queue webhook event", slog.String("event", eventType), slog.Any("error", err))
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	payload, err := json.Marshal(models.WebhookPayload{ID: "evt_" + id, Type: eventType, CreatedAt: now, Data: data})
+	if err != nil {
+		logger.Error("Queue webhook event: encode payload", slog.String("event", eventType), slog.Any("error", err))
+		return
+	}
+
+	var deliveries []models.WebhookDelivery
+	for _, hook := range hooks {
+		if !slices.Contains(hook.Events, eventType) {
+			continue
+		}
+		deliveryID, err := randomHex(8)
+		if err != nil {
+			logger.Error("Queue webhook event", slog.String("event", eventType), slog.Any("error", err))
+			return
+		}
+		deliveries = append(deliveries, models.WebhookDelivery{
+			ID:            "dlv_" + deliveryID,
+			WebhookID:     hook.ID,
+			Event:         eventType,
+			Payload:       payload,
+			Status:        models.DeliveryPending,
+			CreatedAt:     now,
+			NextAttemptAt: now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+	if err := s.repo.AddDeliveries(ctx, deliveries); err != nil {
+		logger.Error("Queue webhook event: AddDeliveries", slog.String("event", eventType), slog.Any("error", err))
+		return
+	}
+	logger.Info("Webhook event queued", slog.String("event", eventType), slog.String("event_id", "evt_"+id), slog.Int("deliveries", len(deliveries)))
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries until ctx is done, waking up when new ones are
+// queued and when the next retry is due. A delivery interrupted by ctx stays
+// pending and is sent after the next start.
+func (s *WebhookServ) Run(ctx context.Context) {
+	const idle = time.Minute
+	for {
+		next := s.deliverDue(ctx)
+		wait := idle
+		if !next.IsZero() {
+			wait = min(max(time.Until(next), 0), idle)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// deliverDue attempts every pending delivery whose time has come and
+// returns when the earliest remaining one is due, or zero if none is left.
+func (s *WebhookServ) deliverDue(ctx context.Context) time.Time {
+	deliveries, err := s.repo.FindDeliveries(ctx)
+	if err != nil {
+		slog.Error("Webhook dispatch: FindDeliveries", slog.Any("error", err))
+		return time.Time{}
+	}
+	var next time.Time
+	for _, d := range deliveries {
+		if d.Status != models.DeliveryPending {
+			continue
+		}
+		due, _ := time.Parse(time.RFC3339Nano, d.NextAttemptAt)
+		if due.After(time.Now()) {
+			if next.IsZero() || due.Before(next) {
+				next = due
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			return time.Time{}
+		}
+		if d = s.attempt(ctx, d); d.Status != models.DeliveryPending {
+			continue
+		}
+		due, _ = time.Parse(time.RFC3339Nano, d.NextAttemptAt)
+		if next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	return next
+}
+
+// attempt sends d once and records the outcome: delivered on a 2xx answer,
+// otherwise rescheduled with exponential backoff or failed after the last
+// attempt.
+func (s *WebhookServ) attempt(ctx context.Context, d models.WebhookDelivery) models.WebhookDelivery {
+	logger := slog.With(slog.String("delivery_id", d.ID), slog.String("webhook_id", d.WebhookID), slog.String("event", d.Event))
+	now := time.Now().UTC()
+	hook, err := s.repo.FindByID(ctx, d.WebhookID)
+	switch {
+	case err == nil:
+		d.ResponseStatus, err = s.send(ctx, *hook, d, now)
+		if ctx.Err() != nil {
+			// Shutting down; try again after the restart.
+			return d
+		}
+		d.Attempts++
+		d.LastAttemptAt = now.Format(time.RFC3339Nano)
+		d.Error = ""
+		if err != nil {
+			d.Error = err.Error()
+		}
+	case errors.Is(err, ErrNotFound):
+		d.Attempts = s.opts.MaxAttempts
+		d.Error = "webhook deleted"
+	default:
+		logger.Error("Webhook dispatch: FindByID", slog.Any("error", err))
+		return d
+	}
+
+	switch {
+	case err == nil:
+		d.Status = models.DeliveryDelivered
+		d.NextAttemptAt = ""
+		logger.Info("Webhook delivered", slog.Int("attempts", d.Attempts), slog.Int("status", d.ResponseStatus))
+		metrics.WebhookDeliveries.Inc(d.Event, "delivered")
+	case d.Attempts >= s.opts.MaxAttempts:
+		d.Status = models.DeliveryFailed
+		d.NextAttemptAt = ""
+		logger.Error("Webhook delivery failed", slog.Int("attempts", d.Attempts), slog.String("error", d.Error))
+		metrics.WebhookDeliveries.Inc(d.Event, "failed")
+	default:
+		d.NextAttemptAt = now.Add(s.backoff(d.Attempts)).Format(time.RFC3339Nano)
+		logger.Warn("Webhook delivery will be retried", slog.Int("attempts", d.Attempts), slog.String("error", d.Error), slog.String("next_attempt_at", d.NextAttemptAt))
+		metrics.WebhookDeliveries.Inc(d.Event, "retry")
+	}
+	if err := s.repo.UpdateDelivery(context.WithoutCancel(ctx), d); err != nil {
+		logger.Error("Webhook dispatch: UpdateDelivery", slog.Any("error", err))
+	}
+	return d
+}
+
+// backoff is the wait after the given number of failed attempts.
+func (s *WebhookServ) backoff(attempts int) time.Duration {
+	delay := s.opts.RetryDelay
+	for i := 1; i < attempts && delay < s.opts.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, s.opts.MaxRetryDelay)
+}
+
+// send posts the payload of d to hook and returns the response status. Any
+// status outside 2xx is an error.
+func (s *WebhookServ) send(ctx context.Context, hook models.Webhook, d models.WebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "hot-coffee-webhooks")
+	req.Header.Set(WebhookEventHeader, d.Event)
+	req.Header.Set(WebhookDeliveryHeader, d.ID)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(hook.Secret, timestamp, d.Payload))
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little so the connection can be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhook computes the signature header value for a payload; receivers
+// recompute it with their copy of the secret and compare.
+func SignWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"hot-coffee/internal/repository"
+	"hot-coffee/models"
+)
+
+// receiver is a webhook endpoint answering with the queued statuses, then
+// 200, and recording every request it gets.
+type receiver struct {
+	*httptest.Server
+	secret string
+
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedDelivery
+}
+
+type receivedDelivery struct {
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	t.Helper()
+	r := &receiver{secret: "s3cret", statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, receivedDelivery{header: req.Header.Clone(), body: body})
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() []receivedDelivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedDelivery(nil), r.requests...)
+}
+
+// subscribe registers r for low stock events with a new WebhookServ over dir.
+func (r *receiver) subscribe(t *testing.T, dir string, opts WebhookOptions) *WebhookServ {
+	t.Helper()
+	svc := NewWebhookService(repository.NewJSONWebhookRepo(dir), opts)
+	_, err := svc.CreateWebhook(context.Background(), models.Webhook{URL: r.URL, Events: []string{models.InventoryLowStock}, Secret: r.secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+func onlyDelivery(t *testing.T, svc *WebhookServ) models.WebhookDelivery {
+	t.Helper()
+	deliveries, err := svc.repo.FindDeliveries(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	return deliveries[0]
+}
+
+// deliverUntilSettled runs the dispatcher until no delivery is pending.
+func deliverUntilSettled(t *testing.T, svc *WebhookServ) {
+	t.Helper()
+	for range 50 {
+		next := svc.deliverDue(context.Background())
+		if next.IsZero() {
+			return
+		}
+		time.Sleep(time.Until(next))
+	}
+	t.Fatal("deliveries still pending")
+}
+
+func TestWebhookDeliveryIsSigned(t *testing.T) {
+	rcv := newReceiver(t)
+	svc := rcv.subscribe(t, t.TempDir(), WebhookOptions{})
+	svc.LowStock(context.Background(), models.InventoryItem{IngredientID: "milk", Quantity: 1})
+	deliverUntilSettled(t, svc)
+
+	got := rcv.received()
+	if len(got) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(got))
+	}
+	header := got[0].header
+	want := SignWebhook(rcv.secret, header.Get(WebhookTimestampHeader), got[0].body)
+	if sig := header.Get(WebhookSignatureHeader); sig != want {
+		t.Errorf("signature = %q, want %q", sig, want)
+	}
+	if SignWebhook("other", header.Get(WebhookTimestampHeader), got[0].body) == want {
+		t.Error("signature does not depend on the secret")
+	}
+	if event := header.Get(WebhookEventHeader); event != models.InventoryLowStock {
+		t.Errorf("event header = %q, want %q", event, models.InventoryLowStock)
+	}
+	if d := onlyDelivery(t, svc); d.Status != models.DeliveryDelivered || header.Get(WebhookDeliveryHeader) != d.ID {
+		t.Errorf("delivery %s is %s, want %s with the delivery header", d.ID, d.Status, models.DeliveryDelivered)
+	}
+}
+
+func TestWebhookDeliveryRetries(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	tests := []struct {
+		name         string
+		statuses     []int
+		maxAttempts  int
+		wantStatus   string
+		wantAttempts int
+		wantBackoffs []time.Duration
+	}{
+		{
+			name:         "retries 5xx with doubling delay",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusBadGateway},
+			maxAttempts:  5,
+			wantStatus:   models.DeliveryDelivered,
+			wantAttempts: 3,
+			wantBackoffs: []time.Duration{delay, 2 * delay},
+		},
+		{
+			name:         "fails after the last attempt",
+			statuses:     []int{500, 500, 500, 500},
+			maxAttempts:  3,
+			wantStatus:   models.DeliveryFailed,
+			wantAttempts: 3,
+			wantBackoffs: []time.Duration{delay, 2 * delay},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rcv := newReceiver(t, tt.statuses...)
+			svc := rcv.subscribe(t, t.TempDir(), WebhookOptions{MaxAttempts: tt.maxAttempts, RetryDelay: delay})
+			svc.LowStock(context.Background(), models.InventoryItem{IngredientID: "milk"})
+
+			var backoffs []time.Duration
+			for range 10 {
+				next := svc.deliverDue(context.Background())
+				d := onlyDelivery(t, svc)
+				if next.IsZero() {
+					break
+				}
+				last, _ := time.Parse(time.RFC3339Nano, d.LastAttemptAt)
+				due, _ := time.Parse(time.RFC3339Nano, d.NextAttemptAt)
+				backoffs = append(backoffs, due.Sub(last))
+				time.Sleep(time.Until(next))
+			}
+
+			d := onlyDelivery(t, svc)
+			if d.Status != tt.wantStatus || d.Attempts != tt.wantAttempts {
+				t.Errorf("delivery is %s after %d attempts, want %s after %d", d.Status, d.Attempts, tt.wantStatus, tt.wantAttempts)
+			}
+			if n := len(rcv.received()); n != tt.wantAttempts {
+				t.Errorf("receiver got %d requests, want %d", n, tt.wantAttempts)
+			}
+			if d.NextAttemptAt != "" {
+				t.Errorf("settled delivery still has next_attempt_at %s", d.NextAttemptAt)
+			}
+			if len(backoffs) != len(tt.wantBackoffs) {
+				t.Fatalf("backoffs = %v, want %v", backoffs, tt.wantBackoffs)
+			}
+			for i, want := range tt.wantBackoffs {
+				if backoffs[i] != want {
+					t.Errorf("backoff %d = %v, want %v", i+1, backoffs[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookPendingDeliverySurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	rcv := newReceiver(t, http.StatusServiceUnavailable)
+	before := rcv.subscribe(t, dir, WebhookOptions{RetryDelay: 10 * time.Millisecond})
+	before.LowStock(context.Background(), models.InventoryItem{IngredientID: "milk"})
+	if next := before.deliverDue(context.Background()); next.IsZero() {
+		t.Fatal("failed delivery was not rescheduled")
+	}
+
+	// A new service over the same directory stands in for the restarted
+	// server.
+	after := NewWebhookService(repository.NewJSONWebhookRepo(dir), WebhookOptions{RetryDelay: 10 * time.Millisecond})
+	if d := onlyDelivery(t, after); d.Status != models.DeliveryPending || d.Attempts != 1 {
+		t.Fatalf("after restart delivery is %s after %d attempts, want pending after 1", d.Status, d.Attempts)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		after.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for onlyDelivery(t, after).Status == models.DeliveryPending {
+		if time.Now().After(deadline) {
+			t.Fatal("pending delivery was not sent after restart")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if d := onlyDelivery(t, after); d.Status != models.DeliveryDelivered || d.Attempts != 2 {
+		t.Errorf("delivery is %s after %d attempts, want delivered after 2", d.Status, d.Attempts)
+	}
+}
@@ -0,0 +1,24 @@
+package models
+
+// Customer is a regular guest orders can be linked to. Walk-in orders need
+// no customer: they carry only a customer name.
+type Customer struct {
+	ID        string `json:"customer_id"`
+	Name      string `json:"name"`
+	Phone     string `json:"phone,omitempty"`
+	Email     string `json:"email,omitempty"`
+	Notes     string `json:"notes,omitempty"`
+	CreatedAt string `json:"created_at"`
+	Version   int    `json:"version"`
+	UpdatedBy string `json:"updated_by,omitempty"`
+}
+
+// CustomerHistory is a customer's orders, newest first, with the lifetime
+// spend and most ordered items over their closed orders.
+type CustomerHistory struct {
+	Customer       Customer      `json:"customer"`
+	Orders         []Order       `json:"orders"`
+	OrderCount     int           `json:"order_count"`
+	LifetimeSpend  float64       `json:"lifetime_spend"`
+	FavouriteItems []PopularItem `json:"favourite_items"`
+}
@@ -0,0 +1,7 @@
+package models
+
+import "errors"
+
+// ErrVersionMismatch is returned when an update or delete names a version
+// other than the one currently stored.
+var ErrVersionMismatch = errors.New("version mismatch")
@@ -0,0 +1,25 @@
+package models
+
+type IngredientConsumption struct {
+	IngredientID string             `json:"ingredient_id"`
+	Name         string             `json:"name"`
+	Unit         string             `json:"unit"`
+	Quantity     float64            `json:"quantity"`
+	DailyAverage float64            `json:"daily_average"`
+	Daily        []DailyConsumption `json:"daily"`
+}
+
+type DailyConsumption struct {
+	Date     string  `json:"date"`
+	Quantity float64 `json:"quantity"`
+}
+
+type InventoryForecast struct {
+	IngredientID     string   `json:"ingredient_id"`
+	Name             string   `json:"name"`
+	Unit             string   `json:"unit"`
+	Quantity         float64  `json:"quantity"`
+	DailyConsumption float64  `json:"daily_consumption"`
+	DaysRemaining    *float64 `json:"days_remaining"`
+	DepletionDate    string   `json:"depletion_date,omitempty"`
+}
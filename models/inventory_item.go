@@ -5,4 +5,10 @@ type InventoryItem struct {
 	Name         string  `json:"name"`
 	Quantity     float64 `json:"quantity"`
 	Unit         string  `json:"unit"`
+	// ReorderLevel is the stock below which the item counts as low; 0
+	// disables low-stock alerts for it.
+	ReorderLevel float64 `json:"reorder_level,omitempty"`
+	Version      int     `json:"version"`
+	Archived     bool    `json:"archived"`
+	UpdatedBy    string  `json:"updated_by,omitempty"`
 }
@@ -6,6 +6,12 @@ type MenuItem struct {
 	Description string               `json:"description"`
 	Price       float64              `json:"price"`
 	Ingredients []MenuItemIngredient `json:"ingredients"`
+	// Station is the prep station its order lines are routed to, e.g.
+	// "espresso_bar"; lines of items without one queue at UnassignedStation.
+	Station   string `json:"station,omitempty"`
+	Version   int    `json:"version"`
+	Archived  bool   `json:"archived"`
+	UpdatedBy string `json:"updated_by,omitempty"`
 }
 
 type MenuItemIngredient struct {
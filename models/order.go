@@ -5,14 +5,31 @@ import "errors"
 type Order struct {
 	ID           string      `json:"order_id"`
 	CustomerName string      `json:"customer_name"`
+	CustomerID   string      `json:"customer_id,omitempty"`
 	Items        []OrderItem `json:"items"`
 	Status       string      `json:"status"`
 	CreatedAt    string      `json:"created_at"`
+	Version      int         `json:"version"`
+	UpdatedBy    string      `json:"updated_by,omitempty"`
 }
 
 type OrderItem struct {
 	ProductID string `json:"product_id"`
 	Quantity  int    `json:"quantity"`
+	// UnitPrice is the product's menu price when the line was placed, so
+	// later price changes do not rewrite what the customer paid.
+	UnitPrice float64 `json:"unit_price"`
+	// Status is LinePending until staff at the station bump the line to
+	// LineDone. An order whose lines are all done moves from "open" to
+	// "ready".
+	Status string `json:"status"`
+	DoneAt string `json:"done_at,omitempty"`
 }
 
+// Order line statuses.
+const (
+	LinePending = "pending"
+	LineDone    = "done"
+)
+
 var ErrAlreadyExists = errors.New("order id already exists")
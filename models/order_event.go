@@ -0,0 +1,21 @@
+package models
+
+// Order event types published on GET /orders/stream.
+const (
+	OrderCreated       = "order.created"
+	OrderUpdated       = "order.updated"
+	OrderStatusChanged = "order.status_changed"
+	OrderClosed        = "order.closed"
+	OrderCancelled     = "order.cancelled"
+)
+
+// OrderEvent records a change of an order. Order is the order after the
+// change, or as it was before a cancellation; PreviousStatus is set when the
+// change moved the order to another status.
+type OrderEvent struct {
+	ID             string `json:"id"`
+	Type           string `json:"type"`
+	Time           string `json:"time"`
+	Order          Order  `json:"order"`
+	PreviousStatus string `json:"previous_status,omitempty"`
+}
@@ -0,0 +1,9 @@
+package models
+
+type PopularItem struct {
+	ProductID    string  `json:"product_id"`
+	Name         string  `json:"name"`
+	Quantity     int     `json:"quantity"`
+	Revenue      float64 `json:"revenue"`
+	ShareOfSales float64 `json:"share_of_sales"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// Page selects a window of a sorted list. A zero Limit means no limit;
+// an empty Sort keeps the order in which items are stored.
+type Page struct {
+	Offset int
+	Limit  int
+	Sort   string
+	Desc   bool
+}
+
+type OrderQuery struct {
+	Page
+	Status       string
+	CustomerName string
+	CustomerID   string
+	ProductID    string
+	From         time.Time
+	To           time.Time
+}
+
+type MenuQuery struct {
+	Page
+	Name     string
+	Archived ArchivedFilter
+}
+
+type InventoryQuery struct {
+	Page
+	Name     string
+	Unit     string
+	Archived ArchivedFilter
+}
+
+// ArchivedFilter selects list results by their archived flag. The zero
+// value lists active items only.
+type ArchivedFilter string
+
+const (
+	ArchivedExclude ArchivedFilter = ""
+	ArchivedInclude ArchivedFilter = "include"
+	ArchivedOnly    ArchivedFilter = "only"
+)
+
+// Match reports whether an item with the given archived flag passes f.
+func (f ArchivedFilter) Match(archived bool) bool {
+	switch f {
+	case ArchivedInclude:
+		return true
+	case ArchivedOnly:
+		return archived
+	}
+	return !archived
+}
@@ -0,0 +1,16 @@
+package models
+
+// ResetRequest selects the collections a reset replaces: "orders", "menu"
+// and "inventory", all of them when empty. With Fixtures they are reseeded
+// from the fixtures directory instead of emptied.
+type ResetRequest struct {
+	Collections []string `json:"collections"`
+	Fixtures    bool     `json:"fixtures"`
+}
+
+type ResetResult struct {
+	Collections []string `json:"collections"`
+	Fixtures    bool     `json:"fixtures"`
+	// SnapshotID names the snapshot of the data before the reset.
+	SnapshotID string `json:"snapshot_id"`
+}
@@ -0,0 +1,12 @@
+package models
+
+// Snapshot describes a point-in-time archive of the data files. Records
+// counts the records in each archived file.
+type Snapshot struct {
+	ID        string         `json:"id"`
+	CreatedAt string         `json:"created_at"`
+	Reason    string         `json:"reason"`
+	CreatedBy string         `json:"created_by,omitempty"`
+	Records   map[string]int `json:"records"`
+	Size      int64          `json:"size,omitempty"`
+}
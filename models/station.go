@@ -0,0 +1,24 @@
+package models
+
+// UnassignedStation queues the lines of menu items without a station.
+const UnassignedStation = "unassigned"
+
+// Station summarises the queue of a prep station.
+type Station struct {
+	Station string `json:"station"`
+	Pending int    `json:"pending"`
+}
+
+// StationLine is an order line in a station queue. Line is its index in the
+// order's items, which the bump endpoint takes.
+type StationLine struct {
+	OrderID      string `json:"order_id"`
+	Line         int    `json:"line"`
+	ProductID    string `json:"product_id"`
+	Name         string `json:"name"`
+	Quantity     int    `json:"quantity"`
+	CustomerName string `json:"customer_name"`
+	CreatedAt    string `json:"created_at"`
+	Status       string `json:"status"`
+	DoneAt       string `json:"done_at,omitempty"`
+}
@@ -0,0 +1,31 @@
+package models
+
+// Role grants access to a set of operations. Roles are ordered: each one
+// includes everything the roles before it may do.
+type Role string
+
+const (
+	RoleCashier Role = "cashier" // read everything, create, update and close orders
+	RoleManager Role = "manager" // also edit menu items and inventory
+	RoleAdmin   Role = "admin"   // also bulk imports and reset
+)
+
+var roleRank = map[Role]int{RoleCashier: 1, RoleManager: 2, RoleAdmin: 3}
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	return roleRank[r] != 0
+}
+
+// Allows reports whether r may perform operations that require required.
+func (r Role) Allows(required Role) bool {
+	return r.Valid() && roleRank[r] >= roleRank[required]
+}
+
+// User is an API client. Only the SHA-256 hash of its key is stored.
+type User struct {
+	Name      string `json:"name"`
+	Role      Role   `json:"role"`
+	KeyHash   string `json:"key_hash"`
+	CreatedAt string `json:"created_at"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import "encoding/json"
+
+// InventoryLowStock is sent when an update takes an inventory item below its
+// reorder level.
+const InventoryLowStock = "inventory.low_stock"
+
+// WebhookEvents are the event types webhooks can subscribe to.
+var WebhookEvents = []string{OrderCreated, OrderClosed, OrderCancelled, InventoryLowStock}
+
+// Webhook subscribes URL to events. Secret signs every payload; it is only
+// returned when the webhook is created.
+type Webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Secret    string   `json:"secret,omitempty"`
+	CreatedAt string   `json:"created_at"`
+	CreatedBy string   `json:"created_by,omitempty"`
+}
+
+// Webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event sent, or still to be sent, to one webhook.
+// Payload is the exact body posted.
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	WebhookID      string          `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	CreatedAt      string          `json:"created_at"`
+	NextAttemptAt  string          `json:"next_attempt_at,omitempty"`
+	LastAttemptAt  string          `json:"last_attempt_at,omitempty"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	Error          string          `json:"error,omitempty"`
+}
+
+// WebhookPayload is the JSON body of a delivery. ID identifies the event and
+// is the same for every webhook receiving it.
+type WebhookPayload struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	CreatedAt string `json:"created_at"`
+	Data      any    `json:"data"`
+}